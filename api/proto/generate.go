@@ -0,0 +1,8 @@
+// Package proto - protobuf описания gRPC API. Сгенерированный код лежит в api/gen,
+// после изменения .proto его нужно перегенерировать: go generate ./api/proto
+//
+// Описан только mm/v1/users.proto. accounts.proto и transactions.proto добавятся,
+// когда в сервисе появятся счета и транзакции, которые они будут отдавать.
+package proto
+
+//go:generate protoc -I . --go_out=../gen --go_opt=paths=source_relative --go-grpc_out=../gen --go-grpc_opt=paths=source_relative mm/v1/users.proto
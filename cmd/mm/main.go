@@ -2,39 +2,377 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	mmv1 "github.com/skinkvi/money_managment/api/gen/mm/v1"
+	"github.com/skinkvi/money_managment/internal/admin"
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/auth"
 	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/delta"
+	"github.com/skinkvi/money_managment/internal/grpcapi"
+	"github.com/skinkvi/money_managment/internal/health"
+	"github.com/skinkvi/money_managment/internal/household"
+	"github.com/skinkvi/money_managment/internal/idempotency"
+	"github.com/skinkvi/money_managment/internal/metrics"
+	"github.com/skinkvi/money_managment/internal/openapi"
+	"github.com/skinkvi/money_managment/internal/ratelimit"
+	"github.com/skinkvi/money_managment/internal/realtime"
+	"github.com/skinkvi/money_managment/internal/server"
 	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/tracing"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/migrations"
 	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/skinkvi/money_managment/pkg/mailer"
 )
 
 func main() {
-	cfg, err := config.MustLoadConfig("../../config/dev.yaml")
+	flags := flag.NewFlagSet("mm", flag.ExitOnError)
+	configFlag := flags.String("config", "", "путь к yaml конфигу, по умолчанию $"+config.PathEnv)
+	var overrides stringList
+	flags.Var(&overrides, "set", "перекрыть значение конфига, key=value, можно несколько раз")
+	_ = flags.Parse(os.Args[1:])
+
+	configOpts := config.Options{Path: config.Path(*configFlag), Overrides: overrides}
+	args := flags.Args()
+
+	if len(args) > 0 && args[0] == "config" {
+		os.Exit(configCommand(configOpts, args[1:]))
+	}
+
+	cfg, err := config.MustLoadConfig(configOpts)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log, err := logger.New(&cfg.Logger)
+	if len(args) > 0 && args[0] == "healthcheck" {
+		os.Exit(healthcheck(cfg, args[1:]))
+	}
+
+	log, err := logger.New(&cfg.Logger, logger.WithContextExtractor(tracing.LogFields))
 	if err != nil {
 		return
 	}
+	defer logger.Close(log)
+
+	// библиотеки, которые пишут в slog по умолчанию, попадают в тот же логгер
+	slog.SetDefault(slog.New(logger.NewSlogHandler(log)))
 
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	log.Info(ctx, "config load", logger.Field{
 		Key:   "cfg",
 		Value: cfg,
 	})
 
+	// компоненты с настройками, которые меняются на лету, подписываются на watcher
+	watcher := config.NewWatcher(configOpts, cfg)
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing, serviceName(cfg))
+	if err != nil {
+		log.Error(ctx, "cannot setup tracing", logger.Field{Key: "error", Value: err})
+		return
+	}
+	defer func() {
+		// ctx к этому моменту уже отменён, спанам нужно своё время на выгрузку
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			log.Error(shutdownCtx, "cannot flush traces", logger.Field{Key: "error", Value: err})
+		}
+	}()
+
 	db, err := storage.Connect(ctx, cfg.DataBase, log)
 	if err != nil {
 		return
 	}
+	defer db.Close()
+
+	reg := metrics.NewRegistry()
+	reg.MustRegister(db.Collectors()...)
 
-	// TODO: run migrations
-	// TODO: init Redis cache
-	// TODO: setup Gin router
-	// TODO: start HTTP server with graceful shutdown
+	rdb := storage.ConnectRedis(ctx, cfg.Redis, log)
+	defer rdb.Close()
+
+	// миграции накатывает tern до старта сервиса, здесь только проверяем версию схемы в readyz
+	latestMigration, err := migrations.Latest()
+	if err != nil {
+		log.Error(ctx, "cannot read migrations", logger.Field{Key: "error", Value: err})
+		return
+	}
+
+	checker := health.NewChecker()
+	checker.Add("postgres", db.Ping)
+	checker.Add("redis", func(ctx context.Context) error { return rdb.Ping(ctx).Err() })
+	checker.Add("migrations", health.Migrations(db.SchemaVersion, latestMigration))
+
+	mail, err := mailer.New(cfg.Mailer, log)
+	if err != nil {
+		log.Error(ctx, "cannot create mailer", logger.Field{Key: "error", Value: err})
+		return
+	}
+
+	auditRepo := audit.NewRepository(db, log)
+	userRepo := user.WithTracing(user.WithMetrics(user.NewUserRepository(db, auditRepo, log), metrics.NewRepository(reg)))
+	metrics.NewUsersGauge(reg, userRepo.Count)
+
+	// лимиты общие для всех экземпляров через Redis, пока он доступен
+	rateStore := ratelimit.NewFallbackStore(ratelimit.NewRedisStore(rdb), ratelimit.NewMemoryStore(), log)
+	limiter := ratelimit.NewLimiter(rateStore, cfg.RateLimit, log)
+	lockout := ratelimit.NewLockout(rateStore, cfg.RateLimit.Lockout)
+	watcher.Subscribe(func(change config.Change) {
+		if change.Changed("ratelimit") {
+			limiter.SetConfig(change.New.RateLimit)
+			lockout.SetConfig(change.New.RateLimit.Lockout)
+		}
+	})
 
+	var idemStore idempotency.Store
+	if strings.EqualFold(cfg.Idempotency.Store, "redis") {
+		idemStore = idempotency.NewRedisStore(rdb)
+	} else {
+		pgStore := idempotency.NewPostgresStore(db)
+		go pgStore.Cleanup(ctx, time.Hour, log)
+		idemStore = pgStore
+	}
+
+	authService, err := auth.NewService(userRepo, auth.NewStore(db, log), lockout, mail, cfg.Auth, log)
+	if err != nil {
+		log.Error(ctx, "cannot create auth service", logger.Field{Key: "error", Value: err})
+		return
+	}
+
+	adminService := admin.NewService(userRepo, authService, auditRepo, log)
+
+	spec, err := openapi.Load()
+	if err != nil {
+		log.Error(ctx, "cannot load openapi spec", logger.Field{Key: "error", Value: err})
+		return
+	}
+
+	router, err := server.NewRouter(cfg.App.Env, cfg.Server.TrustedProxies, log)
+	if err != nil {
+		log.Error(ctx, "cannot create router", logger.Field{Key: "error", Value: err})
+		return
+	}
+	router.Use(tracing.Middleware(), metrics.NewHTTP(reg).Middleware(), audit.Middleware(), limiter.Middleware(ratelimit.ByIP),
+		spec.Middleware(log), idempotency.Middleware(idemStore, cfg.Idempotency, log))
+	checker.Register(router)
+	spec.Register(router)
+	auth.NewHandler(authService, log).Register(router)
+
+	api := router.Group("", auth.RequireUser(authService, log), limiter.Middleware(ratelimit.ByUser))
+	user.NewHandler(userRepo, log).Register(api)
+	admin.NewHandler(adminService, log).Register(api)
+	audit.NewHandler(auditRepo, log).Register(api)
+
+	householdService, err := household.NewService(household.NewRepository(db, auditRepo, log), userRepo, mail, cfg.Auth, log)
+	if err != nil {
+		log.Error(ctx, "cannot create household service", logger.Field{Key: "error", Value: err})
+		return
+	}
+	household.NewHandler(householdService, log).Register(api.Group("", server.RequireFeature("households", watcher.Feature)))
+
+	syncService := delta.NewService(delta.NewLog(db), map[string]delta.Source{
+		user.SyncType: user.NewSyncSource(userRepo),
+	}, log)
+	delta.NewHandler(syncService, log).Register(api.Group("", server.RequireFeature("sync", watcher.Feature)))
+
+	var hub *realtime.Hub
+	if rt := cfg.Realtime; !rt.Disabled {
+		hub = realtime.NewHub(rt.MaxStreamsPerUser, log)
+		listener := storage.NewListener(db, storage.Backoff{Initial: rt.ReconnectBackoff, Max: rt.MaxReconnectBackoff}, log, realtime.Channel)
+		go listener.Run(ctx, hub.HandleNotification, hub.NotifyAll)
+		realtime.NewHandler(hub, syncService, rt.Heartbeat, log).Register(api)
+	}
+
+	if levels, ok := log.(logger.LevelController); ok {
+		admin.NewLogLevelHandler(levels, auditRepo, log).Register(api)
+	}
+
+	watcher.Subscribe(applyLoggerConfig(ctx, log))
+	go watchConfig(ctx, watcher, log)
+
+	srv := server.New(cfg, router, log)
+	srv.BeforeShutdown(checker.ShutDown)
+	if hub != nil {
+		srv.BeforeShutdown(hub.Close)
+	}
+
+	if cfg.Server.MetricsPort != 0 {
+		metricsSrv := server.NewMetrics(cfg, metrics.Handler(reg), log)
+		go func() {
+			if err := metricsSrv.Run(ctx); err != nil {
+				log.Error(ctx, "metrics server stopped with error", logger.Field{Key: "error", Value: err})
+			}
+		}()
+	}
+
+	// gRPC останавливается вместе с HTTP, main ждёт оба, прежде чем закрыть базу
+	grpcDone := make(chan struct{})
+	if cfg.GRPC.Port != 0 {
+		grpcSrv := grpcapi.New(cfg, authService, metrics.NewGRPC(reg), log)
+		mmv1.RegisterUserServiceServer(grpcSrv, grpcapi.NewUserService(userRepo, adminService, log))
+		go func() {
+			defer close(grpcDone)
+			if err := grpcSrv.Run(ctx); err != nil {
+				log.Error(ctx, "grpc server stopped with error", logger.Field{Key: "error", Value: err})
+			}
+		}()
+	} else {
+		close(grpcDone)
+	}
+
+	if err := srv.Run(ctx); err != nil {
+		log.Error(ctx, "http server stopped with error", logger.Field{Key: "error", Value: err})
+	}
+	// HTTP мог упасть сам, без сигнала
+	stop()
+	<-grpcDone
+}
+
+// configCommand - `mm config print [--redacted] [--sources]`: итоговый конфиг после
+// всех слоёв, с --sources - откуда взят каждый ключ. Ошибки проверки печатаются
+// в stderr после конфига.
+func configCommand(opts config.Options, args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: mm [--config path] [--set key=value] config print [--redacted] [--sources]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	redacted := fs.Bool("redacted", false, "скрыть секреты")
+	sources := fs.Bool("sources", false, "вместо значений показать, откуда они взяты")
+	_ = fs.Parse(args[1:])
+
+	cfg, src, err := config.Load(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *sources {
+		for _, key := range src.Keys() {
+			fmt.Printf("%s\t%s\n", key, src[key])
+		}
+	} else {
+		out, err := cfg.YAML(*redacted)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		os.Stdout.Write(out)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// stringList - флаг, который можно указать несколько раз.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// healthcheck - `mm healthcheck [--live]` для HEALTHCHECK контейнера: код выхода 0, если
+// сервер на этой машине готов (или с --live просто жив).
+func healthcheck(cfg *config.Config, args []string) int {
+	path := "/readyz"
+	if len(args) > 0 && args[0] == "--live" {
+		path = "/healthz"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.Server.Port)) + path
+	if err := health.Probe(ctx, url); err != nil {
+		fmt.Fprintln(os.Stderr, "unhealthy:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func serviceName(cfg *config.Config) string {
+	if cfg.App.Name != "" {
+		return cfg.App.Name
+	}
+
+	return "money-management"
+}
+
+// watchConfig перечитывает конфиг при изменении файла и по SIGHUP. Изменения
+// применяют подписчики watcher, здесь они только логируются.
+func watchConfig(ctx context.Context, w *config.Watcher, log logger.Logger) {
+	report := func(change config.Change, err error) {
+		if err != nil {
+			log.Error(ctx, "cannot reload config", logger.Field{Key: "error", Value: err})
+			return
+		}
+		if len(change.Keys) == 0 {
+			return
+		}
+
+		log.Info(ctx, "config reloaded", logger.Field{Key: "changed", Value: change.Keys})
+		if len(change.RestartRequired) > 0 {
+			log.Warn(ctx, "config changes require restart", logger.Field{Key: "keys", Value: change.RestartRequired})
+		}
+	}
+
+	go func() {
+		if err := w.Watch(ctx, report); err != nil {
+			log.Error(ctx, "cannot watch config file", logger.Field{Key: "error", Value: err})
+		}
+	}()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			report(w.Reload())
+		}
+	}
+}
+
+// applyLoggerConfig применяет logger.level и logger.encoding из перечитанного конфига.
+func applyLoggerConfig(ctx context.Context, log logger.Logger) func(config.Change) {
+	return func(change config.Change) {
+		if levels, ok := log.(logger.LevelController); ok && change.Changed("logger.level") {
+			if err := levels.SetLevel(change.New.Logger.Level); err != nil {
+				log.Error(ctx, "cannot apply log level", logger.Field{Key: "error", Value: err})
+			}
+		}
+
+		if enc, ok := log.(logger.EncodingController); ok && change.Changed("logger.encoding") {
+			if err := enc.SetEncoding(change.New.Logger.Encoding); err != nil {
+				log.Error(ctx, "cannot apply log encoding", logger.Field{Key: "error", Value: err})
+			}
+		}
+	}
 }
@@ -0,0 +1,175 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+type Handler struct {
+	svc *Service
+	log logger.Logger
+}
+
+func NewHandler(svc *Service, log logger.Logger) *Handler {
+	return &Handler{svc: svc, log: log}
+}
+
+// Register ожидает, что r уже требует аутентификацию.
+func (h *Handler) Register(r gin.IRouter) {
+	g := r.Group("/admin")
+	g.GET("/users", rbac.RequirePermission(rbac.PermUsersRead), h.listUsers)
+	g.POST("/users/:id/disable", rbac.RequirePermission(rbac.PermUsersWrite), h.disableUser)
+	g.POST("/users/:id/enable", rbac.RequirePermission(rbac.PermUsersWrite), h.enableUser)
+	g.PUT("/users/:id/role", rbac.RequirePermission(rbac.PermUsersWrite), h.setRole)
+	g.POST("/users/:id/impersonate", rbac.RequirePermission(rbac.PermUsersImpersonate), h.impersonate)
+}
+
+type roleRequest struct {
+	Role rbac.Role `json:"role" binding:"required"`
+}
+
+func (h *Handler) listUsers(c *gin.Context) {
+	limit, offset, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	users, total, err := h.svc.ListUsers(c.Request.Context(), rbac.PrincipalFrom(c), limit, offset)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	items := make([]user.Response, 0, len(users))
+	for i := range users {
+		items = append(items, user.NewResponse(&users[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": items, "total": total})
+}
+
+func (h *Handler) disableUser(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+
+	u, err := h.svc.DisableUser(c.Request.Context(), rbac.PrincipalFrom(c), id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.NewResponse(u))
+}
+
+func (h *Handler) enableUser(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+
+	u, err := h.svc.EnableUser(c.Request.Context(), rbac.PrincipalFrom(c), id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.NewResponse(u))
+}
+
+func (h *Handler) setRole(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+
+	var req roleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, err := h.svc.SetRole(c.Request.Context(), rbac.PrincipalFrom(c), id, req.Role)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.NewResponse(u))
+}
+
+func (h *Handler) impersonate(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+
+	session, err := h.svc.Impersonate(c.Request.Context(), rbac.PrincipalFrom(c), id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": session.Token, "expires_at": session.ExpiresAt})
+}
+
+func (h *Handler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, ErrSelfAction), errors.Is(err, rbac.ErrForbidden):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidRole):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.log.Error(c.Request.Context(), "admin handler failed", logger.Field{Key: "error", Value: err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func pathID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return 0, false
+	}
+
+	return id, true
+}
+
+func pagination(c *gin.Context) (int, int, bool) {
+	limit, offset := defaultLimit, 0
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxLimit {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return 0, 0, false
+		}
+		limit = n
+	}
+
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+			return 0, 0, false
+		}
+		offset = n
+	}
+
+	return limit, offset, true
+}
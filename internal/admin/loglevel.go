@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+const ActionSetLogLevel = "admin.log_level"
+
+// LogLevelHandler меняет уровень логирования работающего сервиса.
+type LogLevelHandler struct {
+	levels logger.LevelController
+	audit  audit.Repository
+	log    logger.Logger
+}
+
+func NewLogLevelHandler(levels logger.LevelController, auditRepo audit.Repository, log logger.Logger) *LogLevelHandler {
+	return &LogLevelHandler{levels: levels, audit: auditRepo, log: log}
+}
+
+// Register ожидает, что r уже требует аутентификацию.
+func (h *LogLevelHandler) Register(r gin.IRouter) {
+	g := r.Group("/admin", rbac.RequirePermission(rbac.PermSystemManage))
+	g.GET("/log-level", h.get)
+	g.PUT("/log-level", h.set)
+}
+
+type logLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+func (h *LogLevelHandler) get(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"level": h.levels.Level()})
+}
+
+func (h *LogLevelHandler) set(c *gin.Context) {
+	var req logLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	before := h.levels.Level()
+
+	if err := h.levels.SetLevel(req.Level); err != nil {
+		if errors.Is(err, logger.ErrInvalidLevel) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.log.Error(ctx, "failed to set log level", logger.Field{Key: "error", Value: err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	h.log.Warn(ctx, "log level changed",
+		logger.Field{Key: "from", Value: before},
+		logger.Field{Key: "to", Value: h.levels.Level()})
+
+	// уровень уже поменян, ошибка журнала не повод отвечать клиенту ошибкой
+	if err := h.audit.Append(ctx, audit.Entry{
+		Action:     ActionSetLogLevel,
+		EntityType: "system",
+		EntityID:   "log_level",
+		Diff:       map[string]audit.Change{"level": {Before: before, After: h.levels.Level()}},
+	}); err != nil {
+		h.log.Error(ctx, "failed to audit log level change", logger.Field{Key: "error", Value: err})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"level": h.levels.Level()})
+}
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/auth"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// Действия админки, которые сами данные не меняют. Блокировка и смена роли
+// попадают в журнал из user.Repository вместе с изменением.
+const (
+	ActionListUsers   = "admin.users.list"
+	ActionImpersonate = "admin.users.impersonate"
+)
+
+var (
+	ErrSelfAction  = errors.New("administrators cannot apply this action to themselves")
+	ErrInvalidRole = errors.New("invalid role")
+)
+
+// Sessions - то, что админке нужно от auth.Service.
+type Sessions interface {
+	Impersonate(ctx context.Context, adminID, targetID int64) (*auth.Session, error)
+	RevokeUserSessions(ctx context.Context, userID int64) error
+	Logout(ctx context.Context, token string) error
+}
+
+type Service struct {
+	users    user.Repository
+	sessions Sessions
+	audit    audit.Repository
+	log      logger.Logger
+}
+
+func NewService(users user.Repository, sessions Sessions, auditRepo audit.Repository, log logger.Logger) *Service {
+	return &Service{users: users, sessions: sessions, audit: auditRepo, log: log}
+}
+
+func (s *Service) ListUsers(ctx context.Context, actor *rbac.Principal, limit, offset int) ([]user.User, int64, error) {
+	users, err := s.users.List(ctx, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := s.users.Count(ctx)
+	if err != nil && !errors.Is(err, storage.ErrNoUsers) {
+		return nil, 0, err
+	}
+
+	if err := s.record(ctx, actor, ActionListUsers, "", map[string]any{"limit": limit, "offset": offset}); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// DisableUser блокирует пользователя и сразу завершает все его сессии.
+func (s *Service) DisableUser(ctx context.Context, actor *rbac.Principal, id int64) (*user.User, error) {
+	if actor.UserID == id {
+		return nil, ErrSelfAction
+	}
+
+	u, err := s.users.SetDisabled(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sessions.RevokeUserSessions(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (s *Service) EnableUser(ctx context.Context, actor *rbac.Principal, id int64) (*user.User, error) {
+	return s.users.SetDisabled(ctx, id, false)
+}
+
+// SetRole не даёт админу понизить самого себя, чтобы не остаться без администраторов по ошибке.
+func (s *Service) SetRole(ctx context.Context, actor *rbac.Principal, id int64, role rbac.Role) (*user.User, error) {
+	if !role.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+
+	if actor.UserID == id {
+		return nil, ErrSelfAction
+	}
+
+	return s.users.SetRole(ctx, id, role)
+}
+
+// Impersonate - сессии без записи в журнале быть не должно: если запись не
+// удалась, только что созданная сессия отзывается.
+func (s *Service) Impersonate(ctx context.Context, actor *rbac.Principal, id int64) (*auth.Session, error) {
+	session, err := s.sessions.Impersonate(ctx, actor.UserID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.record(ctx, actor, ActionImpersonate, strconv.FormatInt(id, 10), map[string]any{"expires_at": session.ExpiresAt})
+	if err != nil {
+		if revokeErr := s.sessions.Logout(ctx, session.Token); revokeErr != nil {
+			s.log.Error(ctx, "cannot revoke unaudited impersonation session",
+				logger.Field{Key: "error", Value: revokeErr},
+				logger.Field{Key: "admin_id", Value: actor.UserID},
+				logger.Field{Key: "user_id", Value: id})
+		}
+		return nil, err
+	}
+
+	s.log.Warn(ctx, "admin impersonates user",
+		logger.Field{Key: "admin_id", Value: actor.UserID},
+		logger.Field{Key: "user_id", Value: id})
+
+	return session, nil
+}
+
+// record - параметры действия пишутся в diff как значения "после".
+func (s *Service) record(ctx context.Context, actor *rbac.Principal, action, userID string, details map[string]any) error {
+	diff := make(map[string]audit.Change, len(details))
+	for k, v := range details {
+		diff[k] = audit.Change{After: v}
+	}
+
+	return s.audit.Append(ctx, audit.Entry{
+		ActorID:    &actor.UserID,
+		Action:     action,
+		EntityType: user.AuditEntity,
+		EntityID:   userID,
+		Diff:       diff,
+	})
+}
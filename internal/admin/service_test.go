@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/auth"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+type fakeUsers struct {
+	user.Repository
+	users map[int64]*user.User
+}
+
+func (f *fakeUsers) SetDisabled(ctx context.Context, id int64, disabled bool) (*user.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	u.DisabledAt = nil
+	if disabled {
+		now := time.Now()
+		u.DisabledAt = &now
+	}
+	return u, nil
+}
+
+func (f *fakeUsers) SetRole(ctx context.Context, id int64, role rbac.Role) (*user.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	u.Role = role
+	return u, nil
+}
+
+type fakeSessions struct {
+	revoked []int64
+	// отозванные по одной сессии
+	loggedOut []string
+}
+
+func (f *fakeSessions) Impersonate(ctx context.Context, adminID, targetID int64) (*auth.Session, error) {
+	return &auth.Session{Token: "token", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (f *fakeSessions) RevokeUserSessions(ctx context.Context, userID int64) error {
+	f.revoked = append(f.revoked, userID)
+	return nil
+}
+
+func (f *fakeSessions) Logout(ctx context.Context, token string) error {
+	f.loggedOut = append(f.loggedOut, token)
+	return nil
+}
+
+type memAudit struct {
+	audit.Repository
+	entries []audit.Entry
+}
+
+func (m *memAudit) Append(ctx context.Context, e audit.Entry) error {
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+func TestService_DisableUser(t *testing.T) {
+	users := &fakeUsers{users: map[int64]*user.User{
+		1: {ID: 1, Role: rbac.RoleAdmin},
+		2: {ID: 2, Role: rbac.RoleUser},
+	}}
+	sessions := &fakeSessions{}
+	svc := NewService(users, sessions, &memAudit{}, nopLogger{})
+	admin := &rbac.Principal{UserID: 1, Role: rbac.RoleAdmin}
+	ctx := context.Background()
+
+	u, err := svc.DisableUser(ctx, admin, 2)
+	require.NoError(t, err)
+	require.NotNil(t, u.DisabledAt)
+	require.Equal(t, []int64{2}, sessions.revoked)
+
+	_, err = svc.DisableUser(ctx, admin, 1)
+	require.ErrorIs(t, err, ErrSelfAction)
+
+	_, err = svc.DisableUser(ctx, admin, 3)
+	require.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func TestService_SetRole(t *testing.T) {
+	users := &fakeUsers{users: map[int64]*user.User{
+		1: {ID: 1, Role: rbac.RoleAdmin},
+		2: {ID: 2, Role: rbac.RoleUser},
+	}}
+	svc := NewService(users, &fakeSessions{}, &memAudit{}, nopLogger{})
+	admin := &rbac.Principal{UserID: 1, Role: rbac.RoleAdmin}
+	ctx := context.Background()
+
+	_, err := svc.SetRole(ctx, admin, 2, "root")
+	require.ErrorIs(t, err, ErrInvalidRole)
+
+	_, err = svc.SetRole(ctx, admin, 1, rbac.RoleUser)
+	require.ErrorIs(t, err, ErrSelfAction)
+
+	u, err := svc.SetRole(ctx, admin, 2, rbac.RoleSupportReadonly)
+	require.NoError(t, err)
+	require.Equal(t, rbac.RoleSupportReadonly, u.Role)
+}
+
+func TestService_ImpersonateIsAudited(t *testing.T) {
+	rec := &memAudit{}
+	svc := NewService(&fakeUsers{}, &fakeSessions{}, rec, nopLogger{})
+	admin := &rbac.Principal{UserID: 1, Role: rbac.RoleAdmin}
+
+	session, err := svc.Impersonate(context.Background(), admin, 2)
+	require.NoError(t, err)
+
+	require.Len(t, rec.entries, 1)
+	e := rec.entries[0]
+	require.Equal(t, ActionImpersonate, e.Action)
+	require.Equal(t, int64(1), *e.ActorID)
+	require.Equal(t, "user", e.EntityType)
+	require.Equal(t, "2", e.EntityID)
+	require.Equal(t, session.ExpiresAt, e.Diff["expires_at"].After)
+}
+
+type failingAudit struct {
+	audit.Repository
+}
+
+func (failingAudit) Append(ctx context.Context, e audit.Entry) error {
+	return errors.New("audit is down")
+}
+
+func TestService_ImpersonateRevokedWithoutAudit(t *testing.T) {
+	sessions := &fakeSessions{}
+	svc := NewService(&fakeUsers{}, sessions, failingAudit{}, nopLogger{})
+
+	session, err := svc.Impersonate(context.Background(), &rbac.Principal{UserID: 1, Role: rbac.RoleAdmin}, 2)
+	require.Error(t, err)
+	require.Nil(t, session)
+	require.Equal(t, []string{"token"}, sessions.loggedOut)
+}
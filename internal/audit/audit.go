@@ -0,0 +1,94 @@
+// Package audit - журнал всех изменений данных: кто, что, когда и с какого адреса поменял.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+type Entry struct {
+	ID int64 `json:"id"`
+	// nil для действий без аутентификации, например регистрации
+	ActorID        *int64            `json:"actor_id,omitempty"`
+	ImpersonatorID *int64            `json:"impersonator_id,omitempty"`
+	Action         string            `json:"action"`
+	EntityType     string            `json:"entity_type"`
+	EntityID       string            `json:"entity_id"`
+	Diff           map[string]Change `json:"diff,omitempty"`
+	RequestID      string            `json:"request_id,omitempty"`
+	IP             string            `json:"ip,omitempty"`
+	CreateAt       time.Time         `json:"created_at"`
+}
+
+// Meta - данные запроса, которые попадают в каждую запись, сделанную в его рамках.
+type Meta struct {
+	RequestID string
+	IP        string
+}
+
+type metaCtxKey struct{}
+
+func WithMeta(ctx context.Context, m Meta) context.Context {
+	return context.WithValue(ctx, metaCtxKey{}, m)
+}
+
+func MetaFromContext(ctx context.Context) Meta {
+	m, _ := ctx.Value(metaCtxKey{}).(Meta)
+	return m
+}
+
+const redacted = "[redacted]"
+
+// Diff сравнивает два снимка сущности и возвращает изменившиеся поля.
+// nil before - создание, nil after - удаление. Для полей из redact
+// значения заменяются на "[redacted]", остаётся только факт изменения.
+func Diff(before, after map[string]any, redact ...string) map[string]Change {
+	diff := make(map[string]Change)
+
+	for k, b := range before {
+		a, ok := after[k]
+		if !ok || !equal(a, b) {
+			diff[k] = Change{Before: b, After: a}
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			diff[k] = Change{Before: nil, After: a}
+		}
+	}
+
+	for _, k := range redact {
+		if c, ok := diff[k]; ok {
+			diff[k] = Change{Before: mask(c.Before), After: mask(c.After)}
+		}
+	}
+
+	return diff
+}
+
+// equal сравнивает значения так, как они будут выглядеть в jsonb:
+// *time.Time и time.Time с одинаковым моментом считаются равными.
+func equal(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+
+	return string(ja) == string(jb)
+}
+
+func mask(v any) any {
+	if v == nil {
+		return nil
+	}
+
+	return redacted
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+func TestDiff(t *testing.T) {
+	now := time.Now()
+	same := now
+
+	before := map[string]any{"username": "dima", "email": "a@example.com", "passhash": "old", "verified_at": &now}
+	after := map[string]any{"username": "dima", "email": "b@example.com", "passhash": "new", "verified_at": &same}
+
+	require.Equal(t, map[string]Change{
+		"email":    {Before: "a@example.com", After: "b@example.com"},
+		"passhash": {Before: "[redacted]", After: "[redacted]"},
+	}, Diff(before, after, "passhash"))
+
+	// создание: before пустой
+	require.Equal(t, map[string]Change{
+		"username": {Before: nil, After: "dima"},
+	}, Diff(nil, map[string]any{"username": "dima"}))
+
+	require.Empty(t, Diff(before, before))
+}
+
+func TestRepository_RecordTakesActorFromContext(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	repo := NewRepository(&storage.DB{Pool: mock}, nopLogger{})
+
+	ctx := rbac.WithPrincipal(context.Background(), &rbac.Principal{UserID: 2, Role: rbac.RoleUser, ImpersonatorID: 1})
+	ctx = WithMeta(ctx, Meta{RequestID: "req-1", IP: "10.0.0.1"})
+
+	actor, impersonator := int64(2), int64(1)
+	mock.ExpectExec(regexp.QuoteMeta(`insert into audit_log`)).
+		WithArgs(&actor, &impersonator, "user.update", "user", "2", []byte(`{}`), "req-1", "10.0.0.1").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	require.NoError(t, repo.Append(ctx, Entry{Action: "user.update", EntityType: "user", EntityID: "2"}))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRepository_ListReadsMigratedAdminEntries(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	repo := NewRepository(&storage.DB{Pool: mock}, nopLogger{})
+
+	// так 006_audit_log переносит details из admin_audit_log: {"limit":50,"offset":0}
+	actor := int64(1)
+	created := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta(`where actor_id = $1`)).
+		WithArgs(int64(1), 10, 0).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "actor_id", "impersonator_id", "action", "entity_type", "entity_id", "diff", "request_id", "ip", "create_at"}).
+			AddRow(int64(7), &actor, (*int64)(nil), "admin.users.list", "user", "",
+				[]byte(`{"limit": {"after": 50, "before": null}, "offset": {"after": 0, "before": null}}`), "", "", created))
+
+	entries, err := repo.ListByActor(context.Background(), 1, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, map[string]Change{"limit": {After: 50.0}, "offset": {After: 0.0}}, entries[0].Diff)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+type fakeRepo struct {
+	Repository
+}
+
+func (fakeRepo) ListByEntity(ctx context.Context, entityType, entityID string, limit, offset int) ([]Entry, error) {
+	return []Entry{}, nil
+}
+
+func TestHandler_HistoryHidesOtherUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	serve := func(p *rbac.Principal, target string) int {
+		r := gin.New()
+		r.Use(func(c *gin.Context) { rbac.SetPrincipal(c, p) })
+		NewHandler(fakeRepo{}, nopLogger{}).Register(r)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec.Code
+	}
+
+	user := &rbac.Principal{UserID: 1, Role: rbac.RoleUser}
+	require.Equal(t, http.StatusOK, serve(user, "/audit/user/1"))
+	// чужая история неотличима от несуществующей
+	require.Equal(t, http.StatusNotFound, serve(user, "/audit/user/2"))
+	require.Equal(t, http.StatusOK, serve(&rbac.Principal{UserID: 1, Role: rbac.RoleSupportReadonly}, "/audit/user/2"))
+}
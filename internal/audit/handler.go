@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// Middleware сохраняет в context запроса его id и адрес клиента для записей журнала.
+// Должен стоять после server.RequestID.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := WithMeta(c.Request.Context(), Meta{
+			RequestID: logger.RequestIDFromContext(c.Request.Context()),
+			IP:        c.ClientIP(),
+		})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+type Handler struct {
+	repo Repository
+	log  logger.Logger
+}
+
+func NewHandler(repo Repository, log logger.Logger) *Handler {
+	return &Handler{repo: repo, log: log}
+}
+
+// Register ожидает, что r уже требует аутентификацию.
+func (h *Handler) Register(r gin.IRouter) {
+	r.GET("/users/:id/activity", rbac.RequireOwnerOr("id", rbac.PermAuditRead), h.activity)
+	r.GET("/audit/:entity_type/:entity_id", h.history)
+}
+
+// activity - что делал пользователь.
+func (h *Handler) activity(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	limit, offset, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	entries, err := h.repo.ListByActor(c.Request.Context(), id, limit, offset)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
+
+// history - как менялась сущность. Свой профиль пользователь видит сам, остальное - только с audit:read.
+// Без права - 404, как в rbac.RequireOwnerOr, чтобы нельзя было перебирать id.
+func (h *Handler) history(c *gin.Context) {
+	entityType, entityID := c.Param("entity_type"), c.Param("entity_id")
+
+	p := rbac.PrincipalFrom(c)
+	own := entityType == "user" && entityID == strconv.FormatInt(p.UserID, 10)
+	if !own && !p.Can(rbac.PermAuditRead) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	limit, offset, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	entries, err := h.repo.ListByEntity(c.Request.Context(), entityType, entityID, limit, offset)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	h.log.Error(c.Request.Context(), "audit handler failed", logger.Field{Key: "error", Value: err})
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+func pagination(c *gin.Context) (int, int, bool) {
+	limit, offset := defaultLimit, 0
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxLimit {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return 0, 0, false
+		}
+		limit = n
+	}
+
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+			return 0, 0, false
+		}
+		offset = n
+	}
+
+	return limit, offset, true
+}
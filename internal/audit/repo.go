@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// Recorder пишет запись в той же транзакции q, в которой меняются данные,
+// чтобы изменение и его след в журнале либо появились оба, либо ни одного.
+type Recorder interface {
+	Record(ctx context.Context, q storage.Querier, e Entry) error
+}
+
+type Repository interface {
+	Recorder
+	// Append - запись вне транзакции, для действий, которые сами данные не меняют (просмотр, impersonate).
+	Append(ctx context.Context, e Entry) error
+	// ListByActor - что делал пользователь, от новых к старым.
+	ListByActor(ctx context.Context, actorID int64, limit, offset int) ([]Entry, error)
+	// ListByEntity - история изменений одной сущности, от новых к старым.
+	ListByEntity(ctx context.Context, entityType, entityID string, limit, offset int) ([]Entry, error)
+}
+
+type pgRepository struct {
+	db  *storage.DB
+	log logger.Logger
+}
+
+func NewRepository(db *storage.DB, log logger.Logger) Repository {
+	return &pgRepository{db: db, log: log}
+}
+
+// Record дополняет e автором из rbac.Principal и метаданными запроса из ctx, если они не заданы явно.
+func (r *pgRepository) Record(ctx context.Context, q storage.Querier, e Entry) error {
+	const query = `insert into audit_log
+	(actor_id, impersonator_id, action, entity_type, entity_id, diff, request_id, ip)
+	values ($1, $2, $3, $4, $5, $6, nullif($7, ''), nullif($8, ''))`
+
+	if p := rbac.PrincipalFromContext(ctx); p != nil && e.ActorID == nil {
+		e.ActorID = &p.UserID
+		if p.ImpersonatorID != 0 {
+			e.ImpersonatorID = &p.ImpersonatorID
+		}
+	}
+
+	meta := MetaFromContext(ctx)
+	if e.RequestID == "" {
+		e.RequestID = meta.RequestID
+	}
+	if e.IP == "" {
+		e.IP = meta.IP
+	}
+
+	diff := e.Diff
+	if diff == nil {
+		diff = map[string]Change{}
+	}
+
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return fmt.Errorf("marshal audit diff: %w", err)
+	}
+
+	_, err = q.Exec(ctx, query, e.ActorID, e.ImpersonatorID, e.Action, e.EntityType, e.EntityID, diffJSON, e.RequestID, e.IP)
+	if err != nil {
+		r.log.Error(ctx, "failed to write audit log",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "action", Value: e.Action},
+			logger.Field{Key: "entity_type", Value: e.EntityType},
+			logger.Field{Key: "entity_id", Value: e.EntityID})
+		return fmt.Errorf("failed query Record audit: %w", err)
+	}
+
+	return nil
+}
+
+func (r *pgRepository) Append(ctx context.Context, e Entry) error {
+	return r.Record(ctx, r.db.Pool, e)
+}
+
+const selectEntries = `select id, actor_id, impersonator_id, action, entity_type, entity_id, diff,
+	coalesce(request_id, ''), coalesce(ip, ''), create_at
+	from audit_log `
+
+func (r *pgRepository) ListByActor(ctx context.Context, actorID int64, limit, offset int) ([]Entry, error) {
+	const query = selectEntries + `where actor_id = $1 order by id desc limit $2 offset $3`
+
+	return r.list(ctx, "ListByActor", query, actorID, limit, offset)
+}
+
+func (r *pgRepository) ListByEntity(ctx context.Context, entityType, entityID string, limit, offset int) ([]Entry, error) {
+	const query = selectEntries + `where entity_type = $1 and entity_id = $2 order by id desc limit $3 offset $4`
+
+	return r.list(ctx, "ListByEntity", query, entityType, entityID, limit, offset)
+}
+
+func (r *pgRepository) list(ctx context.Context, op, query string, args ...any) ([]Entry, error) {
+	rows, err := r.db.Reader(ctx).Query(ctx, query, args...)
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query "+op, logger.Field{Key: "error", Value: err})
+		return nil, fmt.Errorf("failed query %s: %w", op, err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var (
+			e    Entry
+			diff []byte
+		)
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.ImpersonatorID, &e.Action, &e.EntityType, &e.EntityID,
+			&diff, &e.RequestID, &e.IP, &e.CreateAt); err != nil {
+			r.log.Error(ctx, "failed scan "+op, logger.Field{Key: "error", Value: err})
+			return nil, fmt.Errorf("failed scan %s: %w", op, err)
+		}
+
+		if err := json.Unmarshal(diff, &e.Diff); err != nil {
+			return nil, fmt.Errorf("unmarshal audit diff: %w", err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.log.Error(ctx, "rows iteration error in "+op, logger.Field{Key: "error", Value: err})
+		return nil, fmt.Errorf("rows interation %s: %w", op, err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+type Handler struct {
+	svc *Service
+	log logger.Logger
+}
+
+func NewHandler(svc *Service, log logger.Logger) *Handler {
+	return &Handler{svc: svc, log: log}
+}
+
+func (h *Handler) Register(r gin.IRouter) {
+	g := r.Group("/auth")
+	g.POST("/register", h.register)
+	g.POST("/verify-email", h.verifyEmail)
+	g.POST("/verify-email/resend", h.resendVerification)
+	g.POST("/password-reset/request", h.requestPasswordReset)
+	g.POST("/password-reset/confirm", h.confirmPasswordReset)
+	g.POST("/login", h.login)
+
+	authed := g.Group("", RequireUser(h.svc, h.log))
+	authed.POST("/logout", h.logout)
+	authed.POST("/2fa/totp/enroll", h.enrollTOTP)
+	authed.POST("/2fa/totp/confirm", h.confirmTOTP)
+	authed.POST("/2fa/totp/disable", h.disableTOTP)
+	authed.POST("/2fa/recovery-codes", h.regenerateRecoveryCodes)
+}
+
+type registerRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type tokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type emailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type loginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	// TOTP код или код восстановления, нужен только при включённой 2FA
+	Code string `json:"code"`
+}
+
+type codeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func (h *Handler) register(c *gin.Context) {
+	var req registerRequest
+	if !bind(c, &req) {
+		return
+	}
+
+	id, err := h.svc.Register(c.Request.Context(), RegisterInput{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: req.Password,
+		Lang:     c.GetHeader("Accept-Language"),
+	})
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+func (h *Handler) verifyEmail(c *gin.Context) {
+	var req tokenRequest
+	if !bind(c, &req) {
+		return
+	}
+
+	if err := h.svc.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) resendVerification(c *gin.Context) {
+	var req emailRequest
+	if !bind(c, &req) {
+		return
+	}
+
+	if err := h.svc.ResendVerification(c.Request.Context(), req.Email, c.GetHeader("Accept-Language")); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+func (h *Handler) requestPasswordReset(c *gin.Context) {
+	var req emailRequest
+	if !bind(c, &req) {
+		return
+	}
+
+	if err := h.svc.RequestPasswordReset(c.Request.Context(), req.Email, c.GetHeader("Accept-Language")); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	// ответ одинаковый для существующих и несуществующих адресов
+	c.Status(http.StatusAccepted)
+}
+
+func (h *Handler) confirmPasswordReset(c *gin.Context) {
+	var req resetPasswordRequest
+	if !bind(c, &req) {
+		return
+	}
+
+	if err := h.svc.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) login(c *gin.Context) {
+	var req loginRequest
+	if !bind(c, &req) {
+		return
+	}
+
+	session, err := h.svc.Login(c.Request.Context(), req.Email, req.Password, req.Code)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": session.Token, "expires_at": session.ExpiresAt})
+}
+
+func (h *Handler) logout(c *gin.Context) {
+	token, _ := c.Get(sessionTokenKey)
+
+	if err := h.svc.Logout(c.Request.Context(), token.(string)); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) enrollTOTP(c *gin.Context) {
+	userID, _ := UserID(c)
+
+	enrollment, err := h.svc.BeginTOTPEnrollment(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           enrollment.Secret,
+		"provisioning_uri": enrollment.ProvisioningURI,
+	})
+}
+
+func (h *Handler) confirmTOTP(c *gin.Context) {
+	var req codeRequest
+	if !bind(c, &req) {
+		return
+	}
+	userID, _ := UserID(c)
+
+	codes, err := h.svc.ConfirmTOTPEnrollment(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *Handler) disableTOTP(c *gin.Context) {
+	var req codeRequest
+	if !bind(c, &req) {
+		return
+	}
+	userID, _ := UserID(c)
+
+	if err := h.svc.DisableTOTP(c.Request.Context(), userID, req.Code); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) regenerateRecoveryCodes(c *gin.Context) {
+	var req codeRequest
+	if !bind(c, &req) {
+		return
+	}
+	userID, _ := UserID(c)
+
+	codes, err := h.svc.RegenerateRecoveryCodes(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *Handler) writeError(c *gin.Context, err error) {
+	var locked *LockedError
+
+	switch {
+	case errors.As(err, &locked):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrTwoFactorRequired):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "two_factor_required": true})
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrInvalidCode), errors.Is(err, ErrUnauthenticated):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrUserDisabled):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrTOTPNotEnrolled), errors.Is(err, ErrTOTPAlreadyEnabled):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidToken):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrWeakPassword):
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrUserAlreadyExists):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.log.Error(c.Request.Context(), "auth handler failed", logger.Field{Key: "error", Value: err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func bind(c *gin.Context, req any) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+type Session struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Login проверяет пароль и, если у пользователя включена 2FA, код из приложения или код восстановления.
+// Без кода при включённой 2FA возвращает ErrTwoFactorRequired, чтобы клиент мог его запросить.
+// После нескольких неудачных попыток подряд вход блокируется, тогда ошибка - *LockedError.
+func (s *Service) Login(ctx context.Context, email, password, code string) (*Session, error) {
+	if s.lockout == nil {
+		return s.login(ctx, email, password, code)
+	}
+
+	// неизвестные email блокируются так же, иначе по блокировке видно, есть ли аккаунт
+	key := user.NormalizeEmail(email)
+
+	locked, err := s.lockout.Check(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if locked > 0 {
+		return nil, &LockedError{RetryAfter: locked}
+	}
+
+	session, err := s.login(ctx, email, password, code)
+	switch {
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrInvalidCode):
+		d, lockErr := s.lockout.Fail(ctx, key)
+		if lockErr != nil {
+			return nil, lockErr
+		}
+		if d > 0 {
+			s.log.Warn(ctx, "login locked after failed attempts", logger.Field{Key: "lock_for", Value: d})
+		}
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+
+	if err := s.lockout.Reset(ctx, key); err != nil {
+		s.log.Error(ctx, "cannot reset login lockout", logger.Field{Key: "error", Value: err})
+	}
+
+	return session, nil
+}
+
+func (s *Service) login(ctx context.Context, email, password, code string) (*Session, error) {
+	u, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		if _, err := CheckPassword(dummyHash, password); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := CheckPassword(u.PassHash, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.log.Info(ctx, "login failed: wrong password", logger.Field{Key: "user_id", Value: u.ID})
+		return nil, ErrInvalidCredentials
+	}
+
+	// о блокировке говорим только тому, кто знает пароль
+	if u.DisabledAt != nil {
+		return nil, ErrUserDisabled
+	}
+
+	t, err := s.store.GetTOTP(ctx, u.ID)
+	switch {
+	case errors.Is(err, ErrTOTPNotEnrolled):
+	case err != nil:
+		return nil, err
+	case t.EnabledAt != nil:
+		if code == "" {
+			return nil, ErrTwoFactorRequired
+		}
+		if err := s.verifySecondFactor(ctx, t, code); err != nil {
+			s.log.Info(ctx, "login failed: wrong two-factor code", logger.Field{Key: "user_id", Value: u.ID})
+			return nil, err
+		}
+	}
+
+	return s.newSession(ctx, u.ID, 0, s.cfg.SessionTTL)
+}
+
+// Authenticate возвращает пользователя запроса по токену сессии.
+func (s *Service) Authenticate(ctx context.Context, token string) (*rbac.Principal, error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	return s.store.SessionPrincipal(ctx, hashToken(token))
+}
+
+// Impersonate создаёт короткую сессию пользователя targetID от имени админа adminID.
+// Под чужой учёткой нельзя зайти к другому админу или заблокированному пользователю.
+func (s *Service) Impersonate(ctx context.Context, adminID, targetID int64) (*Session, error) {
+	if adminID == targetID {
+		return nil, rbac.ErrForbidden
+	}
+
+	target, err := s.users.GetByID(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Role == rbac.RoleAdmin || target.DisabledAt != nil {
+		return nil, rbac.ErrForbidden
+	}
+
+	return s.newSession(ctx, targetID, adminID, s.cfg.ImpersonationTTL)
+}
+
+func (s *Service) RevokeUserSessions(ctx context.Context, userID int64) error {
+	return s.store.RevokeUserSessions(ctx, userID)
+}
+
+func (s *Service) Logout(ctx context.Context, token string) error {
+	return s.store.RevokeSession(ctx, hashToken(token))
+}
+
+func (s *Service) newSession(ctx context.Context, userID, impersonatorID int64, ttl time.Duration) (*Session, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	if err := s.store.CreateSession(ctx, userID, impersonatorID, hash, expiresAt); err != nil {
+		return nil, err
+	}
+
+	return &Session{Token: token, ExpiresAt: expiresAt}, nil
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+const sessionTokenKey = "auth.session_token"
+
+// RequireUser пропускает только запросы с действующей сессией в заголовке Authorization: Bearer <token>.
+func RequireUser(svc *Service, log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c.GetHeader("Authorization"))
+
+		principal, err := svc.Authenticate(c.Request.Context(), token)
+		if errors.Is(err, ErrUnauthenticated) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Error(c.Request.Context(), "failed to authenticate request", logger.Field{Key: "error", Value: err})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+
+		rbac.SetPrincipal(c, principal)
+		c.Set(sessionTokenKey, token)
+
+		fields := []logger.Field{{Key: logger.UserIDKey, Value: principal.UserID}}
+		if principal.ImpersonatorID != 0 {
+			fields = append(fields, logger.Field{Key: "impersonator_id", Value: principal.ImpersonatorID})
+		}
+		c.Request = c.Request.WithContext(logger.WithFields(c.Request.Context(), fields...))
+		c.Next()
+	}
+}
+
+// UserID - id пользователя, которого аутентифицировал RequireUser.
+func UserID(c *gin.Context) (int64, bool) {
+	p := rbac.PrincipalFrom(c)
+	if p == nil {
+		return 0, false
+	}
+
+	return p.UserID, true
+}
+
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
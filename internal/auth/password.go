@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLen = 8
+
+// dummyHash сверяется с паролем, когда пользователя нет: вход по неизвестному
+// email занимает столько же времени, сколько по известному. Стоимость та же,
+// что у HashPassword.
+const dummyHash = "$2a$10$zQjIvuJSSxVSr9GRMQ9dWe2Oy.KMVfciofAPlt0jg1G0u5HDtzzbm"
+
+func HashPassword(password string) (string, error) {
+	if utf8.RuneCountInString(password) < minPasswordLen {
+		return "", ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+func CheckPassword(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("compare password: %w", err)
+	}
+
+	return true, nil
+}
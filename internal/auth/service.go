@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/skinkvi/money_managment/pkg/mailer"
+	"github.com/skinkvi/money_managment/pkg/secretbox"
+)
+
+var (
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters long")
+	ErrUnauthenticated    = errors.New("unauthenticated")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrTwoFactorRequired  = errors.New("two-factor code required")
+	ErrInvalidCode        = errors.New("invalid two-factor code")
+	ErrTOTPNotEnrolled    = errors.New("two-factor authentication is not enabled")
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrUserDisabled       = errors.New("user is disabled")
+	ErrAccountLocked      = errors.New("too many failed login attempts, try again later")
+)
+
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+// Lockout - блокировка входа после неудачных попыток, см. ratelimit.Lockout.
+type Lockout interface {
+	Check(ctx context.Context, key string) (time.Duration, error)
+	Fail(ctx context.Context, key string) (time.Duration, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// LockedError - вход заблокирован ещё на RetryAfter.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string { return ErrAccountLocked.Error() }
+
+func (e *LockedError) Is(target error) bool { return target == ErrAccountLocked }
+
+type Service struct {
+	users user.Repository
+	store Store
+	// nil - без блокировки
+	lockout Lockout
+	mailer  mailer.Mailer
+	tmpl    *mailer.Templates
+	// шифрует TOTP секреты перед записью в базу
+	box *secretbox.Box
+	cfg config.AuthConfig
+	log logger.Logger
+}
+
+func NewService(users user.Repository, store Store, lockout Lockout, m mailer.Mailer, cfg config.AuthConfig, log logger.Logger) (*Service, error) {
+	tmpl, err := mailer.ParseTemplates(templatesFS, "templates/*.tmpl", cfg.DefaultLang)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TOTPEncryptionKey == "" {
+		return nil, errors.New("auth.totpEncryptionKey is required")
+	}
+
+	box, err := secretbox.NewFromBase64(cfg.TOTPEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("auth.totpEncryptionKey: %w", err)
+	}
+
+	return &Service{
+		users:   users,
+		store:   store,
+		lockout: lockout,
+		mailer:  m,
+		tmpl:    tmpl,
+		box:     box,
+		cfg:     cfg,
+		log:     log,
+	}, nil
+}
+
+type RegisterInput struct {
+	Username string
+	Email    string
+	Password string
+	// язык писем, например из Accept-Language
+	Lang string
+}
+
+// Register создаёт пользователя и отправляет письмо для подтверждения email.
+// Ошибка отправки письма не отменяет регистрацию, письмо можно запросить повторно.
+func (s *Service) Register(ctx context.Context, in RegisterInput) (int64, error) {
+	hash, err := HashPassword(in.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	u := &user.User{Username: in.Username, Email: in.Email, PassHash: hash}
+
+	id, err := s.users.Create(ctx, u)
+	if err != nil {
+		return 0, err
+	}
+	u.ID = id
+
+	if err := s.sendVerification(ctx, u, in.Lang); err != nil {
+		s.log.Error(ctx, "failed to send verification email",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: id})
+	}
+
+	return id, nil
+}
+
+// ResendVerification молча ничего не делает для неизвестных и уже подтверждённых адресов,
+// чтобы по ответу нельзя было проверить, зарегистрирован ли email.
+func (s *Service) ResendVerification(ctx context.Context, email, lang string) error {
+	u, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if u.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	return s.sendVerification(ctx, u, lang)
+}
+
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	userID, err := s.store.Consume(ctx, PurposeVerifyEmail, hashToken(token))
+	if err != nil {
+		return err
+	}
+
+	return s.users.MarkEmailVerified(ctx, userID)
+}
+
+// RequestPasswordReset так же как ResendVerification не раскрывает, есть ли такой пользователь.
+func (s *Service) RequestPasswordReset(ctx context.Context, email, lang string) error {
+	u, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		s.log.Info(ctx, "password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.sendToken(ctx, u, PurposeResetPassword, s.cfg.ResetTokenTTL, "reset_password", "/reset-password", lang)
+}
+
+func (s *Service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	userID, err := s.store.Consume(ctx, PurposeResetPassword, hashToken(token))
+	if err != nil {
+		return err
+	}
+
+	// версия нужна для Patch, читаем с основной базы, а не с реплики
+	ctx = storage.WithPrimary(ctx)
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// токен уже погашен, поэтому при гонке с редактированием профиля пробуем ещё раз со свежей версией
+	_, err = s.users.Patch(ctx, u.ID, u.Version, user.Patch{PassHash: &hash})
+	if errors.Is(err, storage.ErrVersionConflict) {
+		if u, err = s.users.GetByID(ctx, userID); err != nil {
+			return err
+		}
+		_, err = s.users.Patch(ctx, u.ID, u.Version, user.Patch{PassHash: &hash})
+	}
+	if err != nil {
+		return err
+	}
+
+	// старый пароль мог утечь, поэтому выкидываем все открытые сессии
+	if err := s.store.RevokeUserSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	s.log.Info(ctx, "password reset", logger.Field{Key: "user_id", Value: userID})
+
+	return nil
+}
+
+func (s *Service) sendVerification(ctx context.Context, u *user.User, lang string) error {
+	return s.sendToken(ctx, u, PurposeVerifyEmail, s.cfg.VerifyTokenTTL, "verify_email", "/verify-email", lang)
+}
+
+func (s *Service) sendToken(ctx context.Context, u *user.User, purpose TokenPurpose, ttl time.Duration, tmplName, path, lang string) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.Issue(ctx, u.ID, purpose, hash, time.Now().Add(ttl)); err != nil {
+		return err
+	}
+
+	if lang == "" {
+		lang = s.cfg.DefaultLang
+	}
+
+	msg, err := s.tmpl.Render(tmplName, lang, map[string]any{
+		"Username": u.Username,
+		"Link":     s.cfg.PublicURL + path + "?token=" + url.QueryEscape(token),
+		"TTL":      ttl.String(),
+	})
+	if err != nil {
+		return err
+	}
+	msg.To = u.Email
+
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("send %s email: %w", tmplName, err)
+	}
+
+	return nil
+}
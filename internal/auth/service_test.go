@@ -0,0 +1,574 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/ratelimit"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/skinkvi/money_managment/pkg/mailer"
+	"github.com/skinkvi/money_managment/pkg/totp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+// memUsers - in-memory user.Repository, реализованы только нужные сервису методы
+type memUsers struct {
+	user.Repository
+	mu    sync.Mutex
+	users map[int64]*user.User
+}
+
+func newMemUsers() *memUsers {
+	return &memUsers{users: map[int64]*user.User{}}
+}
+
+func (m *memUsers) Create(ctx context.Context, u *user.User) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// как pgUserRepository: адрес хранится нормализованным
+	u.Email = user.NormalizeEmail(u.Email)
+	for _, existing := range m.users {
+		if existing.Email == u.Email {
+			return 0, storage.ErrUserAlreadyExists
+		}
+	}
+	cp := *u
+	cp.ID = int64(len(m.users) + 1)
+	cp.Version = 1
+	m.users[cp.ID] = &cp
+	return cp.ID, nil
+}
+
+func (m *memUsers) GetByID(ctx context.Context, id int64) (*user.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (m *memUsers) GetByEmail(ctx context.Context, email string) (*user.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	email = user.NormalizeEmail(email)
+	for _, u := range m.users {
+		if u.Email == email {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, storage.ErrUserNotFound
+}
+
+func (m *memUsers) Patch(ctx context.Context, id, version int64, patch user.Patch) (*user.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	if u.Version != version {
+		return nil, storage.ErrVersionConflict
+	}
+	if patch.PassHash != nil {
+		u.PassHash = *patch.PassHash
+	}
+	u.Version++
+	cp := *u
+	return &cp, nil
+}
+
+func (m *memUsers) MarkEmailVerified(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	now := time.Now()
+	u.EmailVerifiedAt = &now
+	return nil
+}
+
+type memToken struct {
+	userID    int64
+	purpose   TokenPurpose
+	expiresAt time.Time
+	used      bool
+}
+
+type memTOTP struct {
+	TOTP
+	lastStep int64
+}
+
+// memStore - in-memory Store
+type memStore struct {
+	mu       sync.Mutex
+	tokens   map[string]*memToken
+	sessions map[string]int64
+	totp     map[int64]*memTOTP
+	codes    map[int64]map[string]bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		tokens:   map[string]*memToken{},
+		sessions: map[string]int64{},
+		totp:     map[int64]*memTOTP{},
+		codes:    map[int64]map[string]bool{},
+	}
+}
+
+func (m *memStore) Issue(ctx context.Context, userID int64, purpose TokenPurpose, hash []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tokens {
+		if t.userID == userID && t.purpose == purpose {
+			t.used = true
+		}
+	}
+	m.tokens[string(hash)] = &memToken{userID: userID, purpose: purpose, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *memStore) Consume(ctx context.Context, purpose TokenPurpose, hash []byte) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[string(hash)]
+	if !ok || t.used || t.purpose != purpose || time.Now().After(t.expiresAt) {
+		return 0, ErrInvalidToken
+	}
+	t.used = true
+	return t.userID, nil
+}
+
+func (m *memStore) CreateSession(ctx context.Context, userID, impersonatorID int64, hash []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[string(hash)] = userID
+	return nil
+}
+
+func (m *memStore) SessionPrincipal(ctx context.Context, hash []byte) (*rbac.Principal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.sessions[string(hash)]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return &rbac.Principal{UserID: id, Role: rbac.RoleUser}, nil
+}
+
+func (m *memStore) RevokeSession(ctx context.Context, hash []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, string(hash))
+	return nil
+}
+
+func (m *memStore) RevokeUserSessions(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, id := range m.sessions {
+		if id == userID {
+			delete(m.sessions, k)
+		}
+	}
+	return nil
+}
+
+func (m *memStore) GetTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.totp[userID]
+	if !ok {
+		return nil, ErrTOTPNotEnrolled
+	}
+	cp := t.TOTP
+	return &cp, nil
+}
+
+func (m *memStore) SaveTOTPSecret(ctx context.Context, userID int64, secretEnc []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.totp[userID]; ok && t.EnabledAt != nil {
+		return ErrTOTPAlreadyEnabled
+	}
+	m.totp[userID] = &memTOTP{TOTP: TOTP{UserID: userID, SecretEnc: secretEnc}}
+	return nil
+}
+
+func (m *memStore) EnableTOTP(ctx context.Context, userID int64, recoveryHashes [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.totp[userID]
+	now := time.Now()
+	t.EnabledAt = &now
+	m.replaceCodes(userID, recoveryHashes)
+	return nil
+}
+
+func (m *memStore) DisableTOTP(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.totp, userID)
+	delete(m.codes, userID)
+	return nil
+}
+
+func (m *memStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, recoveryHashes [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replaceCodes(userID, recoveryHashes)
+	return nil
+}
+
+func (m *memStore) replaceCodes(userID int64, hashes [][]byte) {
+	m.codes[userID] = map[string]bool{}
+	for _, h := range hashes {
+		m.codes[userID][string(h)] = true
+	}
+}
+
+func (m *memStore) UseTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.totp[userID]
+	if t.lastStep >= step {
+		return false, nil
+	}
+	t.lastStep = step
+	return true, nil
+}
+
+func (m *memStore) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.codes[userID][string(hash)] {
+		return false, nil
+	}
+	delete(m.codes[userID], string(hash))
+	return true, nil
+}
+
+type captureMailer struct {
+	sent []mailer.Message
+}
+
+func (c *captureMailer) Send(ctx context.Context, msg mailer.Message) error {
+	c.sent = append(c.sent, msg)
+	return nil
+}
+
+// lastToken достаёт токен из ссылки в последнем письме
+func (c *captureMailer) lastToken(t *testing.T) string {
+	t.Helper()
+	require.NotEmpty(t, c.sent)
+	body := c.sent[len(c.sent)-1].Body
+	i := strings.Index(body, "?token=")
+	require.NotEqual(t, -1, i)
+	raw := strings.Fields(body[i+len("?token="):])[0]
+	token, err := url.QueryUnescape(raw)
+	require.NoError(t, err)
+	return token
+}
+
+func newTestService(t *testing.T) (*Service, *memUsers, *captureMailer) {
+	users := newMemUsers()
+	mail := &captureMailer{}
+	svc, err := NewService(users, newMemStore(), nil, mail, config.AuthConfig{
+		PublicURL:         "https://mm.example.com",
+		VerifyTokenTTL:    time.Hour,
+		ResetTokenTTL:     time.Hour,
+		DefaultLang:       "ru",
+		SessionTTL:        time.Hour,
+		TOTPIssuer:        "MM",
+		TOTPEncryptionKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)),
+	}, nopLogger{})
+	require.NoError(t, err)
+	return svc, users, mail
+}
+
+func TestService_VerifyEmail(t *testing.T) {
+	svc, users, mail := newTestService(t)
+	ctx := context.Background()
+
+	id, err := svc.Register(ctx, RegisterInput{Username: "dima", Email: "dima@example.com", Password: "secret-pass", Lang: "en-US"})
+	require.NoError(t, err)
+	require.Len(t, mail.sent, 1)
+	require.Equal(t, "dima@example.com", mail.sent[0].To)
+	require.Equal(t, "Confirm your email address", mail.sent[0].Subject)
+	require.Contains(t, mail.sent[0].Body, "https://mm.example.com/verify-email?token=")
+
+	token := mail.lastToken(t)
+	require.NoError(t, svc.VerifyEmail(ctx, token))
+
+	u, err := users.GetByID(ctx, id)
+	require.NoError(t, err)
+	require.NotNil(t, u.EmailVerifiedAt)
+
+	// токен одноразовый
+	require.ErrorIs(t, svc.VerifyEmail(ctx, token), ErrInvalidToken)
+
+	// для подтверждённого адреса письмо повторно не отправляется
+	require.NoError(t, svc.ResendVerification(ctx, "dima@example.com", "ru"))
+	require.Len(t, mail.sent, 1)
+}
+
+func TestService_Register_WeakPassword(t *testing.T) {
+	svc, _, mail := newTestService(t)
+
+	_, err := svc.Register(context.Background(), RegisterInput{Username: "dima", Email: "dima@example.com", Password: "short"})
+	require.ErrorIs(t, err, ErrWeakPassword)
+	require.Empty(t, mail.sent)
+}
+
+func TestService_ResetPassword(t *testing.T) {
+	svc, users, mail := newTestService(t)
+	ctx := context.Background()
+
+	id, err := svc.Register(ctx, RegisterInput{Username: "dima", Email: "dima@example.com", Password: "old-password"})
+	require.NoError(t, err)
+
+	// неизвестный адрес не даёт ошибки и письма
+	require.NoError(t, svc.RequestPasswordReset(ctx, "nobody@example.com", ""))
+	require.Len(t, mail.sent, 1)
+
+	require.NoError(t, svc.RequestPasswordReset(ctx, "dima@example.com", ""))
+	require.Len(t, mail.sent, 2)
+	require.Equal(t, "Сброс пароля", mail.sent[1].Subject)
+	first := mail.lastToken(t)
+
+	// новый запрос гасит предыдущий токен
+	require.NoError(t, svc.RequestPasswordReset(ctx, "dima@example.com", ""))
+	second := mail.lastToken(t)
+	require.ErrorIs(t, svc.ResetPassword(ctx, first, "new-password"), ErrInvalidToken)
+
+	require.ErrorIs(t, svc.ResetPassword(ctx, second, "short"), ErrWeakPassword)
+	require.NoError(t, svc.ResetPassword(ctx, second, "new-password"))
+	require.ErrorIs(t, svc.ResetPassword(ctx, second, "new-password"), ErrInvalidToken)
+
+	u, err := users.GetByID(ctx, id)
+	require.NoError(t, err)
+	ok, err := CheckPassword(u.PassHash, "new-password")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := newToken()
+	require.NoError(t, err)
+	require.Len(t, token, 43)
+	require.True(t, bytes.Equal(hash, hashToken(token)))
+
+	other, _, err := newToken()
+	require.NoError(t, err)
+	require.NotEqual(t, token, other)
+}
+
+func TestDummyHash(t *testing.T) {
+	// вход по неизвестному email должен стоить столько же, сколько по известному
+	cost, err := bcrypt.Cost([]byte(dummyHash))
+	require.NoError(t, err)
+	require.Equal(t, bcrypt.DefaultCost, cost)
+
+	svc, _, _ := newTestService(t)
+	_, err = svc.Login(context.Background(), "nobody@example.com", "password", "")
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestService_TwoFactorLogin(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	id, err := svc.Register(ctx, RegisterInput{Username: "dima", Email: "dima@example.com", Password: "secret-pass"})
+	require.NoError(t, err)
+
+	session, err := svc.Login(ctx, "dima@example.com", "secret-pass", "")
+	require.NoError(t, err)
+	principal, err := svc.Authenticate(ctx, session.Token)
+	require.NoError(t, err)
+	require.Equal(t, id, principal.UserID)
+
+	_, err = svc.Login(ctx, "dima@example.com", "wrong-pass", "")
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	enrollment, err := svc.BeginTOTPEnrollment(ctx, id)
+	require.NoError(t, err)
+	require.Contains(t, enrollment.ProvisioningURI, "secret="+enrollment.Secret)
+
+	_, err = svc.ConfirmTOTPEnrollment(ctx, id, "000000")
+	require.ErrorIs(t, err, ErrInvalidCode)
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	recovery, err := svc.ConfirmTOTPEnrollment(ctx, id, code)
+	require.NoError(t, err)
+	require.Len(t, recovery, recoveryCodesCount)
+
+	_, err = svc.Login(ctx, "dima@example.com", "secret-pass", "")
+	require.ErrorIs(t, err, ErrTwoFactorRequired)
+
+	// код уже использован при подтверждении
+	_, err = svc.Login(ctx, "dima@example.com", "secret-pass", code)
+	require.ErrorIs(t, err, ErrInvalidCode)
+
+	next, err := totp.Code(enrollment.Secret, totp.Step(time.Now())+1)
+	require.NoError(t, err)
+	_, err = svc.Login(ctx, "dima@example.com", "secret-pass", next)
+	require.NoError(t, err)
+
+	// код восстановления одноразовый и не зависит от регистра
+	_, err = svc.Login(ctx, "dima@example.com", "secret-pass", strings.ToUpper(recovery[0]))
+	require.NoError(t, err)
+	_, err = svc.Login(ctx, "dima@example.com", "secret-pass", recovery[0])
+	require.ErrorIs(t, err, ErrInvalidCode)
+
+	require.ErrorIs(t, svc.DisableTOTP(ctx, id, "bad-code"), ErrInvalidCode)
+	require.NoError(t, svc.DisableTOTP(ctx, id, recovery[1]))
+
+	_, err = svc.Login(ctx, "dima@example.com", "secret-pass", "")
+	require.NoError(t, err)
+}
+
+func TestService_LoginLockout(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	svc.lockout = ratelimit.NewLockout(ratelimit.NewMemoryStore(), config.LockoutConfig{
+		Threshold: 3, Window: time.Minute, BaseDelay: time.Minute, MaxDelay: time.Hour,
+	})
+	ctx := context.Background()
+
+	_, err := svc.Register(ctx, RegisterInput{Username: "dima", Email: "dima@example.com", Password: "secret-pass"})
+	require.NoError(t, err)
+
+	// успешный вход сбрасывает счётчик, регистр и пробелы в адресе не важны
+	for i := 0; i < 2; i++ {
+		_, err = svc.Login(ctx, "dima@example.com", "wrong-pass", "")
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+	_, err = svc.Login(ctx, " Dima@Example.COM ", "secret-pass", "")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = svc.Login(ctx, "Dima@example.com", "wrong-pass", "")
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+
+	// даже верный пароль не пускает, пока действует блокировка
+	_, err = svc.Login(ctx, "dima@example.com", "secret-pass", "")
+	var locked *LockedError
+	require.ErrorAs(t, err, &locked)
+	require.ErrorIs(t, err, ErrAccountLocked)
+	require.InDelta(t, time.Minute.Seconds(), locked.RetryAfter.Seconds(), 1)
+
+	// для несуществующего аккаунта ведём себя так же
+	for i := 0; i < 3; i++ {
+		_, err = svc.Login(ctx, "nobody@example.com", "x", "")
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+	_, err = svc.Login(ctx, "nobody@example.com", "x", "")
+	require.ErrorIs(t, err, ErrAccountLocked)
+}
+
+func TestService_TwoFactorLockout(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	svc.lockout = ratelimit.NewLockout(ratelimit.NewMemoryStore(), config.LockoutConfig{
+		Threshold: 3, Window: time.Minute, BaseDelay: time.Minute, MaxDelay: time.Hour,
+	})
+	ctx := context.Background()
+
+	id, err := svc.Register(ctx, RegisterInput{Username: "dima", Email: "dima@example.com", Password: "secret-pass"})
+	require.NoError(t, err)
+	enrollment, err := svc.BeginTOTPEnrollment(ctx, id)
+	require.NoError(t, err)
+
+	// пробелы внутри кода не мешают
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	recovery, err := svc.ConfirmTOTPEnrollment(ctx, id, code[:3]+" "+code[3:])
+	require.NoError(t, err)
+
+	next, err := totp.Code(enrollment.Secret, totp.Step(time.Now())+1)
+	require.NoError(t, err)
+	_, err = svc.Login(ctx, "dima@example.com", "secret-pass", " "+next[:3]+" "+next[3:])
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = svc.RegenerateRecoveryCodes(ctx, id, "000000")
+		require.ErrorIs(t, err, ErrInvalidCode)
+	}
+
+	// перебор из открытой сессии упирается в блокировку, верный код тоже не проходит
+	err = svc.DisableTOTP(ctx, id, recovery[0])
+	var locked *LockedError
+	require.ErrorAs(t, err, &locked)
+	require.InDelta(t, time.Minute.Seconds(), locked.RetryAfter.Seconds(), 1)
+}
+
+func TestStore_SessionPrincipalChecksImpersonator(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	store := NewStore(&storage.DB{Pool: mock}, nopLogger{})
+	hash := hashToken("token")
+
+	// админа понизили или заблокировали - его impersonate сессия больше не находится
+	mock.ExpectQuery(regexp.QuoteMeta(`left join users a on a.id = s.impersonator_id`)).
+		WithArgs(hash, rbac.RoleAdmin).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "impersonator_id", "role"}))
+
+	_, err = store.SessionPrincipal(context.Background(), hash)
+	require.ErrorIs(t, err, ErrUnauthenticated)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestService_ConfirmTOTPEnrollmentLockout(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	svc.lockout = ratelimit.NewLockout(ratelimit.NewMemoryStore(), config.LockoutConfig{
+		Threshold: 3, Window: time.Minute, BaseDelay: time.Minute, MaxDelay: time.Hour,
+	})
+	ctx := context.Background()
+
+	id, err := svc.Register(ctx, RegisterInput{Username: "dima", Email: "dima@example.com", Password: "secret-pass"})
+	require.NoError(t, err)
+	enrollment, err := svc.BeginTOTPEnrollment(ctx, id)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = svc.ConfirmTOTPEnrollment(ctx, id, "000000")
+		require.ErrorIs(t, err, ErrInvalidCode)
+	}
+
+	// подбор кода при подключении упирается в ту же блокировку
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	_, err = svc.ConfirmTOTPEnrollment(ctx, id, code)
+	require.ErrorIs(t, err, ErrAccountLocked)
+}
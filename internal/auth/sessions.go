@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+type SessionRepository interface {
+	// impersonatorID - id админа, если сессия создана через impersonate, иначе 0.
+	CreateSession(ctx context.Context, userID, impersonatorID int64, hash []byte, expiresAt time.Time) error
+	// SessionPrincipal возвращает владельца действующей сессии с его ролью.
+	// Истёкшая, отозванная сессия или заблокированный пользователь - ErrUnauthenticated.
+	// Сессия impersonate действует, только пока её создатель - незаблокированный админ.
+	SessionPrincipal(ctx context.Context, hash []byte) (*rbac.Principal, error)
+	RevokeSession(ctx context.Context, hash []byte) error
+	// RevokeUserSessions завершает все сессии пользователя, например после смены пароля.
+	RevokeUserSessions(ctx context.Context, userID int64) error
+}
+
+func (r *pgStore) CreateSession(ctx context.Context, userID, impersonatorID int64, hash []byte, expiresAt time.Time) error {
+	const query = `insert into sessions (user_id, impersonator_id, token_hash, expires_at)
+	values ($1, nullif($2, 0), $3, $4)`
+
+	if _, err := r.db.Pool.Exec(ctx, query, userID, impersonatorID, hash, expiresAt); err != nil {
+		r.log.Error(ctx, "failed to execute query CreateSession",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID})
+		return fmt.Errorf("failed query CreateSession: %w", err)
+	}
+
+	return nil
+}
+
+func (r *pgStore) SessionPrincipal(ctx context.Context, hash []byte) (*rbac.Principal, error) {
+	const query = `select s.user_id, coalesce(s.impersonator_id, 0), u.role
+	from sessions s
+	join users u on u.id = s.user_id
+	left join users a on a.id = s.impersonator_id
+	where s.token_hash = $1 and s.revoked_at is null and s.expires_at > now() and u.disabled_at is null
+	and (s.impersonator_id is null or (a.role = $2 and a.disabled_at is null))`
+
+	var p rbac.Principal
+
+	err := r.db.Pool.QueryRow(ctx, query, hash, rbac.RoleAdmin).Scan(&p.UserID, &p.ImpersonatorID, &p.Role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUnauthenticated
+	}
+
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query SessionPrincipal", logger.Field{Key: "error", Value: err})
+		return nil, fmt.Errorf("failed query SessionPrincipal: %w", err)
+	}
+
+	return &p, nil
+}
+
+func (r *pgStore) RevokeSession(ctx context.Context, hash []byte) error {
+	const query = `update sessions set revoked_at = now() where token_hash = $1 and revoked_at is null`
+
+	if _, err := r.db.Pool.Exec(ctx, query, hash); err != nil {
+		r.log.Error(ctx, "failed to execute query RevokeSession", logger.Field{Key: "error", Value: err})
+		return fmt.Errorf("failed query RevokeSession: %w", err)
+	}
+
+	return nil
+}
+
+func (r *pgStore) RevokeUserSessions(ctx context.Context, userID int64) error {
+	const query = `update sessions set revoked_at = now() where user_id = $1 and revoked_at is null`
+
+	if _, err := r.db.Pool.Exec(ctx, query, userID); err != nil {
+		r.log.Error(ctx, "failed to execute query RevokeUserSessions",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID})
+		return fmt.Errorf("failed query RevokeUserSessions: %w", err)
+	}
+
+	return nil
+}
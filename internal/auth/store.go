@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// Store - всё, что auth хранит в postgres помимо самих пользователей.
+type Store interface {
+	TokenRepository
+	SessionRepository
+	TwoFactorRepository
+}
+
+type pgStore struct {
+	db  *storage.DB
+	log logger.Logger
+}
+
+func NewStore(db *storage.DB, log logger.Logger) Store {
+	return &pgStore{db: db, log: log}
+}
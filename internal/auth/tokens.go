@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+type TokenPurpose string
+
+const (
+	PurposeVerifyEmail   TokenPurpose = "verify_email"
+	PurposeResetPassword TokenPurpose = "reset_password"
+)
+
+type TokenRepository interface {
+	// Issue сохраняет новый токен и гасит все неиспользованные токены пользователя с тем же назначением.
+	Issue(ctx context.Context, userID int64, purpose TokenPurpose, hash []byte, expiresAt time.Time) error
+	// Consume атомарно помечает токен использованным и возвращает id его владельца.
+	// Просроченный, уже использованный или неизвестный токен - ErrInvalidToken.
+	Consume(ctx context.Context, purpose TokenPurpose, hash []byte) (int64, error)
+}
+
+func (r *pgStore) Issue(ctx context.Context, userID int64, purpose TokenPurpose, hash []byte, expiresAt time.Time) error {
+	const query = `with revoked as (
+		update user_tokens set used_at = now()
+		where user_id = $1 and purpose = $2 and used_at is null
+	)
+	insert into user_tokens (user_id, purpose, token_hash, expires_at)
+	values ($1, $2, $3, $4)`
+
+	if _, err := r.db.Pool.Exec(ctx, query, userID, string(purpose), hash, expiresAt); err != nil {
+		r.log.Error(ctx, "failed to execute query Issue token",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID},
+			logger.Field{Key: "purpose", Value: purpose})
+		return fmt.Errorf("failed query Issue token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *pgStore) Consume(ctx context.Context, purpose TokenPurpose, hash []byte) (int64, error) {
+	const query = `update user_tokens
+	set used_at = now()
+	where token_hash = $1 and purpose = $2 and used_at is null and expires_at > now()
+	returning user_id`
+
+	var userID int64
+
+	err := r.db.Pool.QueryRow(ctx, query, hash, string(purpose)).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrInvalidToken
+	}
+
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query Consume token",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "purpose", Value: purpose})
+		return 0, fmt.Errorf("failed query Consume token: %w", err)
+	}
+
+	return userID, nil
+}
+
+// newToken возвращает токен для отправки пользователю и его хэш для хранения в базе.
+func newToken() (string, []byte, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("generate token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
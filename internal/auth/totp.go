@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/skinkvi/money_managment/pkg/totp"
+)
+
+const (
+	recoveryCodesCount = 10
+	// допускаем расхождение часов телефона и сервера на один шаг в каждую сторону
+	totpSkew = 1
+)
+
+type TOTPEnrollment struct {
+	Secret          string
+	ProvisioningURI string
+}
+
+// BeginTOTPEnrollment генерирует новый секрет. 2FA включится только после ConfirmTOTPEnrollment,
+// до этого повторный вызов просто заменяет секрет.
+func (s *Service) BeginTOTPEnrollment(ctx context.Context, userID int64) (*TOTPEnrollment, error) {
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := s.box.Seal([]byte(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.SaveTOTPSecret(ctx, userID, enc); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(s.cfg.TOTPIssuer, u.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment включает 2FA, если код из приложения верный, и возвращает коды восстановления.
+// Коды показываются пользователю один раз, в базе лежат только их хэши.
+func (s *Service) ConfirmTOTPEnrollment(ctx context.Context, userID int64, code string) ([]string, error) {
+	t, err := s.store.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if t.EnabledAt != nil {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	err = s.withLockout(ctx, userID, func() error {
+		return s.verifyTOTP(ctx, t, normalizeCode(code))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.EnableTOTP(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	s.log.Info(ctx, "two-factor authentication enabled", logger.Field{Key: "user_id", Value: userID})
+
+	return codes, nil
+}
+
+// DisableTOTP требует действующий код (из приложения или восстановления), одного пароля/сессии мало.
+func (s *Service) DisableTOTP(ctx context.Context, userID int64, code string) error {
+	t, err := s.enabledTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.verifySecondFactor(ctx, t, code); err != nil {
+		return err
+	}
+
+	if err := s.store.DisableTOTP(ctx, userID); err != nil {
+		return err
+	}
+
+	s.log.Info(ctx, "two-factor authentication disabled", logger.Field{Key: "user_id", Value: userID})
+
+	return nil
+}
+
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID int64, code string) ([]string, error) {
+	t, err := s.enabledTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySecondFactor(ctx, t, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *Service) enabledTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	t, err := s.store.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if t.EnabledAt == nil {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	return t, nil
+}
+
+// verifySecondFactor принимает либо 6-значный TOTP код, либо код восстановления.
+func (s *Service) verifySecondFactor(ctx context.Context, t *TOTP, code string) error {
+	return s.withLockout(ctx, t.UserID, func() error {
+		return s.checkSecondFactor(ctx, t, code)
+	})
+}
+
+// withLockout считает неудачные проверки кодов по пользователю, после нескольких
+// подряд проверка блокируется с *LockedError, иначе коды можно перебирать из уже
+// открытой сессии. Неудача - check вернул ErrInvalidCode.
+func (s *Service) withLockout(ctx context.Context, userID int64, check func() error) error {
+	if s.lockout == nil {
+		return check()
+	}
+
+	key := "2fa:" + strconv.FormatInt(userID, 10)
+
+	locked, err := s.lockout.Check(ctx, key)
+	if err != nil {
+		return err
+	}
+	if locked > 0 {
+		return &LockedError{RetryAfter: locked}
+	}
+
+	err = check()
+	switch {
+	case errors.Is(err, ErrInvalidCode):
+		d, lockErr := s.lockout.Fail(ctx, key)
+		if lockErr != nil {
+			return lockErr
+		}
+		if d > 0 {
+			s.log.Warn(ctx, "two-factor locked after failed attempts",
+				logger.Field{Key: "user_id", Value: userID},
+				logger.Field{Key: "lock_for", Value: d})
+		}
+		return err
+	case err != nil:
+		return err
+	}
+
+	if err := s.lockout.Reset(ctx, key); err != nil {
+		s.log.Error(ctx, "cannot reset two-factor lockout", logger.Field{Key: "error", Value: err})
+	}
+
+	return nil
+}
+
+func (s *Service) checkSecondFactor(ctx context.Context, t *TOTP, code string) error {
+	code = normalizeCode(code)
+	if len(code) == totp.Digits {
+		return s.verifyTOTP(ctx, t, code)
+	}
+
+	ok, err := s.store.UseRecoveryCode(ctx, t.UserID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	s.log.Info(ctx, "recovery code used", logger.Field{Key: "user_id", Value: t.UserID})
+
+	return nil
+}
+
+// normalizeCode убирает пробелы, которые приложения и пользователи вставляют
+// для читаемости: "123 456".
+func normalizeCode(code string) string {
+	return strings.Join(strings.Fields(code), "")
+}
+
+func (s *Service) verifyTOTP(ctx context.Context, t *TOTP, code string) error {
+	secret, err := s.box.Open(t.SecretEnc)
+	if err != nil {
+		return fmt.Errorf("decrypt totp secret: %w", err)
+	}
+
+	step, ok := totp.Validate(string(secret), code, time.Now(), totpSkew)
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	// один и тот же код нельзя использовать повторно, даже пока он ещё действителен
+	fresh, err := s.store.UseTOTPStep(ctx, t.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes возвращает коды вида "abcde-fghij" и их хэши.
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([][]byte, 0, recoveryCodesCount)
+
+	for range recoveryCodesCount {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+
+		code := strings.ToLower(recoveryEncoding.EncodeToString(raw))[:10]
+		code = code[:5] + "-" + code[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode не учитывает регистр, пробелы и дефисы - их легко перепутать при вводе.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
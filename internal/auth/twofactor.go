@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+type TOTP struct {
+	UserID int64
+	// зашифрованный секрет, расшифровывает Service
+	SecretEnc []byte
+	EnabledAt *time.Time
+}
+
+type TwoFactorRepository interface {
+	// GetTOTP возвращает ErrTOTPNotEnrolled, если пользователь ещё не начинал подключение.
+	GetTOTP(ctx context.Context, userID int64) (*TOTP, error)
+	// SaveTOTPSecret заменяет неподтверждённый секрет. Если 2FA уже включена - ErrTOTPAlreadyEnabled.
+	SaveTOTPSecret(ctx context.Context, userID int64, secretEnc []byte) error
+	// EnableTOTP включает 2FA и заменяет коды восстановления одной транзакцией.
+	EnableTOTP(ctx context.Context, userID int64, recoveryHashes [][]byte) error
+	DisableTOTP(ctx context.Context, userID int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, recoveryHashes [][]byte) error
+	// UseTOTPStep запоминает шаг использованного кода, false - код с этим шагом уже принимался.
+	UseTOTPStep(ctx context.Context, userID, step int64) (bool, error)
+	// UseRecoveryCode гасит код восстановления, false - такого неиспользованного кода нет.
+	UseRecoveryCode(ctx context.Context, userID int64, hash []byte) (bool, error)
+}
+
+func (r *pgStore) GetTOTP(ctx context.Context, userID int64) (*TOTP, error) {
+	const query = `select user_id, secret_enc, enabled_at from user_totp where user_id = $1`
+
+	var t TOTP
+
+	err := r.db.Pool.QueryRow(ctx, query, userID).Scan(&t.UserID, &t.SecretEnc, &t.EnabledAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query GetTOTP",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID})
+		return nil, fmt.Errorf("failed query GetTOTP: %w", err)
+	}
+
+	return &t, nil
+}
+
+func (r *pgStore) SaveTOTPSecret(ctx context.Context, userID int64, secretEnc []byte) error {
+	const query = `insert into user_totp (user_id, secret_enc) values ($1, $2)
+	on conflict (user_id) do update
+	set secret_enc = excluded.secret_enc, last_used_step = null, create_at = now()
+	where user_totp.enabled_at is null`
+
+	cmdTag, err := r.db.Pool.Exec(ctx, query, userID, secretEnc)
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query SaveTOTPSecret",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID})
+		return fmt.Errorf("failed query SaveTOTPSecret: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	return nil
+}
+
+func (r *pgStore) EnableTOTP(ctx context.Context, userID int64, recoveryHashes [][]byte) error {
+	const query = `update user_totp set enabled_at = now() where user_id = $1 and enabled_at is null`
+
+	return r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		cmdTag, err := tx.Exec(ctx, query, userID)
+		if err != nil {
+			r.log.Error(ctx, "failed to execute query EnableTOTP",
+				logger.Field{Key: "error", Value: err},
+				logger.Field{Key: "user_id", Value: userID})
+			return fmt.Errorf("failed query EnableTOTP: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return ErrTOTPAlreadyEnabled
+		}
+
+		return r.replaceRecoveryCodes(ctx, tx, userID, recoveryHashes)
+	})
+}
+
+func (r *pgStore) DisableTOTP(ctx context.Context, userID int64) error {
+	const (
+		deleteTOTP  = `delete from user_totp where user_id = $1`
+		deleteCodes = `delete from user_recovery_codes where user_id = $1`
+	)
+
+	return r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, deleteTOTP, userID); err != nil {
+			r.log.Error(ctx, "failed to execute query DisableTOTP",
+				logger.Field{Key: "error", Value: err},
+				logger.Field{Key: "user_id", Value: userID})
+			return fmt.Errorf("failed query DisableTOTP: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, deleteCodes, userID); err != nil {
+			r.log.Error(ctx, "failed to delete recovery codes",
+				logger.Field{Key: "error", Value: err},
+				logger.Field{Key: "user_id", Value: userID})
+			return fmt.Errorf("failed query DisableTOTP codes: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (r *pgStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, recoveryHashes [][]byte) error {
+	return r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		return r.replaceRecoveryCodes(ctx, tx, userID, recoveryHashes)
+	})
+}
+
+func (r *pgStore) replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int64, recoveryHashes [][]byte) error {
+	const (
+		deleteCodes = `delete from user_recovery_codes where user_id = $1`
+		insertCodes = `insert into user_recovery_codes (user_id, code_hash)
+		select $1, unnest($2::bytea[])`
+	)
+
+	if _, err := tx.Exec(ctx, deleteCodes, userID); err != nil {
+		r.log.Error(ctx, "failed to delete recovery codes",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID})
+		return fmt.Errorf("failed delete recovery codes: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, insertCodes, userID, recoveryHashes); err != nil {
+		r.log.Error(ctx, "failed to insert recovery codes",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID})
+		return fmt.Errorf("failed insert recovery codes: %w", err)
+	}
+
+	return nil
+}
+
+func (r *pgStore) UseTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	const query = `update user_totp set last_used_step = $2
+	where user_id = $1 and (last_used_step is null or last_used_step < $2)`
+
+	cmdTag, err := r.db.Pool.Exec(ctx, query, userID, step)
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query UseTOTPStep",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID})
+		return false, fmt.Errorf("failed query UseTOTPStep: %w", err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+func (r *pgStore) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) (bool, error) {
+	const query = `update user_recovery_codes set used_at = now()
+	where user_id = $1 and code_hash = $2 and used_at is null`
+
+	cmdTag, err := r.db.Pool.Exec(ctx, query, userID, hash)
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query UseRecoveryCode",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID})
+		return false, fmt.Errorf("failed query UseRecoveryCode: %w", err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
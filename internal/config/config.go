@@ -1,77 +1,289 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"time"
 
-	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/skinkvi/money_managment/pkg/redact"
+	"gopkg.in/yaml.v3"
 )
 
 var LogLevel uint8
 
+// Config - настройки сервиса. Как собираются слои, описано у Load. Переменные
+// окружения - из тегов env с префиксом секции, например MM_SERVER_PORT или MM_DB_PASSWORD.
 type Config struct {
-	App      AppSettings  `yaml:"app"`
-	Logger   LoggerConfig `yaml:"logger"`
-	Server   ServerConfig `yaml:"server"`
-	DataBase DBConfig     `yaml:"database"`
-	Redis    RedisConfig  `yaml:"cache"`
-	Timeouts Timeouts     `yaml:"timeouts"`
+	App      AppSettings   `yaml:"app" env-prefix:"MM_APP_"`
+	Logger   LoggerConfig  `yaml:"logger" env-prefix:"MM_LOGGER_"`
+	Server   ServerConfig  `yaml:"server" env-prefix:"MM_SERVER_"`
+	GRPC     GRPCConfig    `yaml:"grpc" env-prefix:"MM_GRPC_"`
+	DataBase DBConfig      `yaml:"database" env-prefix:"MM_DB_"`
+	Redis    RedisConfig   `yaml:"cache" env-prefix:"MM_REDIS_"`
+	Timeouts Timeouts      `yaml:"timeouts" env-prefix:"MM_TIMEOUTS_"`
+	Auth     AuthConfig    `yaml:"auth" env-prefix:"MM_AUTH_"`
+	Mailer   MailerConfig  `yaml:"mailer" env-prefix:"MM_MAILER_"`
+	Tracing  TracingConfig `yaml:"tracing" env-prefix:"MM_TRACING_"`
+	// лимиты применяются без перезапуска
+	RateLimit   RateLimitConfig   `yaml:"ratelimit" env-prefix:"MM_RATELIMIT_" reload:"live"`
+	Idempotency IdempotencyConfig `yaml:"idempotency" env-prefix:"MM_IDEMPOTENCY_"`
+	Realtime    RealtimeConfig    `yaml:"realtime" env-prefix:"MM_REALTIME_"`
+	// флаги функций, меняются без перезапуска: households - /households, sync - /sync.
+	// Флаги из файла дополняют значения по умолчанию, JSON из окружения заменяет их
+	// целиком: MM_FEATURES={"sync":true}
+	Features map[string]bool `yaml:"features" env:"MM_FEATURES" default:"{households: true, sync: true}" reload:"live"`
+}
+
+// Feature - включён ли флаг, неизвестные флаги выключены.
+func (c *Config) Feature(name string) bool {
+	return c.Features[name]
 }
 
 type AppSettings struct {
-	Name string `yaml:"name"`
-	Env  string `yaml:"env" default:"dev"`
+	Name string `yaml:"name" env:"NAME"`
+	Env  string `yaml:"env" env:"ENV" default:"dev"`
 }
 
 type LoggerConfig struct {
-	Level      string `yaml:"level" default:"debug"`
-	Encoding   string `yaml:"encoding" default:"console"`
-	OutputPath string `yaml:"outputPath" default:""`
+	// общий уровень, его можно поменять на лету через админку или SIGHUP
+	Level string `yaml:"level" env:"LEVEL" default:"debug" reload:"live"`
+	// у выводов без своего encoding меняется на лету
+	Encoding string `yaml:"encoding" env:"ENCODING" default:"console" reload:"live"`
+	// используется, только если sinks не заданы
+	OutputPath string `yaml:"outputPath" env:"OUTPUT_PATH"`
+	// в окружении - JSON список: MM_LOGGER_SINKS=[{"output":"stdout"}]
+	Sinks  []SinkConfig `yaml:"sinks" env:"SINKS"`
+	Redact RedactConfig `yaml:"redact" env-prefix:"REDACT_"`
+}
+
+// SinkConfig - один из выводов логгера, записи пишутся во все сразу.
+type SinkConfig struct {
+	// stdout, stderr или путь к файлу
+	Output string `yaml:"output"`
+	// дополнительный порог для этого вывода, ниже общего уровня он не опускается
+	Level string `yaml:"level"`
+	// json или console, по умолчанию как у логгера
+	Encoding string         `yaml:"encoding"`
+	Rotation RotationConfig `yaml:"rotation"`
+}
+
+// RotationConfig - ротация файла, нули отключают соответствующее ограничение.
+type RotationConfig struct {
+	MaxSizeMB int `yaml:"maxSizeMB"`
+	// старые файлы удаляются после maxAge, округляется вверх до суток
+	MaxAge     time.Duration `yaml:"maxAge"`
+	MaxBackups int           `yaml:"maxBackups"`
+	// gzip для ротированных файлов. Без других ограничений файл ротируется по 100 МБ
+	Compress bool `yaml:"compress"`
+	// ротация по времени независимо от размера, например 24h
+	Every time.Duration `yaml:"every"`
+}
+
+// RedactConfig - маскировка секретов и персональных данных (email, номера карт) в логах.
+type RedactConfig struct {
+	// только для локальной отладки
+	Disabled bool `yaml:"disabled" env:"DISABLED"`
+	// ключи полей, значения которых не проверяются на PII, например "email" в логах админки
+	Allow []string `yaml:"allow" env:"ALLOW"`
 }
 
 type ServerConfig struct {
-	Host         string `yaml:"host" default:"0.0.0.0"`
-	Port         int    `yaml:"port" default:"8080"`
-	ReadTimeout  string `yaml:"readTimeout" default:"5s"`
-	WriteTimeout string `yaml:"writeTimeout" default:"10s"`
-	IdleTimeout  string `yaml:"idleTimeout" default:"120s"`
+	Host         string        `yaml:"host" env:"HOST" default:"0.0.0.0"`
+	Port         int           `yaml:"port" env:"PORT" default:"8080"`
+	ReadTimeout  time.Duration `yaml:"readTimeout" env:"READ_TIMEOUT" default:"5s"`
+	WriteTimeout time.Duration `yaml:"writeTimeout" env:"WRITE_TIMEOUT" default:"10s"`
+	IdleTimeout  time.Duration `yaml:"idleTimeout" env:"IDLE_TIMEOUT" default:"120s"`
+	// порт для /metrics, 0 - не поднимать сервер метрик
+	MetricsPort int `yaml:"metricsPort" env:"METRICS_PORT" default:"9090"`
+	// IP или CIDR прокси, которым можно верить в X-Forwarded-For и X-Real-IP.
+	// Пусто - клиентом считается адрес соединения
+	TrustedProxies []string `yaml:"trustedProxies" env:"TRUSTED_PROXIES"`
+}
+
+// GRPCConfig - gRPC API рядом с HTTP, с той же аутентификацией по сессиям.
+type GRPCConfig struct {
+	// 0 - не поднимать gRPC сервер
+	Port int `yaml:"port" env:"PORT" default:"9091"`
+	// server reflection для grpcurl и подобных, наружу лучше не открывать
+	Reflection bool `yaml:"reflection" env:"REFLECTION" default:"true"`
 }
 
 type DBConfig struct {
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	DBName   string `yaml:"dbname"`
-	SSLMode  string `yaml:"sslmode" default:"disable"`
-	MaxConn  int    `yaml:"max_conns" default:"10"`
+	// URL - строка подключения (postgres://... или key=value). Если задана,
+	// Host/Port/User/Password/DBName/SSL* не используются, а настройки пула ниже
+	// перекрывают pool_* параметры из строки, только если не нулевые.
+	URL      string `yaml:"url" env:"URL" secret:"true"`
+	Host     string `yaml:"host" env:"HOST"`
+	Port     int    `yaml:"port" env:"PORT"`
+	User     string `yaml:"user" env:"USER"`
+	Password string `yaml:"password" env:"PASSWORD" secret:"true"`
+	DBName   string `yaml:"dbname" env:"NAME"`
+	// disable, allow, prefer, require, verify-ca, verify-full
+	SSLMode     string `yaml:"sslmode" env:"SSL_MODE" default:"disable"`
+	SSLRootCert string `yaml:"sslrootcert" env:"SSL_ROOT_CERT"`
+	SSLCert     string `yaml:"sslcert" env:"SSL_CERT"`
+	SSLKey      string `yaml:"sslkey" env:"SSL_KEY"`
+
+	MaxConn           int           `yaml:"max_conns" env:"MAX_CONNS" default:"10"`
+	MinConns          int           `yaml:"min_conns" env:"MIN_CONNS"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime" env:"MAX_CONN_LIFETIME" default:"1h"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time" env:"MAX_CONN_IDLE_TIME" default:"30m"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period" env:"HEALTH_CHECK_PERIOD" default:"1m"`
+	// statement_timeout для каждого соединения, 0 - без ограничения
+	StatementTimeout time.Duration `yaml:"statement_timeout" env:"STATEMENT_TIMEOUT"`
+	ApplicationName  string        `yaml:"application_name" env:"APPLICATION_NAME" default:"money-management"`
+
+	// ожидание базы при старте: попытки с экспоненциальной паузой
+	ConnectAttempts   int           `yaml:"connect_attempts" env:"CONNECT_ATTEMPTS" default:"5"`
+	ConnectBackoff    time.Duration `yaml:"connect_backoff" env:"CONNECT_BACKOFF" default:"500ms"`
+	ConnectMaxBackoff time.Duration `yaml:"connect_max_backoff" env:"CONNECT_MAX_BACKOFF" default:"10s"`
+
+	// запросы дольше пишутся в лог с warn, 0 - не отмечать медленные запросы
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" env:"SLOW_QUERY_THRESHOLD" default:"200ms"`
+
+	// строки подключения к репликам, настройки пула берутся те же, что у основной базы
+	Replicas []string `yaml:"replicas" env:"REPLICAS" secret:"true"`
+	// реплика с отставанием больше этого исключается из чтения до следующей проверки
+	MaxReplicaLag      time.Duration `yaml:"max_replica_lag" env:"MAX_REPLICA_LAG" default:"5s"`
+	ReplicaCheckPeriod time.Duration `yaml:"replica_check_period" env:"REPLICA_CHECK_PERIOD" default:"5s"`
 }
 
 type RedisConfig struct {
-	Address      string `yaml:"address" default:"localhost:6379"`
-	Password     string `yaml:"password"`
-	DB           int    `yaml:"db"`
-	DialTimeout  string `yaml:"dialTimeout" default:"500ms"`
-	ReadTimeout  string `yaml:"readTimeout" default:"500ms"`
-	WriteTimeout string `yaml:"writeTimeout" default:"500ms"`
-	PoolSize     int    `yaml:"poolSize" default:"10"`
+	Address      string        `yaml:"address" env:"ADDRESS" default:"localhost:6379"`
+	Password     string        `yaml:"password" env:"PASSWORD" secret:"true"`
+	DB           int           `yaml:"db" env:"DB"`
+	DialTimeout  time.Duration `yaml:"dialTimeout" env:"DIAL_TIMEOUT" default:"500ms"`
+	ReadTimeout  time.Duration `yaml:"readTimeout" env:"READ_TIMEOUT" default:"500ms"`
+	WriteTimeout time.Duration `yaml:"writeTimeout" env:"WRITE_TIMEOUT" default:"500ms"`
+	PoolSize     int           `yaml:"poolSize" env:"POOL_SIZE" default:"10"`
 }
 
 type Timeouts struct {
-	ShutdwonGracePeriod   string `yaml:"shutdownGracePeriod" default:"15s"`
-	RequestContentTimeout string `yaml:"requestContentTimeout" default:"30s"`
-	ExternalAPITimeout    string `yaml:"externalAPITimeout" default:"10s"`
+	ShutdownGracePeriod   time.Duration `yaml:"shutdownGracePeriod" env:"SHUTDOWN_GRACE_PERIOD" default:"15s"`
+	RequestContentTimeout time.Duration `yaml:"requestContentTimeout" env:"REQUEST_CONTENT_TIMEOUT" default:"30s"`
+	ExternalAPITimeout    time.Duration `yaml:"externalAPITimeout" env:"EXTERNAL_API_TIMEOUT" default:"10s"`
+}
+
+type AuthConfig struct {
+	// адрес фронтенда, из него собираются ссылки в письмах
+	PublicURL      string        `yaml:"publicURL" env:"PUBLIC_URL" default:"http://localhost:8080"`
+	VerifyTokenTTL time.Duration `yaml:"verifyTokenTTL" env:"VERIFY_TOKEN_TTL" default:"24h"`
+	ResetTokenTTL  time.Duration `yaml:"resetTokenTTL" env:"RESET_TOKEN_TTL" default:"1h"`
+	// сколько действует приглашение в домохозяйство
+	InvitationTTL time.Duration `yaml:"invitationTTL" env:"INVITATION_TTL" default:"168h"`
+	// язык писем, если клиент не прислал свой
+	DefaultLang string        `yaml:"defaultLang" env:"DEFAULT_LANG" default:"ru"`
+	SessionTTL  time.Duration `yaml:"sessionTTL" env:"SESSION_TTL" default:"720h"`
+	// сессия админа под чужой учёткой живёт недолго
+	ImpersonationTTL time.Duration `yaml:"impersonationTTL" env:"IMPERSONATION_TTL" default:"1h"`
+	// имя, которое приложение-аутентификатор покажет рядом с кодом
+	TOTPIssuer string `yaml:"totpIssuer" env:"TOTP_ISSUER" default:"Money Management"`
+	// base64 от 32 байт, этим ключом шифруются TOTP секреты в базе
+	TOTPEncryptionKey string `yaml:"totpEncryptionKey" env:"TOTP_ENCRYPTION_KEY" secret:"true"`
 }
 
-func MustLoadConfig(path string) (*Config, error) {
-	if path == "" {
-		return nil, fmt.Errorf("config path is empty")
+type MailerConfig struct {
+	// smtp, file или log
+	Driver   string `yaml:"driver" env:"DRIVER" default:"log"`
+	From     string `yaml:"from" env:"FROM" default:"no-reply@localhost"`
+	Host     string `yaml:"host" env:"HOST"`
+	Port     int    `yaml:"port" env:"PORT" default:"587"`
+	Username string `yaml:"username" env:"USERNAME"`
+	Password string `yaml:"password" env:"PASSWORD" secret:"true"`
+	// каталог для драйвера file
+	Dir string `yaml:"dir" env:"DIR" default:"./tmp/mail"`
+}
+
+type TracingConfig struct {
+	// otlp, stdout, file или none
+	Exporter string `yaml:"exporter" env:"EXPORTER" default:"none"`
+	// host:port OTLP/HTTP коллектора, например localhost:4318
+	Endpoint string `yaml:"endpoint" env:"ENDPOINT" default:"localhost:4318"`
+	// без TLS, для коллектора рядом с сервисом
+	Insecure bool `yaml:"insecure" env:"INSECURE"`
+	// куда писать спаны для exporter: file
+	FilePath string `yaml:"filePath" env:"FILE_PATH" default:"./tmp/traces.json"`
+	// доля трейсов, которые начинаются у нас, от 0 до 1
+	SampleRatio float64 `yaml:"sampleRatio" env:"SAMPLE_RATIO" default:"1"`
+}
+
+type RateLimitConfig struct {
+	Disabled bool `yaml:"disabled" env:"DISABLED"`
+	// для маршрутов, которых нет в routes и во встроенных лимитах auth
+	Default RouteLimit `yaml:"default" env-prefix:"DEFAULT_"`
+	// ключ - метод и путь как в роутере, например "POST /auth/login". В окружении -
+	// JSON: MM_RATELIMIT_ROUTES={"GET /users/:id":{"requests":10,"window":"1m"}}
+	Routes  map[string]RouteLimit `yaml:"routes" env:"ROUTES"`
+	Lockout LockoutConfig         `yaml:"lockout" env-prefix:"LOCKOUT_"`
+}
+
+// RouteLimit - не больше Requests запросов за скользящее окно Window, отдельно
+// для каждого IP и каждого пользователя. 0 запросов - без ограничения.
+type RouteLimit struct {
+	Requests int           `yaml:"requests" env:"REQUESTS"`
+	Window   time.Duration `yaml:"window" env:"WINDOW"`
+}
+
+// LockoutConfig - блокировка входа в аккаунт после неудачных попыток подряд.
+// Первая блокировка на BaseDelay, каждая следующая вдвое дольше, но не больше MaxDelay.
+type LockoutConfig struct {
+	// 0 - без блокировки
+	Threshold int `yaml:"threshold" env:"THRESHOLD" default:"5"`
+	// неудачи старше окна забываются
+	Window    time.Duration `yaml:"window" env:"WINDOW" default:"15m"`
+	BaseDelay time.Duration `yaml:"baseDelay" env:"BASE_DELAY" default:"1m"`
+	MaxDelay  time.Duration `yaml:"maxDelay" env:"MAX_DELAY" default:"1h"`
+}
+
+// IdempotencyConfig - повтор POST/PATCH с тем же заголовком Idempotency-Key
+// получает сохранённый ответ первого запроса, а не выполняется второй раз.
+type IdempotencyConfig struct {
+	Disabled bool `yaml:"disabled" env:"DISABLED"`
+	// postgres или redis
+	Store string `yaml:"store" env:"STORE" default:"postgres"`
+	// сколько хранится ответ, после этого ключ можно использовать заново
+	TTL time.Duration `yaml:"ttl" env:"TTL" default:"24h"`
+	// запрос, который не закончился за это время (например, упал экземпляр), больше не держит ключ
+	LockTimeout time.Duration `yaml:"lockTimeout" env:"LOCK_TIMEOUT" default:"1m"`
+	// сколько повтор ждёт окончания первого запроса, прежде чем получить 409
+	Wait time.Duration `yaml:"wait" env:"WAIT" default:"5s"`
+}
+
+// RealtimeConfig - поток изменений клиентам через SSE, сигналы приходят из базы по NOTIFY.
+type RealtimeConfig struct {
+	Disabled bool `yaml:"disabled" env:"DISABLED"`
+	// комментарий в молчащий поток, чтобы прокси и балансировщики его не закрывали
+	Heartbeat time.Duration `yaml:"heartbeat" env:"HEARTBEAT" default:"25s"`
+	// одновременных потоков у одного пользователя, например телефон и несколько вкладок
+	MaxStreamsPerUser int `yaml:"maxStreamsPerUser" env:"MAX_STREAMS_PER_USER" default:"5"`
+	// пауза перед переподключением LISTEN соединения, растёт до maxReconnectBackoff
+	ReconnectBackoff    time.Duration `yaml:"reconnectBackoff" env:"RECONNECT_BACKOFF" default:"1s"`
+	MaxReconnectBackoff time.Duration `yaml:"maxReconnectBackoff" env:"MAX_RECONNECT_BACKOFF" default:"30s"`
+}
+
+// String - конфиг в JSON, поля с тегом secret:"true" скрыты. Благодаря этому
+// конфиг можно безопасно передавать в логгер целиком.
+func (c Config) String() string {
+	b, err := json.Marshal(redact.Secrets(c))
+	if err != nil {
+		return fmt.Sprintf("config: %v", err)
 	}
 
-	var cfg Config
+	return string(b)
+}
+
+// LogValue - то же для log/slog.
+func (c Config) LogValue() slog.Value {
+	return slog.AnyValue(redact.Secrets(c))
+}
 
-	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
-		return nil, fmt.Errorf("cannot read config from %s: %w", path, err)
+// YAML - конфиг в том же виде, что и файл. С redacted поля с тегом secret:"true"
+// заменены маской, ключи тогда идут по алфавиту.
+func (c Config) YAML(redacted bool) ([]byte, error) {
+	if redacted {
+		return yaml.Marshal(redact.Secrets(c))
 	}
 
-	return &cfg, nil
+	return yaml.Marshal(c)
 }
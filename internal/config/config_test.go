@@ -0,0 +1,220 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	return writeFile(t, t.TempDir(), "config.yaml", body)
+}
+
+func writeFile(t *testing.T, dir, name, body string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o600))
+	return path
+}
+
+func TestLoad_DefaultsFileEnv(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: 8081
+  metricsPort: 0
+  readTimeout: 2s
+database:
+  host: db
+  user: mm
+  dbname: money
+`)
+	t.Setenv("MM_SERVER_WRITE_TIMEOUT", "3s")
+	t.Setenv("MM_DB_PASSWORD", "secret")
+	t.Setenv("MM_DB_REPLICAS", "postgres://r1/money,postgres://r2/money")
+
+	cfg, err := MustLoadConfig(Options{Path: path})
+	require.NoError(t, err)
+
+	// из файла, явный 0 не заменяется значением по умолчанию
+	require.Equal(t, 8081, cfg.Server.Port)
+	require.Equal(t, 0, cfg.Server.MetricsPort)
+	require.Equal(t, 2*time.Second, cfg.Server.ReadTimeout)
+	// из окружения
+	require.Equal(t, 3*time.Second, cfg.Server.WriteTimeout)
+	require.Equal(t, "secret", cfg.DataBase.Password)
+	require.Equal(t, []string{"postgres://r1/money", "postgres://r2/money"}, cfg.DataBase.Replicas)
+	// по умолчанию
+	require.Equal(t, 120*time.Second, cfg.Server.IdleTimeout)
+	require.Equal(t, 10, cfg.DataBase.MaxConn)
+	require.Equal(t, "disable", cfg.DataBase.SSLMode)
+	require.Equal(t, 1.0, cfg.Tracing.SampleRatio)
+	require.True(t, cfg.Feature("households"))
+	require.True(t, cfg.Feature("sync"))
+	require.False(t, cfg.Feature("unknown"))
+}
+
+func TestLoad_EnvOnly(t *testing.T) {
+	t.Setenv("MM_DB_URL", "postgres://mm@db/money")
+	t.Setenv("MM_SERVER_PORT", "9000")
+
+	cfg, err := MustLoadConfig(Options{})
+	require.NoError(t, err)
+	require.Equal(t, 9000, cfg.Server.Port)
+	require.Equal(t, "postgres://mm@db/money", cfg.DataBase.URL)
+}
+
+func TestLoad_StructuredEnv(t *testing.T) {
+	path := writeConfig(t, `
+database:
+  url: postgres://mm@db/money
+features:
+  sync: true
+  households: true
+`)
+	t.Setenv("MM_FEATURES", `{"households": false}`)
+	t.Setenv("MM_LOGGER_SINKS", `[{"output": "stderr", "level": "warn", "rotation": {"every": "24h"}}]`)
+	t.Setenv("MM_RATELIMIT_ROUTES", `{"GET /users/:id": {"requests": 10, "window": "1m"}}`)
+
+	cfg, src, err := Load(Options{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	// словарь из окружения заменяет файловый, а не дополняет
+	require.Equal(t, map[string]bool{"households": false}, cfg.Features)
+	require.Equal(t, "env MM_FEATURES", src["features"])
+	require.NotContains(t, src, "features.sync")
+
+	require.Equal(t, []SinkConfig{{Output: "stderr", Level: "warn", Rotation: RotationConfig{Every: 24 * time.Hour}}}, cfg.Logger.Sinks)
+	require.Equal(t, map[string]RouteLimit{"GET /users/:id": {Requests: 10, Window: time.Minute}}, cfg.RateLimit.Routes)
+
+	t.Setenv("MM_LOGGER_SINKS", `[{"otput": "stderr"}]`)
+	_, _, err = Load(Options{Path: path})
+	require.ErrorContains(t, err, "logger.sinks: env MM_LOGGER_SINKS")
+}
+
+func TestLoad_SecretFilesInLists(t *testing.T) {
+	dir := t.TempDir()
+	replica := writeFile(t, dir, "replica", "postgres://r2/money\n")
+	t.Setenv("MM_DB_URL", "postgres://mm@db/money")
+	t.Setenv("MM_DB_REPLICAS", "postgres://r1/money,${file:"+replica+"}")
+
+	cfg, src, err := Load(Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"postgres://r1/money", "postgres://r2/money"}, cfg.DataBase.Replicas)
+	require.Equal(t, "env MM_DB_REPLICAS, secret file "+replica, src["database.replicas"])
+}
+
+func TestValidate_CollectsAllProblems(t *testing.T) {
+	path := writeConfig(t, `
+logger:
+  level: loud
+server:
+  port: 70000
+  trustedProxies: [10.0.0.0/8, proxy.local]
+database:
+  sslmode: sometimes
+tracing:
+  sampleRatio: 2
+`)
+
+	_, err := MustLoadConfig(Options{Path: path})
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	require.ElementsMatch(t, []string{
+		`logger.level: unknown value "loud", expected one of debug, info, warn, error, dpanic, panic, fatal`,
+		"server.port: must be between 1 and 65535, got 70000",
+		`server.trustedProxies[1]: must be an IP or CIDR, got "proxy.local"`,
+		"database.host: is required",
+		"database.user: is required",
+		"database.dbname: is required",
+		`database.sslmode: unknown value "sometimes", expected one of disable, allow, prefer, require, verify-ca, verify-full`,
+		"tracing.sampleRatio: must be between 0 and 1, got 2",
+	}, verr.Problems)
+}
+
+func TestYAML_Redacted(t *testing.T) {
+	cfg, _, err := Load(Options{})
+	require.NoError(t, err)
+	cfg.DataBase.Password = "hunter2"
+
+	out, err := cfg.YAML(true)
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "hunter2")
+
+	out, err = cfg.YAML(false)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "hunter2")
+}
+
+func TestLoad_Layers(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "config.yaml", `
+app:
+  env: staging
+server:
+  port: 8081
+database:
+  host: db
+  user: mm
+  dbname: money
+  password: ${file:`+filepath.Join(dir, "db_password")+`}
+`)
+	writeFile(t, dir, "config.staging.yaml", `
+server:
+  port: 8082
+  metricsPort: 9191
+database:
+  host: staging-db
+`)
+	writeFile(t, dir, "db_password", "s3cret\n")
+	t.Setenv("MM_SERVER_METRICS_PORT", "9292")
+
+	cfg, src, err := Load(Options{Path: base, Overrides: []string{"database.max_conns=20"}})
+	require.NoError(t, err)
+
+	require.Equal(t, 8082, cfg.Server.Port)
+	require.Equal(t, "staging-db", cfg.DataBase.Host)
+	require.Equal(t, "mm", cfg.DataBase.User)
+	require.Equal(t, 9292, cfg.Server.MetricsPort)
+	require.Equal(t, 20, cfg.DataBase.MaxConn)
+	require.Equal(t, "s3cret", cfg.DataBase.Password)
+
+	require.Equal(t, "file "+filepath.Join(dir, "config.staging.yaml"), src["server.port"])
+	require.Equal(t, "file "+base, src["database.user"])
+	require.Equal(t, "env MM_SERVER_METRICS_PORT", src["server.metricsPort"])
+	require.Equal(t, "flag --set", src["database.max_conns"])
+	require.Equal(t, "default", src["server.host"])
+	require.Equal(t, "file "+base+", secret file "+filepath.Join(dir, "db_password"), src["database.password"])
+}
+
+func TestLoad_ProfileFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "config.yaml", "server:\n  port: 8081\n")
+	writeFile(t, dir, "config.prod.yaml", "server:\n  port: 443\n")
+	t.Setenv("MM_APP_ENV", "prod")
+
+	cfg, _, err := Load(Options{Path: base})
+	require.NoError(t, err)
+	require.Equal(t, 443, cfg.Server.Port)
+
+	// файла для профиля может не быть
+	cfg, _, err = Load(Options{Path: base, Overrides: []string{"app.env=qa"}})
+	require.NoError(t, err)
+	require.Equal(t, 8081, cfg.Server.Port)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	_, _, err := Load(Options{Path: writeConfig(t, "server:\n  prot: 1\n")})
+	require.ErrorContains(t, err, "prot")
+
+	_, _, err = Load(Options{Overrides: []string{"server.nope=1"}})
+	require.ErrorContains(t, err, "unknown config key")
+
+	_, _, err = Load(Options{Path: writeConfig(t, "auth:\n  totpEncryptionKey: ${file:/nonexistent/key}\n")})
+	require.ErrorContains(t, err, "auth.totpEncryptionKey")
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field - лист конфига: ключ как в yaml (server.port), имя переменной окружения
+// и значение по умолчанию из тегов.
+type field struct {
+	key    string
+	env    string
+	def    string
+	hasDef bool
+	// reload:"live" - значение применяется без перезапуска
+	live  bool
+	value reflect.Value
+}
+
+// fields обходит конфиг и возвращает все листья. Словари и списки структур
+// (features, logger.sinks) считаются одним листом, из строки они читаются как JSON.
+func fields(cfg *Config) []field {
+	var out []field
+	walkFields(reflect.ValueOf(cfg).Elem(), "", "", false, &out)
+	return out
+}
+
+func walkFields(v reflect.Value, keyPrefix, envPrefix string, live bool, out *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := keyPrefix + name
+
+		fieldLive := live || f.Tag.Get("reload") == "live"
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			walkFields(fv, key+".", envPrefix+f.Tag.Get("env-prefix"), fieldLive, out)
+			continue
+		}
+
+		fl := field{key: key, live: fieldLive, value: fv}
+		if env := f.Tag.Get("env"); env != "" {
+			fl.env = envPrefix + env
+		}
+		fl.def, fl.hasDef = f.Tag.Lookup("default")
+		*out = append(*out, fl)
+	}
+}
+
+// settable - можно ли задать поле строкой из окружения или --set.
+func settable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Struct, reflect.Interface, reflect.Pointer:
+		return false
+	}
+	return true
+}
+
+// setValue разбирает строку в поле простого типа, списки строк - через запятую.
+// Словари и списки структур - JSON, значение целиком заменяет прежнее.
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Map || (v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.String) {
+		return setJSON(v, s)
+	}
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+			for i := range parts {
+				parts[i] = strings.TrimSpace(parts[i])
+			}
+		}
+		v.Set(reflect.ValueOf(parts).Convert(v.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// setJSON читается yaml декодером: JSON - его подмножество, а длительности
+// можно писать строками, как в файле ("1m").
+func setJSON(v reflect.Value, s string) error {
+	out := reflect.New(v.Type())
+	if strings.TrimSpace(s) != "" {
+		dec := yaml.NewDecoder(strings.NewReader(s))
+		dec.KnownFields(true)
+		if err := dec.Decode(out.Interface()); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+	v.Set(out.Elem())
+	return nil
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PathEnv - переменная окружения с путём к конфигу, если не передан --config.
+const PathEnv = "MM_CONFIG"
+
+// Path выбирает файл конфига: флаг, затем MM_CONFIG. Пустая строка значит,
+// что файла нет и настройки берутся из значений по умолчанию и окружения.
+func Path(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+
+	return os.Getenv(PathEnv)
+}
+
+type Options struct {
+	// базовый файл, может быть пустым
+	Path string
+	// key=value из флагов --set, например server.port=9000
+	Overrides []string
+}
+
+// Sources - откуда взято итоговое значение каждого ключа: default, file <путь>,
+// env <переменная> или flag --set. Значение, прочитанное из секретного файла,
+// дополнительно помечено путём к нему.
+type Sources map[string]string
+
+// Keys - ключи по алфавиту, для вывода.
+func (s Sources) Keys() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// set запоминает источник key. Словарь из окружения заменяет файловый целиком,
+// поэтому вложенные ключи вроде features.sync при этом забываются.
+func (s Sources) set(key, origin string) {
+	for k := range s {
+		if strings.HasPrefix(k, key+".") {
+			delete(s, k)
+		}
+	}
+	s[key] = origin
+}
+
+// secretFileRef - значение вида ${file:/run/secrets/db_password} заменяется
+// содержимым файла, так можно подключать секреты docker/k8s.
+var secretFileRef = regexp.MustCompile(`^\$\{file:(.+)\}$`)
+
+// MustLoadConfig читает конфиг и проверяет его. Ошибки проверки собираются все
+// сразу в *ValidationError.
+func MustLoadConfig(opts Options) (*Config, error) {
+	cfg, _, err := Load(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Load собирает конфиг без проверки, каждый следующий слой перекрывает предыдущий:
+//  1. значения по умолчанию из тегов default;
+//  2. базовый файл opts.Path;
+//  3. файл окружения рядом с ним: config.yaml -> config.<app.env>.yaml, если есть;
+//  4. переменные окружения;
+//  5. opts.Overrides.
+//
+// После этого ссылки ${file:...} заменяются содержимым файлов.
+func Load(opts Options) (*Config, Sources, error) {
+	var cfg Config
+	src := Sources{}
+	all := fields(&cfg)
+
+	byKey := make(map[string]field, len(all))
+	for _, f := range all {
+		byKey[f.key] = f
+	}
+
+	for _, f := range all {
+		if !f.hasDef || f.def == "" {
+			continue
+		}
+		if err := setValue(f.value, f.def); err != nil {
+			return nil, nil, fmt.Errorf("%s: default %q: %w", f.key, f.def, err)
+		}
+		src[f.key] = "default"
+	}
+
+	overrides, err := parseOverrides(opts.Overrides, byKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.Path != "" {
+		if err := readFile(opts.Path, &cfg, src); err != nil {
+			return nil, nil, err
+		}
+
+		overlay := overlayPath(opts.Path, profile(&cfg, byKey["app.env"], overrides))
+		if overlay != "" {
+			err := readFile(overlay, &cfg, src)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, nil, err
+			}
+		}
+	}
+
+	for _, f := range all {
+		if f.env == "" {
+			continue
+		}
+		raw, ok := os.LookupEnv(f.env)
+		if !ok {
+			continue
+		}
+		if err := setValue(f.value, raw); err != nil {
+			return nil, nil, fmt.Errorf("%s: env %s: %w", f.key, f.env, err)
+		}
+		src.set(f.key, "env "+f.env)
+	}
+
+	for _, o := range overrides {
+		if err := setValue(byKey[o.key].value, o.value); err != nil {
+			return nil, nil, fmt.Errorf("%s: flag --set: %w", o.key, err)
+		}
+		src.set(o.key, "flag --set")
+	}
+
+	if err := resolveSecretFiles(all, src); err != nil {
+		return nil, nil, err
+	}
+
+	return &cfg, src, nil
+}
+
+type override struct {
+	key   string
+	value string
+}
+
+func parseOverrides(raw []string, byKey map[string]field) ([]override, error) {
+	out := make([]override, 0, len(raw))
+	for _, r := range raw {
+		key, value, ok := strings.Cut(r, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --set %q: expected key=value", r)
+		}
+		f, known := byKey[key]
+		if !known || !settable(f.value) {
+			return nil, fmt.Errorf("invalid --set %q: unknown config key %s", r, key)
+		}
+		out = append(out, override{key: key, value: value})
+	}
+	return out, nil
+}
+
+// profile - app.env с учётом окружения и флагов, которые применятся позже:
+// по нему выбирается файл окружения.
+func profile(cfg *Config, envField field, overrides []override) string {
+	p := cfg.App.Env
+	if v, ok := os.LookupEnv(envField.env); ok {
+		p = v
+	}
+	for _, o := range overrides {
+		if o.key == envField.key {
+			p = o.value
+		}
+	}
+	return p
+}
+
+func overlayPath(base, profile string) string {
+	if profile == "" {
+		return ""
+	}
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + profile + ext
+}
+
+// readFile накладывает yaml поверх cfg. Неизвестные ключи - ошибка, чтобы
+// опечатка в имени не превращалась молча в значение по умолчанию.
+func readFile(path string, cfg *Config, src Sources) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config from %s: %w", path, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("cannot parse config %s: %w", path, err)
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("cannot parse config %s: %w", path, err)
+	}
+	for _, key := range leafKeys(raw, "") {
+		src[key] = "file " + path
+	}
+
+	return nil
+}
+
+func leafKeys(m map[string]any, prefix string) []string {
+	var keys []string
+	for k, v := range m {
+		if nested, ok := v.(map[string]any); ok {
+			keys = append(keys, leafKeys(nested, prefix+k+".")...)
+			continue
+		}
+		keys = append(keys, prefix+k)
+	}
+	return keys
+}
+
+func resolveSecretFiles(all []field, src Sources) error {
+	for _, f := range all {
+		switch {
+		case f.value.Kind() == reflect.String:
+			if err := resolveSecretFile(f.value, f.key, src); err != nil {
+				return err
+			}
+		case f.value.Kind() == reflect.Slice && f.value.Type().Elem().Kind() == reflect.String:
+			// например database.replicas: ссылкой может быть каждый элемент
+			for i := 0; i < f.value.Len(); i++ {
+				if err := resolveSecretFile(f.value.Index(i), f.key, src); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func resolveSecretFile(v reflect.Value, key string, src Sources) error {
+	m := secretFileRef.FindStringSubmatch(v.String())
+	if m == nil {
+		return nil
+	}
+
+	b, err := os.ReadFile(m[1])
+	if err != nil {
+		return fmt.Errorf("%s: read secret file: %w", key, err)
+	}
+	// echo и редакторы оставляют перевод строки в конце
+	v.SetString(strings.TrimRight(string(b), "\r\n"))
+	src[key] += ", secret file " + m[1]
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	logLevels     = []string{"debug", "info", "warn", "error", "dpanic", "panic", "fatal"}
+	logEncodings  = []string{"json", "console"}
+	sslModes      = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+	mailerDrivers = []string{"smtp", "file", "log"}
+	exporters     = []string{"none", "otlp", "stdout", "file"}
+	idemStores    = []string{"postgres", "redis"}
+)
+
+// ValidationError - все найденные проблемы конфига, по одной на строку.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(key, format string, args ...any) {
+	v.problems = append(v.problems, key+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) port(key string, p int, allowZero bool) {
+	if allowZero && p == 0 {
+		return
+	}
+	if p < 1 || p > 65535 {
+		v.addf(key, "must be between 1 and 65535, got %d", p)
+	}
+}
+
+func (v *validator) oneOf(key, value string, allowed []string) {
+	if !slices.Contains(allowed, strings.ToLower(value)) {
+		v.addf(key, "unknown value %q, expected one of %s", value, strings.Join(allowed, ", "))
+	}
+}
+
+func (v *validator) required(key, value string) {
+	if value == "" {
+		v.addf(key, "is required")
+	}
+}
+
+func (v *validator) nonNegative(key string, d time.Duration) {
+	if d < 0 {
+		v.addf(key, "must not be negative, got %s", d)
+	}
+}
+
+func (v *validator) positive(key string, d time.Duration) {
+	if d <= 0 {
+		v.addf(key, "must be positive, got %s", d)
+	}
+}
+
+func (v *validator) routeLimit(key string, l RouteLimit) {
+	if l.Requests < 0 {
+		v.addf(key+".requests", "must not be negative, got %d", l.Requests)
+	}
+	if l.Requests > 0 {
+		v.positive(key+".window", l.Window)
+	}
+}
+
+// validProxy - адрес или подсеть в том виде, который принимает gin.SetTrustedProxies.
+func validProxy(s string) bool {
+	if _, err := netip.ParsePrefix(s); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// Validate проверяет конфиг целиком и возвращает *ValidationError со всеми
+// проблемами, а не только с первой.
+func (c *Config) Validate() error {
+	var v validator
+
+	v.oneOf("logger.level", c.Logger.Level, logLevels)
+	v.oneOf("logger.encoding", c.Logger.Encoding, logEncodings)
+	for i, s := range c.Logger.Sinks {
+		key := fmt.Sprintf("logger.sinks[%d]", i)
+		v.required(key+".output", s.Output)
+		if s.Level != "" {
+			v.oneOf(key+".level", s.Level, logLevels)
+		}
+		if s.Encoding != "" {
+			v.oneOf(key+".encoding", s.Encoding, logEncodings)
+		}
+	}
+
+	v.port("server.port", c.Server.Port, false)
+	v.port("server.metricsPort", c.Server.MetricsPort, true)
+	if c.Server.MetricsPort != 0 && c.Server.MetricsPort == c.Server.Port {
+		v.addf("server.metricsPort", "must differ from server.port (%d)", c.Server.Port)
+	}
+	v.port("grpc.port", c.GRPC.Port, true)
+	if c.GRPC.Port != 0 && (c.GRPC.Port == c.Server.Port || c.GRPC.Port == c.Server.MetricsPort) {
+		v.addf("grpc.port", "must differ from server.port and server.metricsPort, got %d", c.GRPC.Port)
+	}
+	v.positive("server.readTimeout", c.Server.ReadTimeout)
+	v.positive("server.writeTimeout", c.Server.WriteTimeout)
+	v.nonNegative("server.idleTimeout", c.Server.IdleTimeout)
+	for i, p := range c.Server.TrustedProxies {
+		if !validProxy(p) {
+			v.addf(fmt.Sprintf("server.trustedProxies[%d]", i), "must be an IP or CIDR, got %q", p)
+		}
+	}
+
+	db := c.DataBase
+	if db.URL == "" {
+		v.required("database.host", db.Host)
+		v.required("database.user", db.User)
+		v.required("database.dbname", db.DBName)
+		v.port("database.port", db.Port, true)
+		v.oneOf("database.sslmode", db.SSLMode, sslModes)
+	}
+	if db.MaxConn < 1 {
+		v.addf("database.max_conns", "must be at least 1, got %d", db.MaxConn)
+	}
+	if db.MinConns < 0 || db.MinConns > db.MaxConn {
+		v.addf("database.min_conns", "must be between 0 and max_conns (%d), got %d", db.MaxConn, db.MinConns)
+	}
+	v.nonNegative("database.max_conn_lifetime", db.MaxConnLifetime)
+	v.nonNegative("database.max_conn_idle_time", db.MaxConnIdleTime)
+	v.nonNegative("database.health_check_period", db.HealthCheckPeriod)
+	v.nonNegative("database.statement_timeout", db.StatementTimeout)
+	v.nonNegative("database.connect_backoff", db.ConnectBackoff)
+	v.nonNegative("database.slow_query_threshold", db.SlowQueryThreshold)
+	v.nonNegative("database.max_replica_lag", db.MaxReplicaLag)
+
+	v.required("cache.address", c.Redis.Address)
+	v.nonNegative("cache.dialTimeout", c.Redis.DialTimeout)
+	v.nonNegative("cache.readTimeout", c.Redis.ReadTimeout)
+	v.nonNegative("cache.writeTimeout", c.Redis.WriteTimeout)
+
+	v.nonNegative("timeouts.shutdownGracePeriod", c.Timeouts.ShutdownGracePeriod)
+
+	v.positive("auth.sessionTTL", c.Auth.SessionTTL)
+	v.positive("auth.verifyTokenTTL", c.Auth.VerifyTokenTTL)
+	v.positive("auth.resetTokenTTL", c.Auth.ResetTokenTTL)
+	v.positive("auth.invitationTTL", c.Auth.InvitationTTL)
+	v.positive("auth.impersonationTTL", c.Auth.ImpersonationTTL)
+
+	v.oneOf("mailer.driver", c.Mailer.Driver, mailerDrivers)
+	if strings.EqualFold(c.Mailer.Driver, "smtp") {
+		v.required("mailer.host", c.Mailer.Host)
+		v.port("mailer.port", c.Mailer.Port, false)
+	}
+
+	v.oneOf("tracing.exporter", c.Tracing.Exporter, exporters)
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		v.addf("tracing.sampleRatio", "must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+
+	v.routeLimit("ratelimit.default", c.RateLimit.Default)
+	for _, route := range slices.Sorted(maps.Keys(c.RateLimit.Routes)) {
+		l := c.RateLimit.Routes[route]
+		if method, path, ok := strings.Cut(route, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			v.addf("ratelimit.routes", "key %q must look like \"POST /auth/login\"", route)
+		}
+		v.routeLimit(fmt.Sprintf("ratelimit.routes[%s]", route), l)
+	}
+	if lo := c.RateLimit.Lockout; lo.Threshold > 0 {
+		v.positive("ratelimit.lockout.window", lo.Window)
+		v.positive("ratelimit.lockout.baseDelay", lo.BaseDelay)
+		if lo.MaxDelay < lo.BaseDelay {
+			v.addf("ratelimit.lockout.maxDelay", "must not be less than baseDelay (%s), got %s", lo.BaseDelay, lo.MaxDelay)
+		}
+	} else if lo.Threshold < 0 {
+		v.addf("ratelimit.lockout.threshold", "must not be negative, got %d", lo.Threshold)
+	}
+
+	if idem := c.Idempotency; !idem.Disabled {
+		v.oneOf("idempotency.store", idem.Store, idemStores)
+		v.positive("idempotency.ttl", idem.TTL)
+		v.positive("idempotency.lockTimeout", idem.LockTimeout)
+		v.nonNegative("idempotency.wait", idem.Wait)
+	}
+
+	if rt := c.Realtime; !rt.Disabled {
+		v.positive("realtime.heartbeat", rt.Heartbeat)
+		if rt.MaxStreamsPerUser <= 0 {
+			v.addf("realtime.maxStreamsPerUser", "must be positive, got %d", rt.MaxStreamsPerUser)
+		}
+		v.positive("realtime.reconnectBackoff", rt.ReconnectBackoff)
+		v.positive("realtime.maxReconnectBackoff", rt.MaxReconnectBackoff)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce - редакторы и k8s при замене configmap дают пачку событий подряд,
+// перечитываем один раз после того, как они закончились.
+const reloadDebounce = 200 * time.Millisecond
+
+// Change - результат перечитывания конфига.
+type Change struct {
+	Old *Config
+	New *Config
+	// изменившиеся ключи, по алфавиту
+	Keys []string
+	// из них те, что без тега reload:"live": вступят в силу только после перезапуска
+	RestartRequired []string
+}
+
+// Changed - изменился ли ключ или что-то внутри секции, например Changed("logger").
+func (c Change) Changed(key string) bool {
+	for _, k := range c.Keys {
+		if k == key || strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Watcher перечитывает конфиг по изменению файлов или по запросу (SIGHUP) и
+// рассылает изменения подписчикам. Конфиг с ошибками не применяется.
+type Watcher struct {
+	opts    Options
+	current atomic.Pointer[Config]
+
+	// mu держится на всё перечитывание, чтобы подписчики получали изменения по порядку
+	mu     sync.Mutex
+	subs   map[int]func(Change)
+	nextID int
+}
+
+func NewWatcher(opts Options, current *Config) *Watcher {
+	w := &Watcher{opts: opts, subs: make(map[int]func(Change))}
+	w.current.Store(current)
+	return w
+}
+
+// Current - последний успешно загруженный конфиг. Ключи, которые требуют
+// перезапуска, в нём уже новые, хотя сервис ещё работает со старыми.
+func (w *Watcher) Current() *Config {
+	return w.current.Load()
+}
+
+// Feature - флаг из последнего загруженного конфига, для server.RequireFeature.
+func (w *Watcher) Feature(name string) bool {
+	return w.Current().Feature(name)
+}
+
+// Subscribe регистрирует fn, она вызывается после каждого перечитывания, в
+// котором что-то изменилось. Возвращает функцию отписки.
+func (w *Watcher) Subscribe(fn func(Change)) func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	id := w.nextID
+	w.nextID++
+	w.subs[id] = fn
+
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subs, id)
+	}
+}
+
+// Reload перечитывает конфиг и оповещает подписчиков. Если ничего не изменилось,
+// возвращает Change без ключей и никого не зовёт.
+func (w *Watcher) Reload() (Change, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	next, err := MustLoadConfig(w.opts)
+	if err != nil {
+		return Change{}, err
+	}
+
+	prev := w.current.Load()
+	change := diff(prev, next)
+	if len(change.Keys) == 0 {
+		return change, nil
+	}
+
+	w.current.Store(next)
+	for _, fn := range w.subs {
+		fn(change)
+	}
+
+	return change, nil
+}
+
+func diff(prev, next *Config) Change {
+	change := Change{Old: prev, New: next}
+
+	a, b := fields(prev), fields(next)
+	for i := range a {
+		if reflect.DeepEqual(a[i].value.Interface(), b[i].value.Interface()) {
+			continue
+		}
+		change.Keys = append(change.Keys, a[i].key)
+		if !a[i].live {
+			change.RestartRequired = append(change.RestartRequired, a[i].key)
+		}
+	}
+
+	sort.Strings(change.Keys)
+	sort.Strings(change.RestartRequired)
+	return change
+}
+
+// Watch следит за каталогом базового файла и перечитывает конфиг, когда меняется
+// он или файл профиля. Следим за каталогом, а не за файлом: многие редакторы и
+// k8s заменяют файл целиком, и наблюдение за старым inode теряется.
+// Ошибки перечитывания уходят в onReload вместе с результатом. Без файла
+// конфига сразу возвращает nil.
+func (w *Watcher) Watch(ctx context.Context, onReload func(Change, error)) error {
+	if w.opts.Path == "" {
+		return nil
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("config watcher: %w", err)
+	}
+	defer fw.Close()
+
+	dir := filepath.Dir(w.opts.Path)
+	if err := fw.Add(dir); err != nil {
+		return fmt.Errorf("config watcher: watch %s: %w", dir, err)
+	}
+
+	timer := time.NewTimer(reloadDebounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+			if w.relevant(ev.Name) && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+				timer.Reset(reloadDebounce)
+			}
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+			onReload(Change{}, fmt.Errorf("config watcher: %w", err))
+		case <-timer.C:
+			onReload(w.Reload())
+		}
+	}
+}
+
+// relevant - базовый файл, любой файл профиля рядом с ним или, для k8s,
+// служебный каталог ..data, через который configmap подменяет файлы.
+func (w *Watcher) relevant(name string) bool {
+	name = filepath.Clean(name)
+	base := filepath.Clean(w.opts.Path)
+	if name == base || filepath.Base(name) == "..data" {
+		return true
+	}
+
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	return strings.HasPrefix(name, stem+".") && strings.HasSuffix(name, ext)
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const watchBase = `
+logger:
+  level: info
+server:
+  port: 8080
+database:
+  host: db
+  user: mm
+  dbname: money
+`
+
+func TestWatcher_Reload(t *testing.T) {
+	path := writeConfig(t, watchBase)
+
+	cfg, err := MustLoadConfig(Options{Path: path})
+	require.NoError(t, err)
+
+	w := NewWatcher(Options{Path: path}, cfg)
+
+	var got []Change
+	unsubscribe := w.Subscribe(func(c Change) { got = append(got, c) })
+
+	// ничего не изменилось - подписчиков не зовём
+	change, err := w.Reload()
+	require.NoError(t, err)
+	require.Empty(t, change.Keys)
+	require.Empty(t, got)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+logger:
+  level: warn
+server:
+  port: 9000
+database:
+  host: db2
+  user: mm
+  dbname: money
+features:
+  new_reports: true
+`), 0o600))
+
+	change, err = w.Reload()
+	require.NoError(t, err)
+	require.Equal(t, []string{"database.host", "features", "logger.level", "server.port"}, change.Keys)
+	require.Equal(t, []string{"database.host", "server.port"}, change.RestartRequired)
+	require.True(t, change.Changed("logger"))
+	require.False(t, change.Changed("logger.encoding"))
+	require.Len(t, got, 1)
+	require.True(t, w.Feature("new_reports"))
+	// флаги из файла дополняют значения по умолчанию
+	require.True(t, w.Feature("households"))
+	require.Equal(t, "warn", w.Current().Logger.Level)
+
+	// конфиг с ошибкой не применяется
+	require.NoError(t, os.WriteFile(path, []byte("logger:\n  level: loud\n"), 0o600))
+	_, err = w.Reload()
+	require.Error(t, err)
+	require.Equal(t, "warn", w.Current().Logger.Level)
+
+	unsubscribe()
+	require.NoError(t, os.WriteFile(path, []byte(watchBase), 0o600))
+	_, err = w.Reload()
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+}
+
+func TestWatcher_WatchFile(t *testing.T) {
+	path := writeConfig(t, watchBase)
+
+	cfg, err := MustLoadConfig(Options{Path: path})
+	require.NoError(t, err)
+
+	w := NewWatcher(Options{Path: path}, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloaded := make(chan Change, 1)
+	go func() {
+		_ = w.Watch(ctx, func(c Change, err error) {
+			if err == nil && len(c.Keys) > 0 {
+				reloaded <- c
+			}
+		})
+	}()
+
+	// fsnotify начинает следить не мгновенно, пишем, пока не заметит
+	deadline := time.After(5 * time.Second)
+	for {
+		require.NoError(t, os.WriteFile(path, []byte(watchBase+"  max_conns: 20\n"), 0o600))
+		select {
+		case c := <-reloaded:
+			require.Equal(t, []string{"database.max_conns"}, c.Keys)
+			require.Equal(t, 20, w.Current().DataBase.MaxConn)
+			return
+		case <-time.After(500 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("config change was not noticed")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package delta - синхронизация с офлайн клиентами. Каждое изменение
+// синхронизируемой сущности увеличивает счётчик изменений её владельца (триггеры
+// и sync_touch в базе). Клиент забирает всё, что изменилось после его токена, и
+// отправляет пачку своих изменений. Конфликты решаются по полям.
+package delta
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid sync token")
+	// например, создать или удалить профиль пользователя
+	ErrNotSupported = errors.New("operation is not supported for this entity")
+	ErrInvalidField = errors.New("invalid field")
+	ErrNotFound     = errors.New("entity not found")
+)
+
+type Op string
+
+const (
+	OpUpsert Op = "upsert"
+	OpDelete Op = "delete"
+)
+
+// Entity - сущность в том же JSON виде, что и в REST API.
+type Entity map[string]any
+
+// Source - тип сущностей, которые синхронизируются. Реализуется доменным пакетом,
+// id сущности - строка: у новых сущностей это UUID, который сгенерировал клиент.
+type Source interface {
+	// Get - текущие сущности пользователя по id. Удалённых и чужих в ответе нет.
+	Get(ctx context.Context, userID int64, ids []string) (map[string]Entity, error)
+	Create(ctx context.Context, userID int64, id string, fields Entity) (Entity, error)
+	// Update меняет только переданные поля.
+	Update(ctx context.Context, userID int64, id string, fields Entity) (Entity, error)
+	Delete(ctx context.Context, userID int64, id string) error
+}
+
+// Change - изменение на сервере, которое клиент ещё не видел.
+type Change struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Op   Op     `json:"op"`
+	// у удалённых сущностей данных нет
+	Data      Entity    `json:"data,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type Page struct {
+	Changes []Change `json:"changes"`
+	// since для следующего запроса
+	Next    string `json:"next"`
+	HasMore bool   `json:"has_more"`
+}
+
+// ClientChange - изменение, сделанное на клиенте.
+type ClientChange struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Op   Op     `json:"op"`
+	// значения полей, которые клиент видел до своего изменения: по ним понятно,
+	// менял ли кто-то поле на сервере с тех пор
+	Base   Entity `json:"base,omitempty"`
+	Fields Entity `json:"fields,omitempty"`
+	// когда изменение сделано на клиенте, по нему решается конфликт
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type Status string
+
+const (
+	StatusApplied Status = "applied"
+	// часть полей или всё изменение уступило серверу, подробности в Conflicts
+	StatusConflict Status = "conflict"
+	StatusRejected Status = "rejected"
+)
+
+// Conflict - поле, которое поменяли и на сервере, и на клиенте.
+type Conflict struct {
+	Field  string `json:"field"`
+	Server any    `json:"server"`
+	Client any    `json:"client"`
+	// чьё значение осталось: server или client
+	Winner string `json:"winner"`
+}
+
+type Result struct {
+	Type      string     `json:"type"`
+	ID        string     `json:"id"`
+	Status    Status     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+	// состояние после синхронизации, клиент заменяет им свою копию. Нет, если сущность удалена
+	Data Entity `json:"data,omitempty"`
+}
+
+const tokenPrefix = "v1:"
+
+// EncodeToken - непрозрачный для клиента токен из номера изменения.
+func EncodeToken(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(tokenPrefix + strconv.FormatInt(seq, 10)))
+}
+
+// ParseToken - пустой токен значит "с самого начала".
+func ParseToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !strings.HasPrefix(string(raw), tokenPrefix) {
+		return 0, ErrInvalidToken
+	}
+
+	seq, err := strconv.ParseInt(strings.TrimPrefix(string(raw), tokenPrefix), 10, 64)
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("%w: bad sequence", ErrInvalidToken)
+	}
+
+	return seq, nil
+}
@@ -0,0 +1,266 @@
+package delta
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeLog struct {
+	entries []Entry
+}
+
+func (f *fakeLog) Since(ctx context.Context, userID, seq int64, limit int) ([]Entry, error) {
+	var out []Entry
+	for _, e := range f.entries {
+		if e.Seq > seq && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeLog) Entry(ctx context.Context, userID int64, entityType, id string) (Entry, bool, error) {
+	for _, e := range f.entries {
+		if e.Type == entityType && e.ID == id {
+			return e, true, nil
+		}
+	}
+	return Entry{}, false, nil
+}
+
+func (f *fakeLog) Head(ctx context.Context, userID int64) (int64, error) {
+	var seq int64
+	for _, e := range f.entries {
+		seq = max(seq, e.Seq)
+	}
+	return seq, nil
+}
+
+// fakeSource хранит сущности в памяти и ведёт журнал, как это делают триггеры
+type fakeSource struct {
+	log      *fakeLog
+	entities map[string]Entity
+	now      time.Time
+}
+
+func (f *fakeSource) Get(ctx context.Context, userID int64, ids []string) (map[string]Entity, error) {
+	out := make(map[string]Entity)
+	for _, id := range ids {
+		if e, ok := f.entities[id]; ok {
+			out[id] = e
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeSource) Create(ctx context.Context, userID int64, id string, fields Entity) (Entity, error) {
+	if _, ok := fields["name"]; !ok {
+		return nil, ErrInvalidField
+	}
+	f.entities[id] = fields
+	f.touch(id, false)
+	return fields, nil
+}
+
+func (f *fakeSource) Update(ctx context.Context, userID int64, id string, fields Entity) (Entity, error) {
+	for k, v := range fields {
+		f.entities[id][k] = v
+	}
+	f.touch(id, false)
+	return f.entities[id], nil
+}
+
+func (f *fakeSource) Delete(ctx context.Context, userID int64, id string) error {
+	delete(f.entities, id)
+	f.touch(id, true)
+	return nil
+}
+
+func (f *fakeSource) touch(id string, deleted bool) {
+	seq := int64(len(f.log.entries) + 1)
+	for i, e := range f.log.entries {
+		if e.ID == id {
+			f.log.entries = append(f.log.entries[:i], f.log.entries[i+1:]...)
+			break
+		}
+	}
+	f.log.entries = append(f.log.entries, Entry{Seq: seq, Type: "account", ID: id, Deleted: deleted, ChangedAt: f.now})
+}
+
+func newTestService() (*Service, *fakeSource) {
+	l := &fakeLog{}
+	src := &fakeSource{log: l, entities: map[string]Entity{}, now: t0}
+	return NewService(l, map[string]Source{"account": src}, nopLogger{}), src
+}
+
+func TestToken(t *testing.T) {
+	seq, err := ParseToken(EncodeToken(42))
+	require.NoError(t, err)
+	require.EqualValues(t, 42, seq)
+
+	seq, err = ParseToken("")
+	require.NoError(t, err)
+	require.Zero(t, seq)
+
+	for _, bad := range []string{"garbage", EncodeToken(1)[:3], "djE6LTE"} {
+		_, err := ParseToken(bad)
+		require.ErrorIs(t, err, ErrInvalidToken, bad)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	server := Entity{"name": "Cash", "balance": 100.0, "currency": "RUB"}
+	base := Entity{"name": "Cash", "balance": 50.0, "currency": "RUB"}
+
+	// name на сервере не менялся - берём клиентский; balance поменяли обе стороны
+	patch, conflicts := Merge(server, base, Entity{"name": "Wallet", "balance": 70.0, "currency": "RUB"}, false)
+	require.Equal(t, Entity{"name": "Wallet"}, patch)
+	require.Equal(t, []Conflict{{Field: "balance", Server: 100.0, Client: 70.0, Winner: winnerServer}}, conflicts)
+
+	patch, conflicts = Merge(server, base, Entity{"balance": 70.0}, true)
+	require.Equal(t, Entity{"balance": 70.0}, patch)
+	require.Equal(t, winnerClient, conflicts[0].Winner)
+
+	// без base клиент не знает, что было раньше, и просто перезаписывает поле
+	patch, conflicts = Merge(server, nil, Entity{"balance": 1}, false)
+	require.Equal(t, Entity{"balance": 1}, patch)
+	require.Empty(t, conflicts)
+
+	// одинаковое значение с обеих сторон - не конфликт, 100 и 100.0 равны
+	patch, conflicts = Merge(server, base, Entity{"balance": 100}, false)
+	require.Empty(t, patch)
+	require.Empty(t, conflicts)
+}
+
+func TestService_PushAndPull(t *testing.T) {
+	svc, src := newTestService()
+	ctx := context.Background()
+	const id = "0b6f1f4e-7a57-4d7c-9a59-0c2d7c0e6f10"
+
+	results, err := svc.Push(ctx, 1, []ClientChange{
+		{Type: "account", ID: id, Op: OpUpsert, Fields: Entity{"name": "Cash", "balance": 10.0}, ChangedAt: t0},
+		{Type: "account", ID: "not-a-uuid", Op: OpUpsert, Fields: Entity{"name": "Card"}, ChangedAt: t0},
+		{Type: "budget", ID: id, Op: OpUpsert, ChangedAt: t0},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Equal(t, StatusApplied, results[0].Status)
+	require.Equal(t, StatusRejected, results[1].Status)
+	require.Equal(t, StatusRejected, results[2].Status)
+
+	page, err := svc.Pull(ctx, 1, "", 10)
+	require.NoError(t, err)
+	require.False(t, page.HasMore)
+	require.Equal(t, []Change{{Type: "account", ID: id, Op: OpUpsert, Data: src.entities[id], ChangedAt: t0}}, page.Changes)
+
+	// с последним токеном изменений нет, токен не меняется
+	next := page.Next
+	head, err := svc.Head(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, next, head)
+
+	page, err = svc.Pull(ctx, 1, next, 10)
+	require.NoError(t, err)
+	require.Empty(t, page.Changes)
+	require.Equal(t, next, page.Next)
+
+	// клиент удалил сущность: следующий pull отдаёт tombstone
+	results, err = svc.Push(ctx, 1, []ClientChange{{Type: "account", ID: id, Op: OpDelete, ChangedAt: t0.Add(time.Minute)}})
+	require.NoError(t, err)
+	require.Equal(t, StatusApplied, results[0].Status)
+
+	page, err = svc.Pull(ctx, 1, next, 10)
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Type: "account", ID: id, Op: OpDelete, ChangedAt: t0}}, page.Changes)
+
+	// правка удалённой на сервере сущности её не воскрешает
+	results, err = svc.Push(ctx, 1, []ClientChange{{Type: "account", ID: id, Op: OpUpsert, Fields: Entity{"name": "Cash"}, ChangedAt: t0.Add(time.Hour)}})
+	require.NoError(t, err)
+	require.Equal(t, StatusConflict, results[0].Status)
+	require.Empty(t, src.entities)
+}
+
+func TestService_PushConflict(t *testing.T) {
+	svc, src := newTestService()
+	ctx := context.Background()
+	const id = "0b6f1f4e-7a57-4d7c-9a59-0c2d7c0e6f10"
+
+	src.entities[id] = Entity{"name": "Cash", "balance": 100.0}
+	src.touch(id, false)
+
+	// сервер изменил balance позже клиента - сервер и побеждает, name применяется
+	results, err := svc.Push(ctx, 1, []ClientChange{{
+		Type: "account", ID: id, Op: OpUpsert, ChangedAt: t0.Add(-time.Minute),
+		Base:   Entity{"name": "Cash", "balance": 50.0},
+		Fields: Entity{"name": "Wallet", "balance": 70.0},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, StatusConflict, results[0].Status)
+	require.Equal(t, []Conflict{{Field: "balance", Server: 100.0, Client: 70.0, Winner: winnerServer}}, results[0].Conflicts)
+	require.Equal(t, Entity{"name": "Wallet", "balance": 100.0}, results[0].Data)
+
+	// удаление клиента старше правки на сервере, которую клиент не видел
+	results, err = svc.Push(ctx, 1, []ClientChange{{
+		Type: "account", ID: id, Op: OpDelete, ChangedAt: t0.Add(-time.Minute),
+		Base: Entity{"balance": 50.0},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, StatusConflict, results[0].Status)
+	require.Contains(t, src.entities, id)
+}
+
+func TestService_PullPages(t *testing.T) {
+	svc, src := newTestService()
+	for _, id := range []string{"a", "b", "c"} {
+		src.entities[id] = Entity{"name": id}
+		src.touch(id, false)
+	}
+
+	page, err := svc.Pull(context.Background(), 1, "", 2)
+	require.NoError(t, err)
+	require.True(t, page.HasMore)
+	require.Len(t, page.Changes, 2)
+
+	page, err = svc.Pull(context.Background(), 1, page.Next, 2)
+	require.NoError(t, err)
+	require.False(t, page.HasMore)
+	require.Len(t, page.Changes, 1)
+	require.Equal(t, "c", page.Changes[0].ID)
+}
+
+func TestLog_Since(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	mock.ExpectQuery("select seq, entity_type, entity_id, deleted, changed_at from sync_log").
+		WithArgs(int64(1), int64(5), 10).
+		WillReturnRows(pgxmock.NewRows([]string{"seq", "entity_type", "entity_id", "deleted", "changed_at"}).
+			AddRow(int64(6), "user", "1", false, t0).
+			AddRow(int64(7), "account", "x", true, t0))
+
+	entries, err := NewLog(&storage.DB{Pool: mock}).Since(context.Background(), 1, 5, 10)
+	require.NoError(t, err)
+	require.Equal(t, []Entry{
+		{Seq: 6, Type: "user", ID: "1", ChangedAt: t0},
+		{Seq: 7, Type: "account", ID: "x", Deleted: true, ChangedAt: t0},
+	}, entries)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
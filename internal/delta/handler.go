@@ -0,0 +1,86 @@
+package delta
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+const (
+	defaultLimit = 200
+	maxLimit     = 1000
+	// больше за раз клиент отправлять не должен, остальное - следующим запросом
+	maxChanges = 500
+)
+
+type Handler struct {
+	svc *Service
+	log logger.Logger
+}
+
+func NewHandler(svc *Service, log logger.Logger) *Handler {
+	return &Handler{svc: svc, log: log}
+}
+
+// Register ожидает, что r уже требует аутентификацию.
+func (h *Handler) Register(r gin.IRouter) {
+	r.GET("/sync", h.pull)
+	r.POST("/sync", h.push)
+}
+
+type pushRequest struct {
+	Changes []ClientChange `json:"changes" binding:"required"`
+}
+
+func (h *Handler) pull(c *gin.Context) {
+	limit := defaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxLimit {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	page, err := h.svc.Pull(c.Request.Context(), rbac.PrincipalFrom(c).UserID, c.Query("since"), limit)
+	if errors.Is(err, ErrInvalidToken) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *Handler) push(c *gin.Context) {
+	var req pushRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Changes) > maxChanges {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "too many changes"})
+		return
+	}
+
+	results, err := h.svc.Push(c.Request.Context(), rbac.PrincipalFrom(c).UserID, req.Changes)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	h.log.Error(c.Request.Context(), "sync handler failed", logger.Field{Key: "error", Value: err})
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,93 @@
+package delta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/skinkvi/money_managment/internal/storage"
+)
+
+// Entry - последнее изменение одной сущности из sync_log.
+type Entry struct {
+	Seq       int64
+	Type      string
+	ID        string
+	Deleted   bool
+	ChangedAt time.Time
+}
+
+// Log - журнал изменений, его пишут триггеры в базе.
+type Log interface {
+	// Since - изменения пользователя после seq по возрастанию номера, не больше limit.
+	Since(ctx context.Context, userID, seq int64, limit int) ([]Entry, error)
+	// Entry - последнее изменение сущности, ok false, если изменений не было.
+	Entry(ctx context.Context, userID int64, entityType, id string) (Entry, bool, error)
+	// Head - номер последнего изменения пользователя, 0 если изменений не было.
+	Head(ctx context.Context, userID int64) (int64, error)
+}
+
+// журнал читается только с основной базы: номер с реплики может опередить
+// данные, которые потом загрузятся с другой реплики
+type pgLog struct {
+	db *storage.DB
+}
+
+func NewLog(db *storage.DB) Log {
+	return &pgLog{db: db}
+}
+
+func (l *pgLog) Since(ctx context.Context, userID, seq int64, limit int) ([]Entry, error) {
+	const query = `select seq, entity_type, entity_id, deleted, changed_at from sync_log
+	where user_id = $1 and seq > $2 order by seq limit $3`
+
+	rows, err := l.db.Pool.Query(storage.WithQueryName(ctx, "delta.Since"), query, userID, seq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed query Since sync log: %w", err)
+	}
+
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Entry, error) {
+		var e Entry
+		err := row.Scan(&e.Seq, &e.Type, &e.ID, &e.Deleted, &e.ChangedAt)
+		return e, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query Since sync log: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (l *pgLog) Entry(ctx context.Context, userID int64, entityType, id string) (Entry, bool, error) {
+	const query = `select seq, entity_type, entity_id, deleted, changed_at from sync_log
+	where user_id = $1 and entity_type = $2 and entity_id = $3`
+
+	var e Entry
+	err := l.db.Pool.QueryRow(storage.WithQueryName(ctx, "delta.Entry"), query, userID, entityType, id).
+		Scan(&e.Seq, &e.Type, &e.ID, &e.Deleted, &e.ChangedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("failed query Entry sync log: %w", err)
+	}
+
+	return e, true, nil
+}
+
+func (l *pgLog) Head(ctx context.Context, userID int64) (int64, error) {
+	const query = `select seq from sync_state where user_id = $1`
+
+	var seq int64
+	err := l.db.Pool.QueryRow(storage.WithQueryName(ctx, "delta.Head"), query, userID).Scan(&seq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed query Head sync state: %w", err)
+	}
+
+	return seq, nil
+}
@@ -0,0 +1,272 @@
+package delta
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+const (
+	winnerServer = "server"
+	winnerClient = "client"
+)
+
+type Service struct {
+	changes Log
+	sources map[string]Source
+	log     logger.Logger
+}
+
+// NewService - sources по типу сущности, тот же тип пишет в sync_log триггер.
+func NewService(changes Log, sources map[string]Source, log logger.Logger) *Service {
+	return &Service{changes: changes, sources: sources, log: log}
+}
+
+// Pull - изменения после токена since. Удалённые сущности приходят без данных.
+func (s *Service) Pull(ctx context.Context, userID int64, since string, limit int) (Page, error) {
+	seq, err := ParseToken(since)
+	if err != nil {
+		return Page{}, err
+	}
+
+	// сущности читаются с основной базы, иначе можно отдать данные старее номера в токене
+	ctx = storage.WithPrimary(ctx)
+
+	entries, err := s.changes.Since(ctx, userID, seq, limit+1)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page := Page{Changes: []Change{}, Next: EncodeToken(seq)}
+	if len(entries) > limit {
+		entries, page.HasMore = entries[:limit], true
+	}
+	if len(entries) == 0 {
+		return page, nil
+	}
+	page.Next = EncodeToken(entries[len(entries)-1].Seq)
+
+	ids := make(map[string][]string)
+	for _, e := range entries {
+		if !e.Deleted {
+			ids[e.Type] = append(ids[e.Type], e.ID)
+		}
+	}
+
+	loaded := make(map[string]map[string]Entity, len(ids))
+	for typ, list := range ids {
+		src, ok := s.sources[typ]
+		if !ok {
+			continue
+		}
+		loaded[typ], err = src.Get(ctx, userID, list)
+		if err != nil {
+			return Page{}, fmt.Errorf("failed load %s: %w", typ, err)
+		}
+	}
+
+	for _, e := range entries {
+		// тип, которого сервер ещё не умеет отдавать, клиенту тоже не нужен
+		if _, ok := s.sources[e.Type]; !ok {
+			continue
+		}
+
+		ch := Change{Type: e.Type, ID: e.ID, Op: OpDelete, ChangedAt: e.ChangedAt}
+		// сущность могли удалить между чтением журнала и загрузкой, тогда это тоже удаление
+		if data, ok := loaded[e.Type][e.ID]; ok && !e.Deleted {
+			ch.Op, ch.Data = OpUpsert, data
+		}
+		page.Changes = append(page.Changes, ch)
+	}
+
+	return page, nil
+}
+
+// Head - токен, после которого изменений пока нет. С него начинает клиент,
+// которому нужны только новые изменения.
+func (s *Service) Head(ctx context.Context, userID int64) (string, error) {
+	seq, err := s.changes.Head(storage.WithPrimary(ctx), userID)
+	if err != nil {
+		return "", err
+	}
+
+	return EncodeToken(seq), nil
+}
+
+// Push применяет изменения клиента по порядку. Ошибка в одном изменении не мешает
+// остальным, она попадает в его Result. error возвращается только при сбое базы.
+func (s *Service) Push(ctx context.Context, userID int64, changes []ClientChange) ([]Result, error) {
+	ctx = storage.WithPrimary(ctx)
+
+	results := make([]Result, 0, len(changes))
+	for _, ch := range changes {
+		res, err := s.apply(ctx, userID, ch)
+		switch {
+		case errors.Is(err, ErrNotSupported), errors.Is(err, ErrInvalidField), errors.Is(err, ErrNotFound):
+			res = Result{Type: ch.Type, ID: ch.ID, Status: StatusRejected, Error: err.Error()}
+			s.log.Info(ctx, "sync change rejected",
+				logger.Field{Key: "type", Value: ch.Type},
+				logger.Field{Key: "id", Value: ch.ID},
+				logger.Field{Key: "error", Value: err},
+			)
+		case err != nil:
+			return nil, fmt.Errorf("failed apply %s %s: %w", ch.Type, ch.ID, err)
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+func (s *Service) apply(ctx context.Context, userID int64, ch ClientChange) (Result, error) {
+	res := Result{Type: ch.Type, ID: ch.ID}
+
+	src, ok := s.sources[ch.Type]
+	if !ok {
+		return res, fmt.Errorf("%w: unknown entity type %q", ErrNotSupported, ch.Type)
+	}
+	if ch.ID == "" {
+		return res, fmt.Errorf("%w: id is required", ErrInvalidField)
+	}
+	if ch.Op != OpUpsert && ch.Op != OpDelete {
+		return res, fmt.Errorf("%w: unknown op %q", ErrInvalidField, ch.Op)
+	}
+
+	current, err := src.Get(ctx, userID, []string{ch.ID})
+	if err != nil {
+		return res, err
+	}
+	server, exists := current[ch.ID]
+
+	entry, logged, err := s.changes.Entry(ctx, userID, ch.Type, ch.ID)
+	if err != nil {
+		return res, err
+	}
+	// при равном времени побеждает клиент: он отправляет изменение позже, чем сервер его записал
+	clientWins := !logged || !entry.ChangedAt.After(ch.ChangedAt)
+
+	if ch.Op == OpDelete {
+		if !exists {
+			res.Status = StatusApplied
+			return res, nil
+		}
+
+		// правка на сервере, которую клиент не видел и которая новее удаления, сохраняет сущность
+		if !clientWins && len(changedSince(server, ch.Base)) > 0 {
+			res.Status, res.Data = StatusConflict, server
+			res.Error = "entity was modified on the server"
+			return res, nil
+		}
+
+		if err := src.Delete(ctx, userID, ch.ID); err != nil {
+			return res, err
+		}
+		res.Status = StatusApplied
+		return res, nil
+	}
+
+	if !exists {
+		// удаление на сервере побеждает правку: иначе удалённая сущность воскресла бы
+		if logged && entry.Deleted {
+			res.Status = StatusConflict
+			res.Error = "entity was deleted on the server"
+			return res, nil
+		}
+
+		if _, err := uuid.Parse(ch.ID); err != nil {
+			return res, fmt.Errorf("%w: id of a new entity must be a UUID", ErrInvalidField)
+		}
+
+		created, err := src.Create(ctx, userID, ch.ID, ch.Fields)
+		if err != nil {
+			return res, err
+		}
+		res.Status, res.Data = StatusApplied, created
+		return res, nil
+	}
+
+	patch, conflicts := Merge(server, ch.Base, ch.Fields, clientWins)
+
+	res.Status, res.Data, res.Conflicts = StatusApplied, server, conflicts
+	if len(conflicts) > 0 {
+		res.Status = StatusConflict
+	}
+
+	if len(patch) > 0 {
+		updated, err := src.Update(ctx, userID, ch.ID, patch)
+		if err != nil {
+			return res, err
+		}
+		res.Data = updated
+	}
+
+	return res, nil
+}
+
+// Merge - трёхсторонее слияние по полям. Поле из client применяется, если на сервере
+// оно с base не менялось. Если поменялись обе стороны, это конфликт, и его решает
+// clientWins (last-write-wins по времени изменения). Без base считается, что сервер
+// поле не трогал. Возвращает поля, которые нужно записать.
+func Merge(server, base, client Entity, clientWins bool) (Entity, []Conflict) {
+	patch := make(Entity)
+	var conflicts []Conflict
+
+	for _, field := range sortedKeys(client) {
+		value := client[field]
+		current, ok := server[field]
+		if ok && equal(current, value) {
+			continue
+		}
+
+		baseValue, hasBase := base[field]
+		if !ok || !hasBase || equal(current, baseValue) {
+			patch[field] = value
+			continue
+		}
+
+		c := Conflict{Field: field, Server: current, Client: value, Winner: winnerServer}
+		if clientWins {
+			c.Winner = winnerClient
+			patch[field] = value
+		}
+		conflicts = append(conflicts, c)
+	}
+
+	return patch, conflicts
+}
+
+// changedSince - поля base, которые на сервере уже другие.
+func changedSince(server, base Entity) []string {
+	var changed []string
+	for _, field := range sortedKeys(base) {
+		if current, ok := server[field]; ok && !equal(current, base[field]) {
+			changed = append(changed, field)
+		}
+	}
+
+	return changed
+}
+
+// значения приходят из JSON, поэтому сравниваются по JSON: 1 и 1.0 равны
+func equal(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+func sortedKeys(e Entity) []string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,203 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	mmv1 "github.com/skinkvi/money_managment/api/gen/mm/v1"
+	"github.com/skinkvi/money_managment/internal/admin"
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/auth"
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/metrics"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+// fakeAuth: токен - это роль, id у admin 1, у user 2
+type fakeAuth struct{}
+
+func (fakeAuth) Authenticate(ctx context.Context, token string) (*rbac.Principal, error) {
+	switch token {
+	case "admin":
+		return &rbac.Principal{UserID: 1, Role: rbac.RoleAdmin}, nil
+	case "user":
+		return &rbac.Principal{UserID: 2, Role: rbac.RoleUser}, nil
+	}
+	return nil, auth.ErrUnauthenticated
+}
+
+type fakeRepo struct {
+	user.Repository
+	users map[int64]*user.User
+}
+
+func (f *fakeRepo) GetByID(ctx context.Context, id int64) (*user.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	c := *u
+	return &c, nil
+}
+
+func (f *fakeRepo) Patch(ctx context.Context, id, version int64, patch user.Patch) (*user.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	if u.Version != version {
+		return nil, storage.ErrVersionConflict
+	}
+	if patch.Username != nil {
+		u.Username = *patch.Username
+	}
+	u.Version++
+	c := *u
+	return &c, nil
+}
+
+func (f *fakeRepo) List(ctx context.Context, limit, offset int) ([]user.User, error) {
+	return []user.User{*f.users[1], *f.users[2]}, nil
+}
+
+func (f *fakeRepo) Count(ctx context.Context) (int64, error) {
+	return int64(len(f.users)), nil
+}
+
+type fakeAudit struct {
+	audit.Repository
+}
+
+func (fakeAudit) Append(ctx context.Context, e audit.Entry) error { return nil }
+
+type testServer struct {
+	conn *grpc.ClientConn
+	reg  *prometheus.Registry
+	stop func() error
+}
+
+func startServer(t *testing.T) *testServer {
+	t.Helper()
+
+	repo := &fakeRepo{users: map[int64]*user.User{
+		1: {ID: 1, Username: "root", Role: rbac.RoleAdmin, Version: 1},
+		2: {ID: 2, Username: "dima", Role: rbac.RoleUser, Version: 3},
+	}}
+
+	cfg := &config.Config{GRPC: config.GRPCConfig{Reflection: true}, Timeouts: config.Timeouts{ShutdownGracePeriod: time.Second}}
+	reg := prometheus.NewRegistry()
+	srv := New(cfg, fakeAuth{}, metrics.NewGRPC(reg), nopLogger{})
+	mmv1.RegisterUserServiceServer(srv, NewUserService(repo, admin.NewService(repo, nil, fakeAudit{}, nopLogger{}), nopLogger{}))
+
+	lis := bufconn.Listen(1 << 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return &testServer{conn: conn, reg: reg, stop: func() error {
+		cancel()
+		return <-done
+	}}
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestServer_AuthAndHealth(t *testing.T) {
+	s := startServer(t)
+	users := mmv1.NewUserServiceClient(s.conn)
+
+	_, err := users.GetUser(context.Background(), &mmv1.GetUserRequest{Id: 2})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = users.GetUser(withToken("bogus"), &mmv1.GetUserRequest{Id: 2})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// health доступен без сессии
+	health := healthpb.NewHealthClient(s.conn)
+	resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "mm.v1.UserService"})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+
+	require.NoError(t, testutil.GatherAndCompare(s.reg, strings.NewReader(`
+# HELP mm_grpc_requests_total Количество gRPC вызовов по методу и коду.
+# TYPE mm_grpc_requests_total counter
+mm_grpc_requests_total{code="OK",method="/grpc.health.v1.Health/Check"} 1
+mm_grpc_requests_total{code="Unauthenticated",method="/mm.v1.UserService/GetUser"} 2
+`), "mm_grpc_requests_total"))
+	require.NoError(t, s.stop())
+}
+
+func TestUserService(t *testing.T) {
+	s := startServer(t)
+	users := mmv1.NewUserServiceClient(s.conn)
+	ctx := withToken("user")
+
+	u, err := users.GetUser(ctx, &mmv1.GetUserRequest{Id: 2})
+	require.NoError(t, err)
+	require.Equal(t, "dima", u.GetUsername())
+	require.Equal(t, mmv1.Role_ROLE_USER, u.GetRole())
+
+	// чужой профиль не отличается от несуществующего
+	_, err = users.GetUser(ctx, &mmv1.GetUserRequest{Id: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	name := "dmitry"
+	_, err = users.UpdateUser(ctx, &mmv1.UpdateUserRequest{Id: 2, Version: 1, Username: &name})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	u, err = users.UpdateUser(ctx, &mmv1.UpdateUserRequest{Id: 2, Version: 3, Username: &name})
+	require.NoError(t, err)
+	require.Equal(t, "dmitry", u.GetUsername())
+	require.Equal(t, int64(4), u.GetVersion())
+
+	// без версии запись не проходит, даже если профиль не менялся
+	_, err = users.UpdateUser(ctx, &mmv1.UpdateUserRequest{Id: 2, Username: &name})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "version is required")
+
+	_, err = users.ListUsers(ctx, &mmv1.ListUsersRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	list, err := users.ListUsers(withToken("admin"), &mmv1.ListUsersRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, list.GetUsers(), 2)
+	require.Equal(t, int64(2), list.GetTotal())
+
+	_, err = users.ListUsers(withToken("admin"), &mmv1.ListUsersRequest{Limit: 1000})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	require.NoError(t, s.stop())
+}
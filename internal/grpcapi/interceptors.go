@@ -0,0 +1,195 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/auth"
+	"github.com/skinkvi/money_managment/internal/metrics"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/server"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// коды, которые значат сбой у нас, а не ошибку клиента
+var serverCodes = map[codes.Code]bool{
+	codes.Unknown:     true,
+	codes.Internal:    true,
+	codes.DataLoss:    true,
+	codes.Unavailable: true,
+}
+
+func observeUnary(m *metrics.GRPC, log logger.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx = withRequestMeta(ctx)
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observe(ctx, m, log, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func observeStream(m *metrics.GRPC, log logger.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := withRequestMeta(ss.Context())
+		start := time.Now()
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		observe(ctx, m, log, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// withRequestMeta - то же, что server.RequestID и audit.Middleware для HTTP:
+// id запроса из x-request-id или новый, и адрес клиента для журнала.
+func withRequestMeta(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(strings.ToLower(server.RequestIDHeader)); len(v) > 0 {
+			id = v[0]
+		}
+	}
+	id = server.NormalizeRequestID(id)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(server.RequestIDHeader), id))
+
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+
+	ctx = logger.WithRequestID(ctx, id)
+	return audit.WithMeta(ctx, audit.Meta{RequestID: id, IP: ip})
+}
+
+func observe(ctx context.Context, m *metrics.GRPC, log logger.Logger, method string, start time.Time, err error) {
+	code := status.Code(err)
+	d := time.Since(start)
+	m.Observe(method, code.String(), d)
+
+	fields := []logger.Field{
+		{Key: "method", Value: method},
+		{Key: "code", Value: code.String()},
+		{Key: "duration", Value: d},
+	}
+	if serverCodes[code] {
+		log.Error(ctx, "grpc request failed", append(fields, logger.Field{Key: "error", Value: err})...)
+		return
+	}
+
+	log.Info(ctx, "grpc request", fields...)
+}
+
+func recoverUnary(log logger.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ctx, log, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func recoverStream(log logger.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ss.Context(), log, info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recovered(ctx context.Context, log logger.Logger, method string, r any) error {
+	log.Error(ctx, "grpc handler panicked",
+		logger.Field{Key: "method", Value: method},
+		logger.Field{Key: "panic", Value: r},
+		logger.Field{Key: "stack", Value: string(debug.Stack())})
+	return status.Error(codes.Internal, "internal error")
+}
+
+// public - методы без сессии: health и reflection.
+func public(method string) bool {
+	return strings.HasPrefix(method, "/grpc.health.v1.Health/") || strings.HasPrefix(method, "/grpc.reflection.")
+}
+
+func authUnary(authn Authenticator, log logger.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if public(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authenticate(ctx, authn, log)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func authStream(authn Authenticator, log logger.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if public(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		ctx, err := authenticate(ss.Context(), authn, log)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate - то же, что auth.RequireUser: сессия из authorization: Bearer <token>.
+func authenticate(ctx context.Context, authn Authenticator, log logger.Logger) (context.Context, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			scheme, t, ok := strings.Cut(v[0], " ")
+			if ok && strings.EqualFold(scheme, "Bearer") {
+				token = strings.TrimSpace(t)
+			}
+		}
+	}
+
+	p, err := authn.Authenticate(ctx, token)
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		log.Error(ctx, "failed to authenticate grpc request", logger.Field{Key: "error", Value: err})
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	ctx = rbac.WithPrincipal(ctx, p)
+	fields := []logger.Field{{Key: logger.UserIDKey, Value: p.UserID}}
+	if p.ImpersonatorID != 0 {
+		fields = append(fields, logger.Field{Key: "impersonator_id", Value: p.ImpersonatorID})
+	}
+
+	return logger.WithFields(ctx, fields...), nil
+}
+
+// serverStream подменяет context потока.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
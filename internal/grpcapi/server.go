@@ -0,0 +1,128 @@
+// Package grpcapi - gRPC API рядом с HTTP. Сервисы работают поверх тех же
+// репозиториев и сервисов, что и HTTP хендлеры, и с той же аутентификацией по сессиям.
+//
+// Пока есть только UserService. Счетов и транзакций в сервисном слое ещё нет,
+// их protobuf описания и сервисы появятся вместе с ними.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/metrics"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// Authenticator - то, что серверу нужно от auth.Service.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*rbac.Principal, error)
+}
+
+type Server struct {
+	srv             *grpc.Server
+	health          *health.Server
+	addr            string
+	log             logger.Logger
+	shutdownTimeout time.Duration
+}
+
+// New создаёт сервер с health и, если включено, reflection. Сервисы API
+// регистрируются через RegisterService до Run.
+func New(cfg *config.Config, authn Authenticator, m *metrics.GRPC, log logger.Logger) *Server {
+	log = log.With(logger.Field{Key: "server", Value: "grpc"})
+
+	s := &Server{
+		health:          health.NewServer(),
+		addr:            net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.GRPC.Port)),
+		log:             log,
+		shutdownTimeout: cfg.Timeouts.ShutdownGracePeriod,
+	}
+
+	// первый перехватчик внешний: лог и метрики видят и паники, и отказы аутентификации
+	s.srv = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(observeUnary(m, log), recoverUnary(log), authUnary(authn, log)),
+		grpc.ChainStreamInterceptor(observeStream(m, log), recoverStream(log), authStream(authn, log)),
+	)
+
+	healthpb.RegisterHealthServer(s.srv, s.health)
+	if cfg.GRPC.Reflection {
+		reflection.Register(s.srv)
+	}
+
+	return s
+}
+
+// RegisterService - Server реализует grpc.ServiceRegistrar, сгенерированные
+// RegisterXxxServer принимают его напрямую.
+func (s *Server) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	s.srv.RegisterService(desc, impl)
+}
+
+// Run блокируется до отмены ctx, после чего даёт активным вызовам
+// shutdownTimeout на завершение и обрывает оставшиеся.
+func (s *Server) Run(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("grpc server: %w", err)
+	}
+
+	return s.Serve(ctx, lis)
+}
+
+// Serve - то же, что Run, на готовом listener.
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	for name := range s.srv.GetServiceInfo() {
+		s.health.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		s.log.Info(ctx, "grpc server started", logger.Field{Key: "addr", Value: lis.Addr().String()})
+		if err := s.srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	select {
+	case err, ok := <-errCh:
+		if ok {
+			return fmt.Errorf("grpc server: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	// клиенты с health-check перестают слать новые вызовы, пока дорабатывают текущие
+	s.health.Shutdown()
+
+	s.log.Info(ctx, "shutting down grpc server")
+
+	stopped := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(s.shutdownTimeout):
+		s.log.Warn(ctx, "grpc server did not stop in time, closing connections")
+		s.srv.Stop()
+		<-stopped
+	}
+
+	return nil
+}
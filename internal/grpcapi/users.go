@@ -0,0 +1,149 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	mmv1 "github.com/skinkvi/money_managment/api/gen/mm/v1"
+	"github.com/skinkvi/money_managment/internal/admin"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// UserService - gRPC версия user.Handler и списка пользователей из admin.Handler.
+type UserService struct {
+	mmv1.UnimplementedUserServiceServer
+
+	users user.Repository
+	admin *admin.Service
+	log   logger.Logger
+}
+
+func NewUserService(users user.Repository, adminSvc *admin.Service, log logger.Logger) *UserService {
+	return &UserService{users: users, admin: adminSvc, log: log}
+}
+
+func (s *UserService) GetUser(ctx context.Context, req *mmv1.GetUserRequest) (*mmv1.User, error) {
+	if err := s.checkOwner(ctx, req.GetId(), rbac.PermUsersRead); err != nil {
+		return nil, err
+	}
+
+	u, err := s.users.GetByID(ctx, req.GetId())
+	if err != nil {
+		return nil, s.toStatus(ctx, err)
+	}
+
+	return toProto(u), nil
+}
+
+func (s *UserService) UpdateUser(ctx context.Context, req *mmv1.UpdateUserRequest) (*mmv1.User, error) {
+	if err := s.checkOwner(ctx, req.GetId(), rbac.PermUsersWrite); err != nil {
+		return nil, err
+	}
+
+	// без версии запись затёрла бы чужие изменения молча
+	if req.GetVersion() == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "version is required")
+	}
+
+	u, err := s.users.Patch(ctx, req.GetId(), req.GetVersion(), user.Patch{Username: req.Username, Email: req.Email})
+	if err != nil {
+		return nil, s.toStatus(ctx, err)
+	}
+
+	return toProto(u), nil
+}
+
+func (s *UserService) ListUsers(ctx context.Context, req *mmv1.ListUsersRequest) (*mmv1.ListUsersResponse, error) {
+	p := rbac.PrincipalFromContext(ctx)
+	if !p.Can(rbac.PermUsersRead) {
+		return nil, status.Error(codes.PermissionDenied, rbac.ErrForbidden.Error())
+	}
+
+	limit, offset := int(req.GetLimit()), int(req.GetOffset())
+	if limit == 0 {
+		limit = defaultLimit
+	}
+	if limit < 0 || limit > maxLimit {
+		return nil, status.Error(codes.InvalidArgument, "invalid limit")
+	}
+	if offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid offset")
+	}
+
+	users, total, err := s.admin.ListUsers(ctx, p, limit, offset)
+	if err != nil {
+		return nil, s.toStatus(ctx, err)
+	}
+
+	resp := &mmv1.ListUsersResponse{Users: make([]*mmv1.User, 0, len(users)), Total: total}
+	for i := range users {
+		resp.Users = append(resp.Users, toProto(&users[i]))
+	}
+
+	return resp, nil
+}
+
+// checkOwner - то же, что rbac.RequireOwnerOr: чужой пользователь без права не
+// отличается от несуществующего.
+func (s *UserService) checkOwner(ctx context.Context, id int64, perm rbac.Permission) error {
+	if id <= 0 {
+		return status.Error(codes.InvalidArgument, "invalid user id")
+	}
+	if !rbac.PrincipalFromContext(ctx).CanAccess(id, perm) {
+		return status.Error(codes.NotFound, "not found")
+	}
+
+	return nil
+}
+
+func (s *UserService) toStatus(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, storage.ErrVersionConflict):
+		return status.Error(codes.FailedPrecondition, "user was modified by another request")
+	case errors.Is(err, storage.ErrUserAlreadyExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, user.ErrEmptyPatch), errors.Is(err, user.ErrInvalidPatch):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, rbac.ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		s.log.Error(ctx, "user service failed", logger.Field{Key: "error", Value: err})
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+var roles = map[rbac.Role]mmv1.Role{
+	rbac.RoleUser:            mmv1.Role_ROLE_USER,
+	rbac.RoleAdmin:           mmv1.Role_ROLE_ADMIN,
+	rbac.RoleSupportReadonly: mmv1.Role_ROLE_SUPPORT_READONLY,
+}
+
+func toProto(u *user.User) *mmv1.User {
+	return &mmv1.User{
+		Id:        u.ID,
+		Username:  u.Username,
+		Email:     u.Email,
+		Version:   u.Version,
+		Role:      roles[u.Role],
+		Disabled:  u.DisabledAt != nil,
+		CreatedAt: timestamppb.New(u.CreateAt),
+		UpdatedAt: timestamppb.New(u.UpdateAt),
+	}
+}
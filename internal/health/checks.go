@@ -0,0 +1,47 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var ErrMigrationsPending = errors.New("migrations are not up to date")
+
+// Migrations сравнивает версию схемы в базе с последней миграцией в бинарнике.
+// Новее, чем знает бинарник, тоже считается готовым: так бывает при откате релиза.
+func Migrations(current func(ctx context.Context) (int, error), latest int) Check {
+	return func(ctx context.Context) error {
+		v, err := current(ctx)
+		if err != nil {
+			return err
+		}
+
+		if v < latest {
+			return fmt.Errorf("%w: database at %d, expected %d", ErrMigrationsPending, v, latest)
+		}
+
+		return nil
+	}
+}
+
+// Probe - клиентская сторона для `mm healthcheck`: ошибка, если url ответил не 200.
+func Probe(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+// Package health - /healthz и /readyz для оркестратора и балансировщика.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkTimeout - сколько ждём одну зависимость, чтобы /readyz не висел вместе с ней.
+const checkTimeout = 2 * time.Second
+
+// Check возвращает nil, если зависимость готова обслуживать запросы.
+type Check func(ctx context.Context) error
+
+type Checker struct {
+	mu     sync.RWMutex
+	names  []string
+	checks map[string]Check
+
+	shuttingDown atomic.Bool
+}
+
+func NewChecker() *Checker {
+	return &Checker{checks: make(map[string]Check)}
+}
+
+// Add регистрирует проверку зависимости для /readyz.
+func (h *Checker) Add(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// ShutDown переводит /readyz в 503, чтобы балансировщик перестал слать запросы,
+// пока сервер дорабатывает текущие.
+func (h *Checker) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+type CheckResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+const (
+	statusOK           = "ok"
+	statusFail         = "fail"
+	statusShuttingDown = "shutting_down"
+)
+
+// Ready выполняет все проверки параллельно.
+func (h *Checker) Ready(ctx context.Context) (Report, bool) {
+	if h.shuttingDown.Load() {
+		return Report{Status: statusShuttingDown}, false
+	}
+
+	h.mu.RLock()
+	names := append([]string(nil), h.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.RUnlock()
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, checks[i])
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: statusOK, Checks: make(map[string]CheckResult, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != statusOK {
+			report.Status = statusFail
+		}
+	}
+
+	return report, report.Status == statusOK
+}
+
+func run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	res := CheckResult{Status: statusOK, Latency: time.Since(start).String()}
+	if err != nil {
+		res.Status = statusFail
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// Register вешает /healthz и /readyz без аутентификации.
+func (h *Checker) Register(r gin.IRouter) {
+	r.GET("/healthz", h.healthz)
+	r.GET("/readyz", h.readyz)
+}
+
+// healthz - процесс жив и отвечает, зависимости не проверяются:
+// рестарт контейнера не поможет, если лежит база.
+func (h *Checker) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, Report{Status: statusOK})
+}
+
+func (h *Checker) readyz(c *gin.Context) {
+	report, ok := h.Ready(c.Request.Context())
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+
+	c.JSON(status, report)
+}
@@ -0,0 +1,66 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, h *Checker, path string) (int, Report) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h.Register(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var report Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestChecker_Readyz(t *testing.T) {
+	h := NewChecker()
+	h.Add("postgres", func(ctx context.Context) error { return nil })
+
+	code, report := serve(t, h, "/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", report.Checks["postgres"].Status)
+	require.NotEmpty(t, report.Checks["postgres"].Latency)
+
+	h.Add("redis", func(ctx context.Context) error { return errors.New("connection refused") })
+	code, report = serve(t, h, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "fail", report.Status)
+	require.Equal(t, "connection refused", report.Checks["redis"].Error)
+
+	// жив процесс независимо от зависимостей
+	code, _ = serve(t, h, "/healthz")
+	require.Equal(t, http.StatusOK, code)
+}
+
+func TestChecker_ShutDown(t *testing.T) {
+	h := NewChecker()
+	h.ShutDown()
+
+	code, report := serve(t, h, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "shutting_down", report.Status)
+}
+
+func TestMigrations(t *testing.T) {
+	version := func(v int) func(context.Context) (int, error) {
+		return func(context.Context) (int, error) { return v, nil }
+	}
+
+	require.ErrorIs(t, Migrations(version(5), 6)(context.Background()), ErrMigrationsPending)
+	require.NoError(t, Migrations(version(6), 6)(context.Background()))
+	require.NoError(t, Migrations(version(7), 6)(context.Background()))
+}
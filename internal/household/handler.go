@@ -0,0 +1,271 @@
+package household
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+type Handler struct {
+	svc *Service
+	log logger.Logger
+}
+
+func NewHandler(svc *Service, log logger.Logger) *Handler {
+	return &Handler{svc: svc, log: log}
+}
+
+// Register ожидает, что r уже требует аутентификацию. Права внутри домохозяйства
+// проверяет Service по роли участника, глобальные роли rbac тут ни при чём.
+func (h *Handler) Register(r gin.IRouter) {
+	g := r.Group("/households")
+	g.GET("", h.list)
+	g.POST("", h.create)
+	g.POST("/join", h.accept)
+	g.GET("/:id", h.get)
+	g.PATCH("/:id", h.rename)
+	g.DELETE("/:id", h.delete)
+	g.POST("/:id/invitations", h.invite)
+	g.PUT("/:id/members/:user_id/role", h.setRole)
+	g.DELETE("/:id/members/:user_id", h.removeMember)
+	g.POST("/:id/leave", h.leave)
+}
+
+type nameRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+type inviteRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  Role   `json:"role" binding:"required"`
+}
+
+type acceptRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type roleRequest struct {
+	Role Role `json:"role" binding:"required"`
+}
+
+type leaveRequest struct {
+	// обязателен, если уходит последний владелец, а в домохозяйстве есть кто-то ещё
+	SuccessorID int64 `json:"successor_id"`
+}
+
+func (h *Handler) list(c *gin.Context) {
+	items, err := h.svc.List(c.Request.Context(), rbac.PrincipalFrom(c).UserID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	if items == nil {
+		items = []Membership{}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *Handler) create(c *gin.Context) {
+	var req nameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hh, err := h.svc.Create(c.Request.Context(), rbac.PrincipalFrom(c).UserID, req.Name)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Header("Location", "/households/"+strconv.FormatInt(hh.ID, 10))
+	c.JSON(http.StatusCreated, Membership{Household: *hh, Role: RoleOwner})
+}
+
+func (h *Handler) get(c *gin.Context) {
+	id, ok := param(c, "id")
+	if !ok {
+		return
+	}
+
+	m, members, err := h.svc.Get(c.Request.Context(), rbac.PrincipalFrom(c).UserID, id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"household": m, "members": members})
+}
+
+func (h *Handler) rename(c *gin.Context) {
+	id, ok := param(c, "id")
+	if !ok {
+		return
+	}
+
+	var req nameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hh, err := h.svc.Rename(c.Request.Context(), rbac.PrincipalFrom(c).UserID, id, req.Name)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hh)
+}
+
+func (h *Handler) delete(c *gin.Context) {
+	id, ok := param(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.svc.Delete(c.Request.Context(), rbac.PrincipalFrom(c).UserID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) invite(c *gin.Context) {
+	id, ok := param(c, "id")
+	if !ok {
+		return
+	}
+
+	var req inviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	inv, err := h.svc.Invite(c.Request.Context(), rbac.PrincipalFrom(c).UserID, id, req.Email, req.Role, c.GetHeader("Accept-Language"))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, inv)
+}
+
+func (h *Handler) accept(c *gin.Context) {
+	var req acceptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	m, err := h.svc.Accept(c.Request.Context(), rbac.PrincipalFrom(c).UserID, req.Token)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, m)
+}
+
+func (h *Handler) setRole(c *gin.Context) {
+	id, ok := param(c, "id")
+	if !ok {
+		return
+	}
+	userID, ok := param(c, "user_id")
+	if !ok {
+		return
+	}
+
+	var req roleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.svc.SetRole(c.Request.Context(), rbac.PrincipalFrom(c).UserID, id, userID, req.Role); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) removeMember(c *gin.Context) {
+	id, ok := param(c, "id")
+	if !ok {
+		return
+	}
+	userID, ok := param(c, "user_id")
+	if !ok {
+		return
+	}
+
+	if err := h.svc.RemoveMember(c.Request.Context(), rbac.PrincipalFrom(c).UserID, id, userID); err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) leave(c *gin.Context) {
+	id, ok := param(c, "id")
+	if !ok {
+		return
+	}
+
+	// тело необязательно
+	var req leaveRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	deleted, err := h.svc.Leave(c.Request.Context(), rbac.PrincipalFrom(c).UserID, id, req.SuccessorID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"household_deleted": deleted})
+}
+
+func (h *Handler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrForbidden):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidRole), errors.Is(err, ErrInvalidInvitation):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotMember), errors.Is(err, ErrLastOwner),
+		errors.Is(err, ErrSuccessorRequired), errors.Is(err, ErrAlreadyMember):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrUserNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	default:
+		h.log.Error(c.Request.Context(), "household handler failed", logger.Field{Key: "error", Value: err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func param(c *gin.Context, name string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil || id <= 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+		return 0, false
+	}
+
+	return id, true
+}
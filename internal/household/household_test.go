@@ -0,0 +1,366 @@
+package household
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/skinkvi/money_managment/pkg/mailer"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRoles(t *testing.T) {
+	require.True(t, RoleOwner.Can(PermManage))
+	require.True(t, RoleEditor.Can(PermEdit))
+	require.False(t, RoleEditor.Can(PermManage))
+	require.True(t, RoleViewer.Can(PermView))
+	require.False(t, RoleViewer.Can(PermEdit))
+	require.False(t, Role("admin").Valid())
+}
+
+func TestCheckRoleChange(t *testing.T) {
+	members := []Member{{UserID: 1, Role: RoleOwner}, {UserID: 2, Role: RoleEditor}}
+
+	require.ErrorIs(t, checkRoleChange(members, 1, RoleEditor), ErrLastOwner)
+	require.ErrorIs(t, checkRoleChange(members, 1, ""), ErrLastOwner)
+	require.ErrorIs(t, checkRoleChange(members, 3, RoleViewer), ErrNotMember)
+	require.NoError(t, checkRoleChange(members, 2, RoleOwner))
+
+	// второй владелец - и первый может понизить себя
+	members[1].Role = RoleOwner
+	require.NoError(t, checkRoleChange(members, 1, RoleViewer))
+}
+
+func TestPlanLeave(t *testing.T) {
+	members := []Member{{UserID: 1, Role: RoleOwner}, {UserID: 2, Role: RoleEditor}, {UserID: 3, Role: RoleViewer}}
+
+	plan, err := planLeave(members, 2, 0)
+	require.NoError(t, err)
+	require.Equal(t, leavePlan{}, plan)
+
+	_, err = planLeave(members, 1, 0)
+	require.ErrorIs(t, err, ErrSuccessorRequired)
+
+	_, err = planLeave(members, 1, 42)
+	require.ErrorIs(t, err, ErrNotMember)
+
+	_, err = planLeave(members, 1, 1)
+	require.ErrorIs(t, err, ErrNotMember)
+
+	plan, err = planLeave(members, 1, 3)
+	require.NoError(t, err)
+	require.Equal(t, leavePlan{promote: 3}, plan)
+
+	plan, err = planLeave(members[:1], 1, 0)
+	require.NoError(t, err)
+	require.True(t, plan.deleteHousehold)
+}
+
+// memRepo - Repository в памяти с теми же правилами, что у postgres
+type memRepo struct {
+	households  map[int64]*Household
+	members     map[int64][]Member
+	invitations map[string]Invitation
+}
+
+func newMemRepo() *memRepo {
+	return &memRepo{households: map[int64]*Household{}, members: map[int64][]Member{}, invitations: map[string]Invitation{}}
+}
+
+func (m *memRepo) Create(ctx context.Context, name string, ownerID int64) (*Household, error) {
+	h := &Household{ID: int64(len(m.households) + 1), Name: name, CreateAt: t0, UpdateAt: t0}
+	m.households[h.ID] = h
+	m.members[h.ID] = []Member{{UserID: ownerID, Role: RoleOwner, JoinedAt: t0}}
+	return h, nil
+}
+
+func (m *memRepo) Get(ctx context.Context, id int64) (*Household, error) {
+	h, ok := m.households[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return h, nil
+}
+
+func (m *memRepo) ListForUser(ctx context.Context, userID int64) ([]Membership, error) {
+	var out []Membership
+	for id, members := range m.members {
+		if role := roleOf(members, userID); role != "" {
+			out = append(out, Membership{Household: *m.households[id], Role: role})
+		}
+	}
+	return out, nil
+}
+
+func (m *memRepo) Members(ctx context.Context, id int64) ([]Member, error) {
+	return m.members[id], nil
+}
+
+func (m *memRepo) Role(ctx context.Context, id, userID int64) (Role, error) {
+	if role := roleOf(m.members[id], userID); role != "" {
+		return role, nil
+	}
+	return "", ErrNotMember
+}
+
+func (m *memRepo) Rename(ctx context.Context, id int64, name string) (*Household, error) {
+	m.households[id].Name = name
+	return m.households[id], nil
+}
+
+func (m *memRepo) Delete(ctx context.Context, id int64) error {
+	delete(m.households, id)
+	delete(m.members, id)
+	return nil
+}
+
+func (m *memRepo) SetRole(ctx context.Context, id, userID int64, role Role) error {
+	if err := checkRoleChange(m.members[id], userID, role); err != nil {
+		return err
+	}
+	for i := range m.members[id] {
+		if m.members[id][i].UserID == userID {
+			m.members[id][i].Role = role
+		}
+	}
+	return nil
+}
+
+func (m *memRepo) RemoveMember(ctx context.Context, id, userID int64) error {
+	if err := checkRoleChange(m.members[id], userID, ""); err != nil {
+		return err
+	}
+	m.members[id] = slices.DeleteFunc(m.members[id], func(mm Member) bool { return mm.UserID == userID })
+	return nil
+}
+
+func (m *memRepo) Leave(ctx context.Context, id, userID, successor int64) (bool, error) {
+	plan, err := planLeave(m.members[id], userID, successor)
+	if err != nil {
+		return false, err
+	}
+	if plan.deleteHousehold {
+		return true, m.Delete(ctx, id)
+	}
+	if plan.promote != 0 {
+		for i := range m.members[id] {
+			if m.members[id][i].UserID == plan.promote {
+				m.members[id][i].Role = RoleOwner
+			}
+		}
+	}
+	m.members[id] = slices.DeleteFunc(m.members[id], func(mm Member) bool { return mm.UserID == userID })
+	return false, nil
+}
+
+func (m *memRepo) CreateInvitation(ctx context.Context, inv Invitation, hash []byte, invitedBy int64) (*Invitation, error) {
+	inv.ID = int64(len(m.invitations) + 1)
+	m.invitations[string(hash)] = inv
+	return &inv, nil
+}
+
+func (m *memRepo) AcceptInvitation(ctx context.Context, hash []byte, userID int64, email string) (*Membership, error) {
+	inv, ok := m.invitations[string(hash)]
+	if !ok || !strings.EqualFold(inv.Email, email) {
+		return nil, ErrInvalidInvitation
+	}
+	if roleOf(m.members[inv.HouseholdID], userID) != "" {
+		return nil, ErrAlreadyMember
+	}
+	delete(m.invitations, string(hash))
+	m.members[inv.HouseholdID] = append(m.members[inv.HouseholdID], Member{UserID: userID, Role: inv.Role, JoinedAt: t0})
+	return &Membership{Household: *m.households[inv.HouseholdID], Role: inv.Role}, nil
+}
+
+type fakeUsers struct {
+	user.Repository
+	users map[int64]*user.User
+}
+
+func (f *fakeUsers) GetByID(ctx context.Context, id int64) (*user.User, error) {
+	if u, ok := f.users[id]; ok {
+		return u, nil
+	}
+	return nil, storage.ErrUserNotFound
+}
+
+func (f *fakeUsers) GetByEmail(ctx context.Context, email string) (*user.User, error) {
+	for _, u := range f.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return nil, storage.ErrUserNotFound
+}
+
+type memMailer struct {
+	sent []mailer.Message
+}
+
+func (m *memMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var linkRe = regexp.MustCompile(`token=(\S+)`)
+
+func newTestService(t *testing.T) (*Service, *memRepo, *memMailer) {
+	t.Helper()
+
+	repo := newMemRepo()
+	users := &fakeUsers{users: map[int64]*user.User{
+		1: {ID: 1, Username: "anna", Email: "anna@example.com"},
+		2: {ID: 2, Username: "dima", Email: "dima@example.com"},
+		3: {ID: 3, Username: "olga", Email: "olga@example.com"},
+	}}
+	mail := &memMailer{}
+
+	svc, err := NewService(repo, users, mail, config.AuthConfig{
+		PublicURL: "https://mm.example", DefaultLang: "ru", InvitationTTL: time.Hour,
+	}, nopLogger{})
+	require.NoError(t, err)
+
+	return svc, repo, mail
+}
+
+func TestService_InviteAndAccept(t *testing.T) {
+	svc, _, mail := newTestService(t)
+	ctx := context.Background()
+
+	h, err := svc.Create(ctx, 1, "Семья")
+	require.NoError(t, err)
+
+	_, err = svc.Invite(ctx, 1, h.ID, "dima@example.com", RoleOwner, "")
+	require.ErrorIs(t, err, ErrInvalidRole)
+
+	inv, err := svc.Invite(ctx, 1, h.ID, "dima@example.com", RoleEditor, "en-US")
+	require.NoError(t, err)
+	require.Equal(t, RoleEditor, inv.Role)
+	require.Len(t, mail.sent, 1)
+	require.Equal(t, "dima@example.com", mail.sent[0].To)
+	require.Contains(t, mail.sent[0].Subject, "Семья")
+	require.Contains(t, mail.sent[0].Body, "anna invites you")
+
+	token := linkRe.FindStringSubmatch(mail.sent[0].Body)[1]
+
+	// пересланная ссылка не работает для другого адреса
+	_, err = svc.Accept(ctx, 3, token)
+	require.ErrorIs(t, err, ErrInvalidInvitation)
+
+	m, err := svc.Accept(ctx, 2, token)
+	require.NoError(t, err)
+	require.Equal(t, RoleEditor, m.Role)
+
+	_, err = svc.Invite(ctx, 1, h.ID, "DIMA@example.com", RoleViewer, "")
+	require.ErrorIs(t, err, ErrAlreadyMember)
+
+	// редактор меняет данные, но не состав
+	_, err = svc.Rename(ctx, 2, h.ID, "Наша семья")
+	require.NoError(t, err)
+	_, err = svc.Invite(ctx, 2, h.ID, "olga@example.com", RoleViewer, "")
+	require.ErrorIs(t, err, ErrForbidden)
+
+	// чужое домохозяйство не видно вовсе
+	_, _, err = svc.Get(ctx, 3, h.ID)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestService_LeaveHandsOffOwnership(t *testing.T) {
+	svc, repo, _ := newTestService(t)
+	ctx := context.Background()
+
+	h, err := svc.Create(ctx, 1, "Семья")
+	require.NoError(t, err)
+	repo.members[h.ID] = append(repo.members[h.ID], Member{UserID: 2, Role: RoleViewer})
+
+	require.ErrorIs(t, svc.SetRole(ctx, 1, h.ID, 1, RoleEditor), ErrLastOwner)
+	require.ErrorIs(t, svc.RemoveMember(ctx, 1, h.ID, 1), ErrLastOwner)
+
+	_, err = svc.Leave(ctx, 1, h.ID, 0)
+	require.ErrorIs(t, err, ErrSuccessorRequired)
+
+	deleted, err := svc.Leave(ctx, 1, h.ID, 2)
+	require.NoError(t, err)
+	require.False(t, deleted)
+
+	m, members, err := svc.Get(ctx, 2, h.ID)
+	require.NoError(t, err)
+	require.Equal(t, RoleOwner, m.Role)
+	require.Len(t, members, 1)
+
+	// ушёл бывший участник - домохозяйство ему больше не видно
+	_, err = svc.Leave(ctx, 1, h.ID, 0)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// последний участник уходит вместе с домохозяйством
+	deleted, err = svc.Leave(ctx, 2, h.ID, 0)
+	require.NoError(t, err)
+	require.True(t, deleted)
+	require.Empty(t, repo.households)
+}
+
+type memRecorder struct {
+	entries []audit.Entry
+}
+
+func (m *memRecorder) Record(ctx context.Context, q storage.Querier, e audit.Entry) error {
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+func TestRepo_LeavePromotesSuccessor(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	rec := &memRecorder{}
+	repo := NewRepository(&storage.DB{Pool: mock}, rec, nopLogger{})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`select h.id, h.name, h.create_at, h.update_at from households h where h.id = \$1 for update`).
+		WithArgs(int64(7)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "create_at", "update_at"}).AddRow(int64(7), "Семья", t0, t0))
+	mock.ExpectQuery(`select m.user_id, u.username, m.role, m.joined_at from household_members m .* for update of m`).
+		WithArgs(int64(7)).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "username", "role", "joined_at"}).
+			AddRow(int64(1), "anna", RoleOwner, t0).
+			AddRow(int64(2), "dima", RoleViewer, t0))
+	mock.ExpectExec(`update household_members set role = \$3`).
+		WithArgs(int64(7), int64(2), RoleOwner).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(`delete from household_members`).
+		WithArgs(int64(7), int64(1)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+
+	deleted, err := repo.Leave(context.Background(), 7, 1, 2)
+	require.NoError(t, err)
+	require.False(t, deleted)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	require.Len(t, rec.entries, 2)
+	require.Equal(t, ActionMemberRole, rec.entries[0].Action)
+	require.Equal(t, audit.Change{Before: RoleViewer, After: RoleOwner}, rec.entries[0].Diff["members.2"])
+	require.Equal(t, ActionMemberRemove, rec.entries[1].Action)
+	require.Equal(t, "7", rec.entries[1].EntityID)
+}
@@ -0,0 +1,145 @@
+// Package household - домохозяйства: общее пространство нескольких пользователей
+// с ролями. Владелец управляет участниками, редактор меняет данные, зритель их
+// только видит.
+package household
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var (
+	// и для несуществующего домохозяйства, и для чужого - чтобы не раскрывать, что оно есть
+	ErrNotFound  = errors.New("household not found")
+	ErrForbidden = errors.New("not enough rights in household")
+	ErrNotMember = errors.New("user is not a member of household")
+	// у домохозяйства всегда должен остаться владелец
+	ErrLastOwner         = errors.New("household must keep at least one owner")
+	ErrSuccessorRequired = errors.New("last owner must name a successor before leaving")
+	ErrAlreadyMember     = errors.New("user is already a member of household")
+	ErrInvalidRole       = errors.New("invalid household role")
+	// просроченное, уже принятое, неизвестное или выписанное на другой адрес
+	ErrInvalidInvitation = errors.New("invalid or expired invitation")
+)
+
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+type Permission string
+
+const (
+	PermView Permission = "view"
+	// менять данные домохозяйства: счета, операции, название
+	PermEdit Permission = "edit"
+	// приглашать и удалять участников, менять роли, удалять домохозяйство
+	PermManage Permission = "manage"
+)
+
+var permissions = map[Role][]Permission{
+	RoleOwner:  {PermView, PermEdit, PermManage},
+	RoleEditor: {PermView, PermEdit},
+	RoleViewer: {PermView},
+}
+
+func (r Role) Valid() bool {
+	_, ok := permissions[r]
+	return ok
+}
+
+func (r Role) Can(p Permission) bool {
+	return slices.Contains(permissions[r], p)
+}
+
+type Household struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	CreateAt time.Time `json:"created_at"`
+	UpdateAt time.Time `json:"updated_at"`
+}
+
+type Member struct {
+	UserID   int64     `json:"user_id"`
+	Username string    `json:"username"`
+	Role     Role      `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Membership - домохозяйство глазами участника.
+type Membership struct {
+	Household
+	Role Role `json:"role"`
+}
+
+type Invitation struct {
+	ID          int64     `json:"id"`
+	HouseholdID int64     `json:"household_id"`
+	Email       string    `json:"email"`
+	Role        Role      `json:"role"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// checkRoleChange - можно ли поставить участнику userID роль role (пустая роль -
+// удалить из домохозяйства), не оставив домохозяйство без владельца.
+func checkRoleChange(members []Member, userID int64, role Role) error {
+	i := slices.IndexFunc(members, func(m Member) bool { return m.UserID == userID })
+	if i < 0 {
+		return ErrNotMember
+	}
+
+	if members[i].Role == RoleOwner && role != RoleOwner && owners(members) == 1 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+// leavePlan - что сделать, когда userID уходит из домохозяйства.
+type leavePlan struct {
+	// кого сделать владельцем перед уходом, 0 - никого
+	promote int64
+	// userID - последний участник, домохозяйство удаляется целиком
+	deleteHousehold bool
+}
+
+// planLeave передаёт владение successor, если уходит последний владелец. Без
+// successor последний владелец может уйти, только если он и последний участник.
+func planLeave(members []Member, userID, successor int64) (leavePlan, error) {
+	i := slices.IndexFunc(members, func(m Member) bool { return m.UserID == userID })
+	if i < 0 {
+		return leavePlan{}, ErrNotMember
+	}
+
+	if len(members) == 1 {
+		return leavePlan{deleteHousehold: true}, nil
+	}
+
+	if members[i].Role != RoleOwner || owners(members) > 1 {
+		return leavePlan{}, nil
+	}
+
+	if successor == 0 {
+		return leavePlan{}, ErrSuccessorRequired
+	}
+	if successor == userID || !slices.ContainsFunc(members, func(m Member) bool { return m.UserID == successor }) {
+		return leavePlan{}, ErrNotMember
+	}
+
+	return leavePlan{promote: successor}, nil
+}
+
+func owners(members []Member) int {
+	n := 0
+	for _, m := range members {
+		if m.Role == RoleOwner {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,464 @@
+package household
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+const AuditEntity = "household"
+
+const (
+	ActionCreate       = "household.create"
+	ActionRename       = "household.rename"
+	ActionDelete       = "household.delete"
+	ActionInvite       = "household.invite"
+	ActionMemberAdd    = "household.member.add"
+	ActionMemberRole   = "household.member.role"
+	ActionMemberRemove = "household.member.remove"
+)
+
+type Repository interface {
+	// Create создаёт домохозяйство, ownerID становится его владельцем.
+	Create(ctx context.Context, name string, ownerID int64) (*Household, error)
+	Get(ctx context.Context, id int64) (*Household, error)
+	// ListForUser - домохозяйства, в которых состоит пользователь, с его ролью.
+	ListForUser(ctx context.Context, userID int64) ([]Membership, error)
+	Members(ctx context.Context, id int64) ([]Member, error)
+	// Role - роль пользователя в домохозяйстве, ErrNotMember если он не участник.
+	Role(ctx context.Context, id, userID int64) (Role, error)
+	Rename(ctx context.Context, id int64, name string) (*Household, error)
+	Delete(ctx context.Context, id int64) error
+
+	// SetRole и RemoveMember не дают убрать последнего владельца (ErrLastOwner).
+	SetRole(ctx context.Context, id, userID int64, role Role) error
+	RemoveMember(ctx context.Context, id, userID int64) error
+	// Leave - уход userID, владение при необходимости переходит successor (см. planLeave).
+	// deleted - ушёл последний участник и домохозяйство удалено.
+	Leave(ctx context.Context, id, userID, successor int64) (deleted bool, err error)
+
+	// CreateInvitation заменяет неиспользованное приглашение на тот же адрес.
+	CreateInvitation(ctx context.Context, inv Invitation, hash []byte, invitedBy int64) (*Invitation, error)
+	// AcceptInvitation атомарно гасит приглашение и добавляет userID в участники.
+	// Приглашение должно быть выписано на email.
+	AcceptInvitation(ctx context.Context, hash []byte, userID int64, email string) (*Membership, error)
+}
+
+const householdColumns = `h.id, h.name, h.create_at, h.update_at`
+
+func scanHousehold(row pgx.Row, h *Household) error {
+	return row.Scan(&h.ID, &h.Name, &h.CreateAt, &h.UpdateAt)
+}
+
+type pgRepository struct {
+	db    *storage.DB
+	audit audit.Recorder
+	log   logger.Logger
+}
+
+// NewRepository - изменения состава и ролей пишутся в журнал rec в той же транзакции.
+func NewRepository(db *storage.DB, rec audit.Recorder, log logger.Logger) Repository {
+	return &pgRepository{db: db, audit: rec, log: log}
+}
+
+func (r *pgRepository) Create(ctx context.Context, name string, ownerID int64) (*Household, error) {
+	const insertHousehold = `insert into households as h (name) values ($1) returning ` + householdColumns
+	const insertOwner = `insert into household_members (household_id, user_id, role) values ($1, $2, $3)`
+
+	var h Household
+
+	err := r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := scanHousehold(tx.QueryRow(ctx, insertHousehold, name), &h); err != nil {
+			return fmt.Errorf("failed query Create household: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, insertOwner, h.ID, ownerID, RoleOwner); err != nil {
+			return fmt.Errorf("failed query Create household owner: %w", err)
+		}
+
+		return r.record(ctx, tx, ActionCreate, h.ID, map[string]audit.Change{
+			"name":             {After: name},
+			memberKey(ownerID): {After: RoleOwner},
+		})
+	})
+	if err != nil {
+		r.log.Error(ctx, "failed to create household", logger.Field{Key: "error", Value: err})
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+func (r *pgRepository) Get(ctx context.Context, id int64) (*Household, error) {
+	const query = `select ` + householdColumns + ` from households h where h.id = $1`
+
+	var h Household
+
+	err := scanHousehold(r.db.Reader(ctx).QueryRow(ctx, query, id), &h)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query Get household",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "household_id", Value: id})
+		return nil, fmt.Errorf("failed query Get household: %w", err)
+	}
+
+	return &h, nil
+}
+
+func (r *pgRepository) ListForUser(ctx context.Context, userID int64) ([]Membership, error) {
+	const query = `select ` + householdColumns + `, m.role
+	from household_members m
+	join households h on h.id = m.household_id
+	where m.user_id = $1
+	order by h.id`
+
+	rows, err := r.db.Reader(ctx).Query(ctx, query, userID)
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query ListForUser",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: userID})
+		return nil, fmt.Errorf("failed query ListForUser: %w", err)
+	}
+
+	list, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Membership, error) {
+		var m Membership
+		err := row.Scan(&m.ID, &m.Name, &m.CreateAt, &m.UpdateAt, &m.Role)
+		return m, err
+	})
+	if err != nil {
+		r.log.Error(ctx, "failed scan ListForUser", logger.Field{Key: "error", Value: err})
+		return nil, fmt.Errorf("failed scan ListForUser: %w", err)
+	}
+
+	return list, nil
+}
+
+func (r *pgRepository) Members(ctx context.Context, id int64) ([]Member, error) {
+	return r.members(ctx, r.db.Reader(ctx), id, false)
+}
+
+// members - участники в порядке вступления. forUpdate блокирует их строки до конца транзакции.
+func (r *pgRepository) members(ctx context.Context, q storage.Querier, id int64, forUpdate bool) ([]Member, error) {
+	query := `select m.user_id, u.username, m.role, m.joined_at
+	from household_members m
+	join users u on u.id = m.user_id
+	where m.household_id = $1
+	order by m.joined_at, m.user_id`
+	if forUpdate {
+		query += ` for update of m`
+	}
+
+	rows, err := q.Query(ctx, query, id)
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query Members",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "household_id", Value: id})
+		return nil, fmt.Errorf("failed query Members: %w", err)
+	}
+
+	members, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Member, error) {
+		var m Member
+		err := row.Scan(&m.UserID, &m.Username, &m.Role, &m.JoinedAt)
+		return m, err
+	})
+	if err != nil {
+		r.log.Error(ctx, "failed scan Members", logger.Field{Key: "error", Value: err})
+		return nil, fmt.Errorf("failed scan Members: %w", err)
+	}
+
+	return members, nil
+}
+
+func (r *pgRepository) Role(ctx context.Context, id, userID int64) (Role, error) {
+	const query = `select role from household_members where household_id = $1 and user_id = $2`
+
+	var role Role
+
+	err := r.db.Reader(ctx).QueryRow(ctx, query, id, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotMember
+	}
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query Role",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "household_id", Value: id},
+			logger.Field{Key: "user_id", Value: userID})
+		return "", fmt.Errorf("failed query household Role: %w", err)
+	}
+
+	return role, nil
+}
+
+func (r *pgRepository) Rename(ctx context.Context, id int64, name string) (*Household, error) {
+	const query = `update households h set name = $2, update_at = now() where h.id = $1 returning ` + householdColumns
+
+	var h Household
+
+	err := r.withHousehold(ctx, id, func(tx pgx.Tx, before *Household, _ []Member) error {
+		if err := scanHousehold(tx.QueryRow(ctx, query, id, name), &h); err != nil {
+			return fmt.Errorf("failed query Rename household: %w", err)
+		}
+
+		return r.record(ctx, tx, ActionRename, id, audit.Diff(
+			map[string]any{"name": before.Name}, map[string]any{"name": name}))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+func (r *pgRepository) Delete(ctx context.Context, id int64) error {
+	return r.withHousehold(ctx, id, func(tx pgx.Tx, before *Household, members []Member) error {
+		return r.delete(ctx, tx, before, members)
+	})
+}
+
+func (r *pgRepository) delete(ctx context.Context, tx pgx.Tx, h *Household, members []Member) error {
+	const query = `delete from households where id = $1`
+
+	if _, err := tx.Exec(ctx, query, h.ID); err != nil {
+		return fmt.Errorf("failed query Delete household: %w", err)
+	}
+
+	diff := map[string]audit.Change{"name": {Before: h.Name}}
+	for _, m := range members {
+		diff[memberKey(m.UserID)] = audit.Change{Before: m.Role}
+	}
+
+	return r.record(ctx, tx, ActionDelete, h.ID, diff)
+}
+
+func (r *pgRepository) SetRole(ctx context.Context, id, userID int64, role Role) error {
+	const query = `update household_members set role = $3 where household_id = $1 and user_id = $2`
+
+	return r.withHousehold(ctx, id, func(tx pgx.Tx, _ *Household, members []Member) error {
+		if err := checkRoleChange(members, userID, role); err != nil {
+			return err
+		}
+
+		before := roleOf(members, userID)
+		if before == role {
+			return nil
+		}
+
+		if _, err := tx.Exec(ctx, query, id, userID, role); err != nil {
+			return fmt.Errorf("failed query SetRole household member: %w", err)
+		}
+
+		return r.record(ctx, tx, ActionMemberRole, id, map[string]audit.Change{
+			memberKey(userID): {Before: before, After: role},
+		})
+	})
+}
+
+func (r *pgRepository) RemoveMember(ctx context.Context, id, userID int64) error {
+	return r.withHousehold(ctx, id, func(tx pgx.Tx, _ *Household, members []Member) error {
+		if err := checkRoleChange(members, userID, ""); err != nil {
+			return err
+		}
+
+		return r.removeMember(ctx, tx, id, userID, roleOf(members, userID))
+	})
+}
+
+func (r *pgRepository) Leave(ctx context.Context, id, userID, successor int64) (bool, error) {
+	const promote = `update household_members set role = $3 where household_id = $1 and user_id = $2`
+
+	var deleted bool
+
+	err := r.withHousehold(ctx, id, func(tx pgx.Tx, h *Household, members []Member) error {
+		plan, err := planLeave(members, userID, successor)
+		if err != nil {
+			return err
+		}
+
+		if plan.deleteHousehold {
+			deleted = true
+			return r.delete(ctx, tx, h, members)
+		}
+
+		if plan.promote != 0 {
+			if _, err := tx.Exec(ctx, promote, id, plan.promote, RoleOwner); err != nil {
+				return fmt.Errorf("failed query Leave promote successor: %w", err)
+			}
+			err := r.record(ctx, tx, ActionMemberRole, id, map[string]audit.Change{
+				memberKey(plan.promote): {Before: roleOf(members, plan.promote), After: RoleOwner},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return r.removeMember(ctx, tx, id, userID, roleOf(members, userID))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return deleted, nil
+}
+
+func (r *pgRepository) removeMember(ctx context.Context, tx pgx.Tx, id, userID int64, role Role) error {
+	const query = `delete from household_members where household_id = $1 and user_id = $2`
+
+	if _, err := tx.Exec(ctx, query, id, userID); err != nil {
+		return fmt.Errorf("failed query RemoveMember: %w", err)
+	}
+
+	return r.record(ctx, tx, ActionMemberRemove, id, map[string]audit.Change{
+		memberKey(userID): {Before: role},
+	})
+}
+
+func (r *pgRepository) CreateInvitation(ctx context.Context, inv Invitation, hash []byte, invitedBy int64) (*Invitation, error) {
+	const query = `insert into household_invitations (household_id, email, role, token_hash, invited_by, expires_at)
+	values ($1, $2, $3, $4, $5, $6)
+	on conflict (household_id, lower(email)) where accepted_at is null do update
+	set role = excluded.role, token_hash = excluded.token_hash, invited_by = excluded.invited_by,
+		expires_at = excluded.expires_at, create_at = now()
+	returning id`
+
+	err := r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, query, inv.HouseholdID, inv.Email, inv.Role, hash, invitedBy, inv.ExpiresAt).Scan(&inv.ID)
+		if err != nil {
+			return fmt.Errorf("failed query CreateInvitation: %w", err)
+		}
+
+		// адрес приглашённого в журнал не попадает, только роль
+		return r.record(ctx, tx, ActionInvite, inv.HouseholdID, map[string]audit.Change{
+			"invitation." + strconv.FormatInt(inv.ID, 10): {After: inv.Role},
+		})
+	})
+	if err != nil {
+		r.log.Error(ctx, "failed to create invitation",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "household_id", Value: inv.HouseholdID})
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+func (r *pgRepository) AcceptInvitation(ctx context.Context, hash []byte, userID int64, email string) (*Membership, error) {
+	const consume = `update household_invitations
+	set accepted_at = now()
+	where token_hash = $1 and accepted_at is null and expires_at > now() and lower(email) = lower($2)
+	returning household_id, role`
+	const insertMember = `insert into household_members (household_id, user_id, role)
+	values ($1, $2, $3)
+	on conflict (household_id, user_id) do nothing`
+
+	var m Membership
+
+	err := r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		var id int64
+		err := tx.QueryRow(ctx, consume, hash, email).Scan(&id, &m.Role)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidInvitation
+		}
+		if err != nil {
+			return fmt.Errorf("failed query AcceptInvitation: %w", err)
+		}
+
+		h, err := r.lock(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		m.Household = *h
+
+		tag, err := tx.Exec(ctx, insertMember, id, userID, m.Role)
+		if err != nil {
+			return fmt.Errorf("failed query AcceptInvitation add member: %w", err)
+		}
+		// откат транзакции оставит приглашение неиспользованным
+		if tag.RowsAffected() == 0 {
+			return ErrAlreadyMember
+		}
+
+		return r.record(ctx, tx, ActionMemberAdd, id, map[string]audit.Change{
+			memberKey(userID): {After: m.Role},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// withHousehold выполняет fn в транзакции с заблокированной строкой домохозяйства:
+// изменения состава одного домохозяйства идут строго по очереди, поэтому проверка
+// "останется ли владелец" не устаревает до commit.
+func (r *pgRepository) withHousehold(ctx context.Context, id int64, fn func(tx pgx.Tx, h *Household, members []Member) error) error {
+	return r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		h, err := r.lock(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		members, err := r.members(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		return fn(tx, h, members)
+	})
+}
+
+func (r *pgRepository) lock(ctx context.Context, q storage.Querier, id int64) (*Household, error) {
+	const query = `select ` + householdColumns + ` from households h where h.id = $1 for update`
+
+	var h Household
+
+	err := scanHousehold(q.QueryRow(ctx, query, id), &h)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		r.log.Error(ctx, "failed to lock household",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "household_id", Value: id})
+		return nil, fmt.Errorf("failed query lock household: %w", err)
+	}
+
+	return &h, nil
+}
+
+func (r *pgRepository) record(ctx context.Context, q storage.Querier, action string, id int64, diff map[string]audit.Change) error {
+	if len(diff) == 0 {
+		return nil
+	}
+
+	return r.audit.Record(ctx, q, audit.Entry{
+		Action:     action,
+		EntityType: AuditEntity,
+		EntityID:   strconv.FormatInt(id, 10),
+		Diff:       diff,
+	})
+}
+
+// в журнале участник - поле members.<id>, его значение - роль
+func memberKey(userID int64) string {
+	return "members." + strconv.FormatInt(userID, 10)
+}
+
+func roleOf(members []Member, userID int64) Role {
+	for _, m := range members {
+		if m.UserID == userID {
+			return m.Role
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,258 @@
+package household
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/skinkvi/money_managment/pkg/mailer"
+)
+
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+type Service struct {
+	repo   Repository
+	users  user.Repository
+	mailer mailer.Mailer
+	tmpl   *mailer.Templates
+	// PublicURL, DefaultLang и InvitationTTL
+	cfg config.AuthConfig
+	log logger.Logger
+}
+
+func NewService(repo Repository, users user.Repository, m mailer.Mailer, cfg config.AuthConfig, log logger.Logger) (*Service, error) {
+	tmpl, err := mailer.ParseTemplates(templatesFS, "templates/*.tmpl", cfg.DefaultLang)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Service{repo: repo, users: users, mailer: m, tmpl: tmpl, cfg: cfg, log: log}, nil
+}
+
+// Authorize - роль userID, если её хватает для perm. Этой проверкой должны
+// пользоваться все данные, которые принадлежат домохозяйству. Тому, кто в
+// домохозяйстве не состоит, оно не видно вовсе - ErrNotFound.
+func (s *Service) Authorize(ctx context.Context, id, userID int64, perm Permission) (Role, error) {
+	role, err := s.repo.Role(ctx, id, userID)
+	if errors.Is(err, ErrNotMember) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !role.Can(perm) {
+		return role, ErrForbidden
+	}
+
+	return role, nil
+}
+
+func (s *Service) Create(ctx context.Context, actorID int64, name string) (*Household, error) {
+	return s.repo.Create(ctx, name, actorID)
+}
+
+func (s *Service) List(ctx context.Context, actorID int64) ([]Membership, error) {
+	return s.repo.ListForUser(ctx, actorID)
+}
+
+// Get - домохозяйство, его участники и роль actorID в нём.
+func (s *Service) Get(ctx context.Context, actorID, id int64) (*Membership, []Member, error) {
+	role, err := s.Authorize(ctx, id, actorID, PermView)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members, err := s.repo.Members(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &Membership{Household: *h, Role: role}, members, nil
+}
+
+func (s *Service) Rename(ctx context.Context, actorID, id int64, name string) (*Household, error) {
+	if _, err := s.Authorize(storage.WithPrimary(ctx), id, actorID, PermEdit); err != nil {
+		return nil, err
+	}
+
+	return s.repo.Rename(ctx, id, name)
+}
+
+func (s *Service) Delete(ctx context.Context, actorID, id int64) error {
+	if _, err := s.Authorize(storage.WithPrimary(ctx), id, actorID, PermManage); err != nil {
+		return err
+	}
+
+	return s.repo.Delete(ctx, id)
+}
+
+// SetRole - в том числе передача владения: владельцев может быть несколько, и
+// владелец может потом понизить себя сам.
+func (s *Service) SetRole(ctx context.Context, actorID, id, userID int64, role Role) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	if _, err := s.Authorize(storage.WithPrimary(ctx), id, actorID, PermManage); err != nil {
+		return err
+	}
+
+	return s.repo.SetRole(ctx, id, userID, role)
+}
+
+// RemoveMember - исключить участника. Уйти самому - Leave, там можно назвать преемника.
+func (s *Service) RemoveMember(ctx context.Context, actorID, id, userID int64) error {
+	if _, err := s.Authorize(storage.WithPrimary(ctx), id, actorID, PermManage); err != nil {
+		return err
+	}
+
+	return s.repo.RemoveMember(ctx, id, userID)
+}
+
+// Leave - уйти из домохозяйства. Последний владелец передаёт владение successor,
+// последний участник удаляет домохозяйство вместе с уходом.
+func (s *Service) Leave(ctx context.Context, actorID, id, successor int64) (bool, error) {
+	if _, err := s.Authorize(storage.WithPrimary(ctx), id, actorID, PermView); err != nil {
+		return false, err
+	}
+
+	// ErrNotMember тут уже про successor
+	deleted, err := s.repo.Leave(ctx, id, actorID, successor)
+	if err != nil {
+		return false, err
+	}
+
+	s.log.Info(ctx, "user left household",
+		logger.Field{Key: "household_id", Value: id},
+		logger.Field{Key: "user_id", Value: actorID},
+		logger.Field{Key: "successor_id", Value: successor},
+		logger.Field{Key: "deleted", Value: deleted})
+
+	return deleted, nil
+}
+
+// Invite отправляет приглашение на email. Адрес не обязан быть зарегистрирован:
+// по ссылке человек сначала зарегистрируется, потом примет приглашение.
+// Владельцем через приглашение не стать, только через SetRole.
+func (s *Service) Invite(ctx context.Context, actorID, id int64, email string, role Role, lang string) (*Invitation, error) {
+	if role != RoleEditor && role != RoleViewer {
+		return nil, ErrInvalidRole
+	}
+
+	ctx = storage.WithPrimary(ctx)
+	if _, err := s.Authorize(ctx, id, actorID, PermManage); err != nil {
+		return nil, err
+	}
+
+	h, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	inviter, err := s.users.GetByID(ctx, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.users.GetByEmail(ctx, email); err == nil {
+		if _, err := s.repo.Role(ctx, id, existing.ID); err == nil {
+			return nil, ErrAlreadyMember
+		} else if !errors.Is(err, ErrNotMember) {
+			return nil, err
+		}
+	} else if !errors.Is(err, storage.ErrUserNotFound) {
+		return nil, err
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := s.repo.CreateInvitation(ctx, Invitation{
+		HouseholdID: id,
+		Email:       email,
+		Role:        role,
+		ExpiresAt:   time.Now().Add(s.cfg.InvitationTTL),
+	}, hash, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if lang == "" {
+		lang = s.cfg.DefaultLang
+	}
+
+	msg, err := s.tmpl.Render("household_invitation", lang, map[string]any{
+		"Household": h.Name,
+		"Inviter":   inviter.Username,
+		"Link":      s.cfg.PublicURL + "/households/join?token=" + url.QueryEscape(token),
+		"TTL":       s.cfg.InvitationTTL.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.To = email
+
+	// приглашение без письма бесполезно, поэтому ошибку отправки отдаём клиенту - он повторит
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return nil, fmt.Errorf("send household invitation: %w", err)
+	}
+
+	return inv, nil
+}
+
+// Accept - принять приглашение. Ссылку мог получить кто-то ещё (пересланное письмо),
+// поэтому приглашение действует только для пользователя с тем же email.
+func (s *Service) Accept(ctx context.Context, actorID int64, token string) (*Membership, error) {
+	u, err := s.users.GetByID(ctx, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.repo.AcceptInvitation(ctx, hashToken(token), actorID, strings.TrimSpace(u.Email))
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info(ctx, "household invitation accepted",
+		logger.Field{Key: "household_id", Value: m.ID},
+		logger.Field{Key: "user_id", Value: actorID},
+		logger.Field{Key: "role", Value: m.Role})
+
+	return m, nil
+}
+
+// newToken - токен для письма и его хэш для базы, как у токенов auth.
+func newToken() (string, []byte, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("generate token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,222 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+var testConfig = config.IdempotencyConfig{Store: "redis", TTL: time.Hour, LockTimeout: time.Minute, Wait: 50 * time.Millisecond}
+
+func newRedisStore(t *testing.T) *RedisStore {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedisStore(client)
+}
+
+// newRouter - POST /items отвечает кодом status и считает вызовы обработчика.
+func newRouter(store Store, cfg config.IdempotencyConfig, status *int, calls *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(store, cfg, nopLogger{}))
+	r.POST("/items", func(c *gin.Context) {
+		*calls++
+		c.Header("Location", "/items/"+strconv.Itoa(*calls))
+		c.JSON(*status, gin.H{"call": *calls})
+	})
+	return r
+}
+
+// anonKey - ключ в хранилище для запроса send без Authorization.
+func anonKey(key string) string {
+	return "anon-" + digest("192.0.2.1") + ":" + key
+}
+
+func send(r http.Handler, key, auth, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware_Replay(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	r := newRouter(newRedisStore(t), testConfig, &status, &calls)
+
+	first := send(r, "k1", "Bearer a", `{"amount":10}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+	require.Empty(t, first.Header().Get(ReplayedHeader))
+
+	again := send(r, "k1", "Bearer a", `{"amount":10}`)
+	require.Equal(t, http.StatusCreated, again.Code)
+	require.Equal(t, "true", again.Header().Get(ReplayedHeader))
+	require.Equal(t, first.Body.String(), again.Body.String())
+	require.Equal(t, "/items/1", again.Header().Get("Location"))
+	require.Equal(t, 1, calls)
+
+	// тот же ключ с другим телом - ошибка клиента, а не новый запрос
+	require.Equal(t, http.StatusUnprocessableEntity, send(r, "k1", "Bearer a", `{"amount":20}`).Code)
+
+	// у другого клиента свои ключи
+	require.Equal(t, http.StatusCreated, send(r, "k1", "Bearer b", `{"amount":10}`).Code)
+	// без ключа запрос просто выполняется
+	require.Equal(t, http.StatusCreated, send(r, "", "Bearer a", `{"amount":10}`).Code)
+	require.Equal(t, 3, calls)
+}
+
+func TestMiddleware_ServerErrorReleasesKey(t *testing.T) {
+	status, calls := http.StatusInternalServerError, 0
+	r := newRouter(newRedisStore(t), testConfig, &status, &calls)
+
+	require.Equal(t, http.StatusInternalServerError, send(r, "k1", "", `{}`).Code)
+
+	status = http.StatusCreated
+	w := send(r, "k1", "", `{}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Empty(t, w.Header().Get(ReplayedHeader))
+	require.Equal(t, 2, calls)
+}
+
+func TestMiddleware_InProgress(t *testing.T) {
+	store := newRedisStore(t)
+	status, calls := http.StatusCreated, 0
+	r := newRouter(store, testConfig, &status, &calls)
+
+	ctx := context.Background()
+	fp := fingerprint(http.MethodPost, "/items", []byte(`{}`))
+	_, claimed, err := store.Claim(ctx, anonKey("k1"), fp, time.Minute, time.Hour)
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	w := send(r, "k1", "", `{}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+	require.Zero(t, calls)
+
+	// первый запрос закончился, пока повтор ждал
+	cfg := testConfig
+	cfg.Wait = time.Second
+	r = newRouter(store, cfg, &status, &calls)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = store.Save(ctx, anonKey("k1"), fp, Response{Status: http.StatusAccepted, Body: []byte(`{"first":true}`)}, time.Hour)
+	}()
+
+	w = send(r, "k1", "", `{}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, `{"first":true}`, w.Body.String())
+	require.Zero(t, calls)
+}
+
+func TestMiddleware_AnonymousByIP(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	r := newRouter(newRedisStore(t), testConfig, &status, &calls)
+
+	sendFrom := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(Header, "k1")
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Empty(t, sendFrom("10.0.0.1").Header().Get(ReplayedHeader))
+	// другой аноним с тем же ключом не получает чужой ответ
+	require.Empty(t, sendFrom("10.0.0.2").Header().Get(ReplayedHeader))
+	require.Equal(t, "true", sendFrom("10.0.0.1").Header().Get(ReplayedHeader))
+	require.Equal(t, 2, calls)
+}
+
+func TestRedisStore_SaveKeepsOtherClaim(t *testing.T) {
+	store := newRedisStore(t)
+	ctx := context.Background()
+
+	// ключ освободился по lockTimeout и его занял запрос с другим телом
+	_, claimed, err := store.Claim(ctx, "k1", "other", time.Minute, time.Hour)
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	require.NoError(t, store.Save(ctx, "k1", "late", Response{Status: http.StatusCreated}, time.Hour))
+
+	rec, claimed, err := store.Claim(ctx, "k1", "other", time.Minute, time.Hour)
+	require.NoError(t, err)
+	require.False(t, claimed)
+	require.Equal(t, "other", rec.Fingerprint)
+	require.Nil(t, rec.Response)
+
+	require.NoError(t, store.Save(ctx, "k1", "other", Response{Status: http.StatusAccepted}, time.Hour))
+	rec, _, err = store.Claim(ctx, "k1", "other", time.Minute, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, rec.Response.Status)
+}
+
+func TestPostgresStore_Claim(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	store := NewPostgresStore(&storage.DB{Pool: mock})
+	ctx := context.Background()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`insert into idempotency_keys`)).
+		WithArgs(anonKey("k1"), "fp", int64(60000), int64(3600000)).
+		WillReturnRows(pgxmock.NewRows([]string{"key"}).AddRow(anonKey("k1")))
+
+	_, claimed, err := store.Claim(ctx, anonKey("k1"), "fp", time.Minute, time.Hour)
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	status := 201
+	mock.ExpectQuery(regexp.QuoteMeta(`insert into idempotency_keys`)).
+		WithArgs(anonKey("k1"), "fp", int64(60000), int64(3600000)).
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(`select fingerprint, status, headers, body from idempotency_keys where key = $1`)).
+		WithArgs(anonKey("k1")).
+		WillReturnRows(pgxmock.NewRows([]string{"fingerprint", "status", "headers", "body"}).
+			AddRow("fp", &status, []byte(`{"Location":"/items/1"}`), []byte(`{"id":1}`)))
+
+	rec, claimed, err := store.Claim(ctx, anonKey("k1"), "fp", time.Minute, time.Hour)
+	require.NoError(t, err)
+	require.False(t, claimed)
+	require.Equal(t, &Response{Status: 201, Header: map[string]string{"Location": "/items/1"}, Body: []byte(`{"id":1}`)}, rec.Response)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
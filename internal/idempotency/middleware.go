@@ -0,0 +1,212 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+const (
+	Header = "Idempotency-Key"
+	// ставится на ответ, который отдан из хранилища, а не выполнен заново
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLen = 255
+	// тело читается целиком ради отпечатка, больше этого не принимаем
+	maxBodySize = 1 << 20
+	// как часто повтор проверяет, закончился ли первый запрос
+	pollInterval = 100 * time.Millisecond
+)
+
+// sensitiveRoutes отдают токены и секреты, их ответы не должны лежать в хранилище.
+// Для них заголовок игнорируется.
+var sensitiveRoutes = map[string]bool{
+	"POST /auth/login":                  true,
+	"POST /auth/2fa/totp/enroll":        true,
+	"POST /auth/2fa/totp/confirm":       true,
+	"POST /auth/2fa/recovery-codes":     true,
+	"POST /admin/users/:id/impersonate": true,
+}
+
+// заголовки ответа, которые сохраняются вместе с телом
+var storedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// Middleware обрабатывает POST и PATCH с заголовком Idempotency-Key. Первый запрос
+// занимает ключ и выполняется, его ответ сохраняется. Повтор с тем же телом получает
+// сохранённый ответ, с другим - 422. Повтор, пока первый ещё выполняется, ждёт до
+// cfg.Wait, потом получает 409. Ответы 5xx не сохраняются, такой запрос можно повторить.
+//
+// Ключи разных клиентов не пересекаются: ключ дополняется хешем заголовка Authorization.
+func Middleware(store Store, cfg config.IdempotencyConfig, log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		method := c.Request.Method
+		if cfg.Disabled || key == "" || (method != http.MethodPost && method != http.MethodPatch) ||
+			c.FullPath() == "" || sensitiveRoutes[method+" "+c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "idempotency key must be at most 255 characters"})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodySize+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cannot read request body"})
+			return
+		}
+		if len(body) > maxBodySize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		key = subject(c) + ":" + key
+		fp := fingerprint(method, c.Request.URL.Path, body)
+
+		rec, claimed, err := claim(ctx, store, key, fp, cfg)
+		if err != nil {
+			// без хранилища запрос выполняется как обычный, дубль лучше отказа
+			log.Error(ctx, "idempotency key check failed", logger.Field{Key: "error", Value: err})
+			c.Next()
+			return
+		}
+
+		switch {
+		case !claimed && rec.Fingerprint != fp:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "idempotency key was used with a different request"})
+			return
+		case !claimed && rec.Response != nil:
+			replay(c, rec.Response)
+			return
+		case !claimed:
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "request with this idempotency key is still in progress"})
+			return
+		}
+
+		// ответ сохраняется, даже если клиент уже отключился
+		bg := context.WithoutCancel(ctx)
+		w := &recorder{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		finished := false
+		defer func() {
+			// обработчик запаниковал: ключ не должен висеть до lockTimeout
+			if !finished {
+				if err := store.Release(bg, key, fp); err != nil {
+					log.Error(bg, "cannot release idempotency key", logger.Field{Key: "error", Value: err})
+				}
+			}
+		}()
+
+		c.Next()
+		finished = true
+
+		status := w.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests || status == http.StatusConflict {
+			if err := store.Release(bg, key, fp); err != nil {
+				log.Error(bg, "cannot release idempotency key", logger.Field{Key: "error", Value: err})
+			}
+			return
+		}
+
+		resp := Response{Status: status, Header: map[string]string{}, Body: w.body.Bytes()}
+		for _, h := range storedHeaders {
+			if v := w.Header().Get(h); v != "" {
+				resp.Header[h] = v
+			}
+		}
+
+		if err := store.Save(bg, key, fp, resp, cfg.TTL); err != nil {
+			log.Error(bg, "cannot save idempotent response", logger.Field{Key: "error", Value: err})
+			if err := store.Release(bg, key, fp); err != nil {
+				log.Error(bg, "cannot release idempotency key", logger.Field{Key: "error", Value: err})
+			}
+		}
+	}
+}
+
+// claim занимает ключ, а если его держит незаконченный запрос с тем же
+// отпечатком, ждёт до cfg.Wait, пока тот закончится.
+func claim(ctx context.Context, store Store, key, fp string, cfg config.IdempotencyConfig) (Record, bool, error) {
+	deadline := time.Now().Add(cfg.Wait)
+
+	for {
+		rec, claimed, err := store.Claim(ctx, key, fp, cfg.LockTimeout, cfg.TTL)
+		if err != nil || claimed || rec.Response != nil || (rec.Fingerprint != "" && rec.Fingerprint != fp) {
+			return rec, claimed, err
+		}
+
+		if !time.Now().Before(deadline) {
+			rec.Fingerprint = fp
+			return rec, false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return Record{}, false, ctx.Err()
+		case <-time.After(min(pollInterval, time.Until(deadline))):
+		}
+	}
+}
+
+func replay(c *gin.Context, resp *Response) {
+	for k, v := range resp.Header {
+		c.Header(k, v)
+	}
+	c.Header(ReplayedHeader, "true")
+	c.Status(resp.Status)
+	_, _ = c.Writer.Write(resp.Body)
+	c.Abort()
+}
+
+// subject - чей это ключ: хеш заголовка Authorization, а для запросов без него -
+// хеш адреса клиента, чтобы анонимы с одинаковым ключом не получали чужие ответы.
+func subject(c *gin.Context) string {
+	auth := c.GetHeader("Authorization")
+	if auth == "" {
+		return "anon-" + digest(c.ClientIP())
+	}
+
+	return digest(auth)
+}
+
+func digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+func fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder копирует тело ответа, чтобы его можно было сохранить.
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,136 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// PostgresStore хранит ключи в таблице idempotency_keys. Все запросы идут в
+// основную базу: на реплике может не оказаться только что занятого ключа.
+type PostgresStore struct {
+	db *storage.DB
+}
+
+func NewPostgresStore(db *storage.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Claim(ctx context.Context, key, fingerprint string, lockTimeout, ttl time.Duration) (Record, bool, error) {
+	// чужую запись можно перезаписать, только если она истекла или её владелец
+	// не уложился в lockTimeout
+	const claimQuery = `insert into idempotency_keys (key, fingerprint, locked_until, expires_at)
+	values ($1, $2, now() + $3 * interval '1 millisecond', now() + $4 * interval '1 millisecond')
+	on conflict (key) do update
+	set fingerprint = excluded.fingerprint, status = null, headers = null, body = null,
+		locked_until = excluded.locked_until, expires_at = excluded.expires_at, create_at = now()
+	where idempotency_keys.expires_at < now()
+		or (idempotency_keys.status is null and idempotency_keys.locked_until < now())
+	returning key`
+	const getQuery = `select fingerprint, status, headers, body from idempotency_keys where key = $1`
+
+	ctx = storage.WithQueryName(ctx, "idempotency.Claim")
+
+	var claimed string
+	err := s.db.Pool.QueryRow(ctx, claimQuery, key, fingerprint, lockTimeout.Milliseconds(), ttl.Milliseconds()).Scan(&claimed)
+	if err == nil {
+		return Record{}, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return Record{}, false, fmt.Errorf("failed query Claim idempotency key: %w", err)
+	}
+
+	var (
+		rec     Record
+		status  *int
+		headers []byte
+		body    []byte
+	)
+	err = s.db.Pool.QueryRow(ctx, getQuery, key).Scan(&rec.Fingerprint, &status, &headers, &body)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Record{}, false, nil
+	}
+	if err != nil {
+		return Record{}, false, fmt.Errorf("failed query Claim idempotency key: %w", err)
+	}
+
+	if status != nil {
+		rec.Response = &Response{Status: *status, Body: body}
+		if len(headers) > 0 {
+			if err := json.Unmarshal(headers, &rec.Response.Header); err != nil {
+				return Record{}, false, fmt.Errorf("unmarshal idempotency headers: %w", err)
+			}
+		}
+	}
+
+	return rec, false, nil
+}
+
+func (s *PostgresStore) Save(ctx context.Context, key, fingerprint string, resp Response, ttl time.Duration) error {
+	const query = `update idempotency_keys
+	set status = $3, headers = $4, body = $5, expires_at = now() + $6 * interval '1 millisecond'
+	where key = $1 and fingerprint = $2`
+
+	headers, err := json.Marshal(resp.Header)
+	if err != nil {
+		return fmt.Errorf("marshal idempotency headers: %w", err)
+	}
+
+	ctx = storage.WithQueryName(ctx, "idempotency.Save")
+	if _, err := s.db.Pool.Exec(ctx, query, key, fingerprint, resp.Status, headers, resp.Body, ttl.Milliseconds()); err != nil {
+		return fmt.Errorf("failed query Save idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key, fingerprint string) error {
+	const query = `delete from idempotency_keys where key = $1 and fingerprint = $2 and status is null`
+
+	ctx = storage.WithQueryName(ctx, "idempotency.Release")
+	if _, err := s.db.Pool.Exec(ctx, query, key, fingerprint); err != nil {
+		return fmt.Errorf("failed query Release idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpired удаляет истёкшие ключи и возвращает, сколько удалено.
+func (s *PostgresStore) DeleteExpired(ctx context.Context) (int64, error) {
+	const query = `delete from idempotency_keys where expires_at < now()`
+
+	tag, err := s.db.Pool.Exec(storage.WithQueryName(ctx, "idempotency.DeleteExpired"), query)
+	if err != nil {
+		return 0, fmt.Errorf("failed query DeleteExpired idempotency keys: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Cleanup раз в every удаляет истёкшие ключи, пока не отменён ctx.
+func (s *PostgresStore) Cleanup(ctx context.Context, every time.Duration, log logger.Logger) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.DeleteExpired(ctx)
+			if err != nil {
+				log.Error(ctx, "cannot delete expired idempotency keys", logger.Field{Key: "error", Value: err})
+				continue
+			}
+			if n > 0 {
+				log.Debug(ctx, "expired idempotency keys deleted", logger.Field{Key: "count", Value: n})
+			}
+		}
+	}
+}
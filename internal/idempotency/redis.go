@@ -0,0 +1,103 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// releasePending удаляет ключ, только если под ним всё ещё наша запись без ответа:
+// после lockTimeout ключ мог занять другой запрос.
+var releasePending = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// savePending записывает ответ, только если ключ всё ещё занят нашим запросом:
+// иначе запрос, переживший lockTimeout, затёр бы ответ другого запроса.
+var savePending = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+end
+return false
+`)
+
+// RedisStore хранит запись под ключом в JSON. Пока ответа нет, ключ живёт
+// lockTimeout, с ответом - ttl.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+const keyPrefix = "mm:idem:"
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client, prefix: keyPrefix}
+}
+
+func (s *RedisStore) Claim(ctx context.Context, key, fingerprint string, lockTimeout, ttl time.Duration) (Record, bool, error) {
+	pending, err := json.Marshal(Record{Fingerprint: fingerprint})
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	ok, err := s.client.SetNX(ctx, s.prefix+key, pending, lockTimeout).Result()
+	if err != nil {
+		return Record{}, false, fmt.Errorf("claim idempotency key: %w", err)
+	}
+	if ok {
+		return Record{}, true, nil
+	}
+
+	raw, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Record{}, false, nil
+	}
+	if err != nil {
+		return Record{}, false, fmt.Errorf("claim idempotency key: %w", err)
+	}
+
+	var rec Record
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return Record{}, false, fmt.Errorf("unmarshal idempotency record: %w", err)
+	}
+
+	return rec, false, nil
+}
+
+func (s *RedisStore) Save(ctx context.Context, key, fingerprint string, resp Response, ttl time.Duration) error {
+	pending, err := json.Marshal(Record{Fingerprint: fingerprint})
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(Record{Fingerprint: fingerprint, Response: &resp})
+	if err != nil {
+		return err
+	}
+
+	err = savePending.Run(ctx, s.client, []string{s.prefix + key}, pending, raw, ttl.Milliseconds()).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("save idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key, fingerprint string) error {
+	pending, err := json.Marshal(Record{Fingerprint: fingerprint})
+	if err != nil {
+		return err
+	}
+
+	if err := releasePending.Run(ctx, s.client, []string{s.prefix + key}, pending).Err(); err != nil {
+		return fmt.Errorf("release idempotency key: %w", err)
+	}
+
+	return nil
+}
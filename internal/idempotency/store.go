@@ -0,0 +1,37 @@
+// Package idempotency - повтор POST/PATCH с тем же заголовком Idempotency-Key
+// получает сохранённый ответ первого запроса вместо повторного выполнения.
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// Response - сохранённый ответ первого запроса.
+type Response struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   []byte            `json:"body,omitempty"`
+}
+
+// Record - то, что уже лежит под ключом.
+type Record struct {
+	// отпечаток запроса, который занял ключ
+	Fingerprint string `json:"fingerprint"`
+	// nil, пока первый запрос ещё выполняется
+	Response *Response `json:"response,omitempty"`
+}
+
+// Store хранит ключи и ответы. Claim должен быть атомарным между экземплярами
+// сервиса, иначе два одновременных повтора выполнятся оба.
+type Store interface {
+	// Claim занимает key за запросом с отпечатком fingerprint: запись живёт ttl,
+	// но держит ключ без ответа не дольше lockTimeout. Если ключ уже занят или
+	// под ним есть ответ, возвращает эту запись и false. Пустая запись с false -
+	// ключ освободился между проверками, Claim можно повторить.
+	Claim(ctx context.Context, key, fingerprint string, lockTimeout, ttl time.Duration) (Record, bool, error)
+	// Save сохраняет ответ на ttl, если ключ всё ещё занят запросом с этим fingerprint.
+	Save(ctx context.Context, key, fingerprint string, resp Response, ttl time.Duration) error
+	// Release освобождает ключ без ответа, чтобы повтор выполнился заново.
+	Release(ctx context.Context, key, fingerprint string) error
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeTimeout - сколько ждём базу при сборе бизнес метрик, чтобы /metrics не висел.
+const scrapeTimeout = 2 * time.Second
+
+// Counter - источник значения для gauge, например user.Repository.Count.
+type Counter func(ctx context.Context) (int64, error)
+
+// business собирает gauge на каждый scrape запросом к базе.
+type business struct {
+	desc  *prometheus.Desc
+	count Counter
+	// при ошибке gauge в этот scrape не отдаётся, зато растёт этот счётчик
+	errors prometheus.Counter
+}
+
+// NewUsersGauge регистрирует mm_users gauge - общее число пользователей.
+func NewUsersGauge(reg prometheus.Registerer, count Counter) {
+	b := &business{
+		desc:  prometheus.NewDesc(namespace+"_users", "Количество зарегистрированных пользователей.", nil, nil),
+		count: count,
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "business_scrape_errors_total",
+			Help:        "Ошибки при сборе бизнес метрик.",
+			ConstLabels: prometheus.Labels{"metric": "users"},
+		}),
+	}
+	reg.MustRegister(b, b.errors)
+}
+
+func (b *business) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.desc
+}
+
+func (b *business) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	n, err := b.count(ctx)
+	if err != nil && ErrorType(err) != "empty" {
+		b.errors.Inc()
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(b.desc, prometheus.GaugeValue, float64(n))
+}
@@ -0,0 +1,160 @@
+// Package metrics - метрики Prometheus, которые отдаются на отдельном порту в /metrics.
+//
+// Метрик кэша и числа созданных транзакций здесь нет: в сервисе пока нет ни
+// Redis кэша, ни транзакций. Они добавятся вместе с этими частями.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/skinkvi/money_managment/internal/storage"
+)
+
+const namespace = "mm"
+
+// NewRegistry - отдельный registry вместо глобального, чтобы в /metrics было только то,
+// что мы явно зарегистрировали, плюс стандартные метрики Go рантайма и процесса.
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return reg
+}
+
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
+
+// HTTP - счётчики и длительность запросов по маршруту и статусу.
+type HTTP struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func NewHTTP(reg prometheus.Registerer) *HTTP {
+	m := &HTTP{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Количество HTTP запросов по маршруту и статусу.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Длительность HTTP запросов.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+	reg.MustRegister(m.requests, m.duration)
+
+	return m
+}
+
+// Middleware использует шаблон маршрута (/users/:id), а не путь, иначе число меток не ограничено.
+func (m *HTTP) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		m.requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.duration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// GRPC - счётчики и длительность gRPC вызовов по методу и коду ответа.
+type GRPC struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func NewGRPC(reg prometheus.Registerer) *GRPC {
+	m := &GRPC{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "requests_total",
+			Help:      "Количество gRPC вызовов по методу и коду.",
+		}, []string{"method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "request_duration_seconds",
+			Help:      "Длительность gRPC вызовов.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+	}
+	reg.MustRegister(m.requests, m.duration)
+
+	return m
+}
+
+// Observe - method полный, вида /mm.v1.UserService/GetUser, code - имя кода gRPC.
+func (m *GRPC) Observe(method, code string, d time.Duration) {
+	m.requests.WithLabelValues(method, code).Inc()
+	m.duration.WithLabelValues(method, code).Observe(d.Seconds())
+}
+
+// Repository - ошибки операций репозиториев по типу.
+type Repository struct {
+	errors *prometheus.CounterVec
+}
+
+func NewRepository(reg prometheus.Registerer) *Repository {
+	r := &Repository{
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "repository",
+			Name:      "errors_total",
+			Help:      "Ошибки операций репозиториев по типу.",
+		}, []string{"repository", "operation", "type"}),
+	}
+	reg.MustRegister(r.errors)
+
+	return r
+}
+
+// Observe ничего не делает для err == nil, чтобы его можно было звать после каждой операции.
+func (r *Repository) Observe(repo, op string, err error) {
+	if err == nil {
+		return
+	}
+
+	r.errors.WithLabelValues(repo, op, ErrorType(err)).Inc()
+}
+
+// ErrorType - тип ошибки для метки: ожидаемые ошибки предметной области отдельно от сбоев базы.
+func ErrorType(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		return "not_found"
+	case errors.Is(err, storage.ErrNoUsers):
+		return "empty"
+	case errors.Is(err, storage.ErrVersionConflict):
+		return "conflict"
+	case errors.Is(err, storage.ErrUserAlreadyExists):
+		return "already_exists"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "internal"
+	}
+}
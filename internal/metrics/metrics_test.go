@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTP_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	reg := prometheus.NewRegistry()
+	m := NewHTTP(reg)
+
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, path := range []string{"/users/1", "/users/2", "/nope"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	require.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues("GET", "/users/:id", "200")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues("GET", "unmatched", "404")))
+}
+
+func TestRepository_Observe(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewRepository(reg)
+
+	m.Observe("user", "GetByID", nil)
+	m.Observe("user", "GetByID", fmt.Errorf("user with id 1 not found: %w", storage.ErrUserNotFound))
+	m.Observe("user", "Patch", storage.ErrVersionConflict)
+	m.Observe("user", "Patch", errors.New("connection reset"))
+
+	require.Equal(t, 3, testutil.CollectAndCount(m.errors))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.errors.WithLabelValues("user", "GetByID", "not_found")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.errors.WithLabelValues("user", "Patch", "internal")))
+}
+
+func TestUsersGauge(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	NewUsersGauge(reg, func(ctx context.Context) (int64, error) { return 42, nil })
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP mm_users Количество зарегистрированных пользователей.
+# TYPE mm_users gauge
+mm_users 42
+`), "mm_users"))
+}
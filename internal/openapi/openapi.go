@@ -0,0 +1,246 @@
+// Package openapi - спецификация HTTP API и проверка запросов по ней. Спецификация
+// пишется руками в openapi.yaml, тест пакета сверяет её с маршрутами и ответами хендлеров.
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+//go:embed openapi.yaml
+var specYAML []byte
+
+// Spec - разобранная и проверенная спецификация.
+type Spec struct {
+	doc  *openapi3.T
+	json []byte
+}
+
+// Load читает встроенную спецификацию. Ошибка значит, что openapi.yaml сломан.
+func Load() (*Spec, error) {
+	// без этого format: email в схемах не проверяется. Регистрация глобальная
+	// в kin-openapi, повторный вызов её просто перезаписывает
+	openapi3.DefineStringFormatValidator("email", openapi3.NewRegexpFormatValidator(openapi3.FormatOfStringForEmail))
+
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(specYAML)
+	if err != nil {
+		return nil, fmt.Errorf("load openapi spec: %w", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec: %w", err)
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("marshal openapi spec: %w", err)
+	}
+
+	return &Spec{doc: doc, json: b}, nil
+}
+
+// Doc - сама спецификация, её нельзя менять.
+func (s *Spec) Doc() *openapi3.T {
+	return s.doc
+}
+
+// Register отдаёт спецификацию на GET /openapi.json без аутентификации.
+func (s *Spec) Register(r gin.IRouter) {
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", s.json)
+	})
+}
+
+// Route - операция спецификации для маршрута gin. ok false, если маршрута в ней нет.
+func (s *Spec) Route(method, fullPath string) (*routers.Route, bool) {
+	path := Path(fullPath)
+	item := s.doc.Paths.Value(path)
+	if item == nil {
+		return nil, false
+	}
+
+	op := item.GetOperation(method)
+	if op == nil {
+		return nil, false
+	}
+
+	return &routers.Route{Spec: s.doc, Path: path, PathItem: item, Method: method, Operation: op}, true
+}
+
+// Path переводит путь gin в путь спецификации: /users/:id -> /users/{id}.
+func Path(fullPath string) string {
+	parts := strings.Split(fullPath, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") || strings.HasPrefix(p, "*") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// Middleware проверяет параметры и тело запроса по спецификации и отвечает 400
+// с details до того, как запрос дойдёт до хендлера. Маршруты, которых нет в
+// спецификации, пропускаются. Аутентификацию проверяет auth.RequireUser, здесь
+// требования security не проверяются.
+func (s *Spec) Middleware(log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route, ok := s.Route(c.Request.Method, c.FullPath())
+		if !ok {
+			c.Next()
+			return
+		}
+
+		err := openapi3filter.ValidateRequest(c.Request.Context(), s.input(c, route))
+		if err == nil {
+			c.Next()
+			return
+		}
+
+		details := Details(err)
+		if len(details) == 0 {
+			log.Error(c.Request.Context(), "openapi request validation failed", logger.Field{Key: "error", Value: err})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": details})
+	}
+}
+
+func (s *Spec) input(c *gin.Context, route *routers.Route) *openapi3filter.RequestValidationInput {
+	params := make(map[string]string, len(c.Params))
+	for _, p := range c.Params {
+		params[p.Key] = p.Value
+	}
+
+	return &openapi3filter.RequestValidationInput{
+		Request:    c.Request,
+		PathParams: params,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError:          true,
+			SkipSettingDefaults: true,
+			AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+}
+
+// CheckResponses сверяет каждый ответ со спецификацией и передаёт расхождения в
+// report: код, которого нет у операции, или тело не по схеме. Для тестов - тело
+// ответа копируется в память. Маршрут, которого нет в спецификации, тоже расхождение.
+func (s *Spec) CheckResponses(report func(c *gin.Context, err error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route, ok := s.Route(c.Request.Method, c.FullPath())
+		if !ok {
+			report(c, fmt.Errorf("%s %s is not in the spec", c.Request.Method, c.FullPath()))
+			c.Next()
+			return
+		}
+
+		w := &recorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		err := openapi3filter.ValidateResponse(c.Request.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: s.input(c, route),
+			Status:                 w.Status(),
+			Header:                 w.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(w.body.Bytes())),
+			Options:                &openapi3filter.Options{MultiError: true, IncludeResponseStatus: true},
+		})
+		if err != nil {
+			report(c, err)
+		}
+	}
+}
+
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// Details - ошибки проверки запроса в виде строк для ответа клиенту. Пусто, если
+// err не ошибка запроса, а, например, сломанная спецификация.
+func Details(err error) []string {
+	var multi openapi3.MultiError
+	if !errors.As(err, &multi) {
+		multi = openapi3.MultiError{err}
+	}
+
+	var details []string
+	for _, e := range multi {
+		var reqErr *openapi3filter.RequestError
+		if !errors.As(e, &reqErr) {
+			var secErr *openapi3filter.SecurityRequirementsError
+			if errors.As(e, &secErr) {
+				continue
+			}
+			return nil
+		}
+		details = append(details, describe(reqErr)...)
+	}
+
+	return details
+}
+
+func describe(e *openapi3filter.RequestError) []string {
+	where := "body"
+	if e.Parameter != nil {
+		where = e.Parameter.In + " parameter " + e.Parameter.Name
+	}
+
+	var schemaErrs []error
+	var multi openapi3.MultiError
+	if errors.As(e.Err, &multi) {
+		schemaErrs = multi
+	} else if e.Err != nil {
+		schemaErrs = []error{e.Err}
+	}
+
+	if len(schemaErrs) == 0 {
+		return []string{where + ": " + e.Reason}
+	}
+
+	out := make([]string, 0, len(schemaErrs))
+	for _, err := range schemaErrs {
+		var schemaErr *openapi3.SchemaError
+		if errors.As(err, &schemaErr) {
+			field := where
+			if ptr := schemaErr.JSONPointer(); len(ptr) > 0 {
+				field += "." + strings.Join(ptr, ".")
+			}
+			out = append(out, field+": "+schemaErr.Reason)
+			continue
+		}
+		if errors.Is(err, openapi3filter.ErrInvalidRequired) {
+			out = append(out, where+": is required")
+			continue
+		}
+		out = append(out, where+": "+err.Error())
+	}
+
+	return out
+}
@@ -0,0 +1,367 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/admin"
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/auth"
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/delta"
+	"github.com/skinkvi/money_managment/internal/health"
+	"github.com/skinkvi/money_managment/internal/household"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/realtime"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/internal/user"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/skinkvi/money_managment/pkg/mailer"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+var testTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeUsers struct {
+	user.Repository
+	users map[int64]*user.User
+}
+
+func (f *fakeUsers) GetByID(ctx context.Context, id int64) (*user.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (f *fakeUsers) Patch(ctx context.Context, id, version int64, patch user.Patch) (*user.User, error) {
+	u, err := f.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Version != version {
+		return nil, storage.ErrVersionConflict
+	}
+	if patch.Username != nil {
+		u.Username = *patch.Username
+	}
+	u.Version++
+	return u, nil
+}
+
+func (f *fakeUsers) SetDisabled(ctx context.Context, id int64, disabled bool) (*user.User, error) {
+	u, err := f.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	u.DisabledAt = nil
+	if disabled {
+		u.DisabledAt = &testTime
+	}
+	return u, nil
+}
+
+func (f *fakeUsers) SetRole(ctx context.Context, id int64, role rbac.Role) (*user.User, error) {
+	u, err := f.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	u.Role = role
+	return u, nil
+}
+
+func (f *fakeUsers) List(ctx context.Context, limit, offset int) ([]user.User, error) {
+	return []user.User{*f.users[1], *f.users[2]}, nil
+}
+
+func (f *fakeUsers) Count(ctx context.Context) (int64, error) {
+	return int64(len(f.users)), nil
+}
+
+type fakeAudit struct {
+	audit.Repository
+}
+
+func (fakeAudit) Append(ctx context.Context, e audit.Entry) error { return nil }
+
+func (fakeAudit) ListByActor(ctx context.Context, actorID int64, limit, offset int) ([]audit.Entry, error) {
+	return []audit.Entry{{
+		ID: 7, ActorID: &actorID, Action: "user.update", EntityType: "user", EntityID: "2",
+		Diff: map[string]audit.Change{"username": {Before: "a", After: "b"}}, CreateAt: testTime,
+	}}, nil
+}
+
+func (fakeAudit) ListByEntity(ctx context.Context, entityType, entityID string, limit, offset int) ([]audit.Entry, error) {
+	return []audit.Entry{}, nil
+}
+
+type fakeSessions struct{}
+
+func (fakeSessions) Impersonate(ctx context.Context, adminID, targetID int64) (*auth.Session, error) {
+	return &auth.Session{Token: "t", ExpiresAt: testTime}, nil
+}
+
+func (fakeSessions) RevokeUserSessions(ctx context.Context, userID int64) error { return nil }
+
+func (fakeSessions) Logout(ctx context.Context, token string) error { return nil }
+
+type fakeSyncLog struct{}
+
+func (fakeSyncLog) Since(ctx context.Context, userID, seq int64, limit int) ([]delta.Entry, error) {
+	return []delta.Entry{
+		{Seq: 1, Type: user.SyncType, ID: "1", ChangedAt: testTime},
+		{Seq: 2, Type: user.SyncType, ID: "42", Deleted: true, ChangedAt: testTime},
+	}, nil
+}
+
+func (fakeSyncLog) Entry(ctx context.Context, userID int64, entityType, id string) (delta.Entry, bool, error) {
+	return delta.Entry{}, false, nil
+}
+
+func (fakeSyncLog) Head(ctx context.Context, userID int64) (int64, error) { return 2, nil }
+
+type fakeHouseholds struct {
+	household.Repository
+}
+
+func (fakeHouseholds) Create(ctx context.Context, name string, ownerID int64) (*household.Household, error) {
+	return &household.Household{ID: 5, Name: name, CreateAt: testTime, UpdateAt: testTime}, nil
+}
+
+func (fakeHouseholds) ListForUser(ctx context.Context, userID int64) ([]household.Membership, error) {
+	return []household.Membership{{
+		Household: household.Household{ID: 5, Name: "Семья", CreateAt: testTime, UpdateAt: testTime},
+		Role:      household.RoleOwner,
+	}}, nil
+}
+
+func (fakeHouseholds) Role(ctx context.Context, id, userID int64) (household.Role, error) {
+	if id != 5 {
+		return "", household.ErrNotMember
+	}
+	return household.RoleOwner, nil
+}
+
+func (fakeHouseholds) Get(ctx context.Context, id int64) (*household.Household, error) {
+	return &household.Household{ID: id, Name: "Семья", CreateAt: testTime, UpdateAt: testTime}, nil
+}
+
+func (fakeHouseholds) Members(ctx context.Context, id int64) ([]household.Member, error) {
+	return []household.Member{{UserID: 1, Username: "root", Role: household.RoleOwner, JoinedAt: testTime}}, nil
+}
+
+func (fakeHouseholds) Leave(ctx context.Context, id, userID, successor int64) (bool, error) {
+	return false, household.ErrSuccessorRequired
+}
+
+type fakeLevels struct{ level string }
+
+func (f *fakeLevels) Level() string { return f.level }
+
+func (f *fakeLevels) SetLevel(level string) error {
+	f.level = level
+	return nil
+}
+
+func loadSpec(t *testing.T) *Spec {
+	t.Helper()
+
+	spec, err := Load()
+	require.NoError(t, err)
+	return spec
+}
+
+// registerAll вешает все хендлеры сервиса так же, как main, но без middleware аутентификации.
+func registerAll(r gin.IRouter, spec *Spec, users user.Repository, auditRepo audit.Repository) {
+	health.NewChecker().Register(r)
+	spec.Register(r)
+	auth.NewHandler(nil, nopLogger{}).Register(r)
+	user.NewHandler(users, nopLogger{}).Register(r)
+	admin.NewHandler(admin.NewService(users, fakeSessions{}, auditRepo, nopLogger{}), nopLogger{}).Register(r)
+	audit.NewHandler(auditRepo, nopLogger{}).Register(r)
+	admin.NewLogLevelHandler(&fakeLevels{level: "info"}, auditRepo, nopLogger{}).Register(r)
+
+	syncService := delta.NewService(fakeSyncLog{}, map[string]delta.Source{user.SyncType: user.NewSyncSource(users)}, nopLogger{})
+	delta.NewHandler(syncService, nopLogger{}).Register(r)
+	households, err := household.NewService(fakeHouseholds{}, users, mailer.NewLogMailer(nopLogger{}), config.AuthConfig{DefaultLang: "ru"}, nopLogger{})
+	if err != nil {
+		panic(err)
+	}
+	household.NewHandler(households, nopLogger{}).Register(r)
+	realtime.NewHandler(realtime.NewHub(5, nopLogger{}), syncService, time.Second, nopLogger{}).Register(r)
+}
+
+func TestSpec_CoversAllRoutes(t *testing.T) {
+	spec := loadSpec(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerAll(r, spec, &fakeUsers{}, fakeAudit{})
+
+	var routes []string
+	for _, route := range r.Routes() {
+		routes = append(routes, route.Method+" "+Path(route.Path))
+	}
+
+	var documented []string
+	for path, item := range spec.Doc().Paths.Map() {
+		for method := range item.Operations() {
+			documented = append(documented, method+" "+path)
+		}
+	}
+
+	slices.Sort(routes)
+	slices.Sort(documented)
+	require.Equal(t, documented, routes, "маршруты в роутере и в openapi.yaml должны совпадать")
+}
+
+func TestSpec_Served(t *testing.T) {
+	spec := loadSpec(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	spec.Register(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	require.Equal(t, "3.0.3", doc["openapi"])
+}
+
+func TestMiddleware(t *testing.T) {
+	spec := loadSpec(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(spec.Middleware(nopLogger{}))
+	reached := 0
+	r.POST("/auth/register", func(c *gin.Context) { reached++; c.Status(http.StatusCreated) })
+	r.GET("/admin/users", func(c *gin.Context) { reached++; c.Status(http.StatusOK) })
+	r.GET("/not-in-spec", func(c *gin.Context) { reached++; c.Status(http.StatusOK) })
+
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(http.MethodPost, "/auth/register", `{"username":"dima","email":"not-an-email"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var resp struct {
+		Error   string   `json:"error"`
+		Details []string `json:"details"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "invalid request", resp.Error)
+	require.Len(t, resp.Details, 2)
+	require.Contains(t, strings.Join(resp.Details, "\n"), "password")
+	require.Contains(t, strings.Join(resp.Details, "\n"), "body.email")
+
+	rec = send(http.MethodGet, "/admin/users?limit=1000", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "query parameter limit")
+	require.Zero(t, reached)
+
+	require.Equal(t, http.StatusCreated, send(http.MethodPost, "/auth/register", `{"username":"dima","email":"d@example.com","password":"secret"}`).Code)
+	require.Equal(t, http.StatusOK, send(http.MethodGet, "/admin/users?limit=10", "").Code)
+	require.Equal(t, http.StatusOK, send(http.MethodGet, "/not-in-spec", "").Code)
+	require.Equal(t, 3, reached)
+}
+
+// TestHandlers_MatchSpec прогоняет реальные хендлеры и падает, если код ответа
+// не описан в спецификации или тело не совпадает со схемой.
+func TestHandlers_MatchSpec(t *testing.T) {
+	spec := loadSpec(t)
+	users := &fakeUsers{users: map[int64]*user.User{
+		1: {ID: 1, Username: "root", Email: "root@example.com", Version: 1, Role: rbac.RoleAdmin, CreateAt: testTime, UpdateAt: testTime},
+		2: {ID: 2, Username: "dima", Email: "dima@example.com", Version: 3, Role: rbac.RoleUser, CreateAt: testTime, UpdateAt: testTime},
+	}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(spec.CheckResponses(func(c *gin.Context, err error) {
+		t.Errorf("%s %s: response diverges from spec: %v", c.Request.Method, c.Request.URL, err)
+	}))
+	r.Use(func(c *gin.Context) {
+		rbac.SetPrincipal(c, &rbac.Principal{UserID: 1, Role: rbac.RoleAdmin})
+	})
+	registerAll(r, spec, users, fakeAudit{})
+
+	cases := []struct {
+		method, target, body string
+		header               map[string]string
+		status               int
+	}{
+		{method: http.MethodGet, target: "/healthz", status: http.StatusOK},
+		{method: http.MethodGet, target: "/readyz", status: http.StatusOK},
+		{method: http.MethodGet, target: "/openapi.json", status: http.StatusOK},
+		{method: http.MethodGet, target: "/users/2", status: http.StatusOK},
+		{method: http.MethodGet, target: "/users/2", header: map[string]string{"If-None-Match": `"3"`}, status: http.StatusNotModified},
+		{method: http.MethodGet, target: "/users/99", status: http.StatusNotFound},
+		{method: http.MethodPatch, target: "/users/2", body: `{"username":"dmitry"}`, status: http.StatusPreconditionRequired},
+		{method: http.MethodPatch, target: "/users/2", body: `{"username":"dmitry"}`, header: map[string]string{"If-Match": `"1"`}, status: http.StatusPreconditionFailed},
+		{method: http.MethodPatch, target: "/users/2", body: `{"username":"dmitry"}`, header: map[string]string{"If-Match": `"3"`}, status: http.StatusOK},
+		{method: http.MethodGet, target: "/users/2/activity?limit=10", status: http.StatusOK},
+		{method: http.MethodGet, target: "/audit/user/2", status: http.StatusOK},
+		{method: http.MethodGet, target: "/admin/users", status: http.StatusOK},
+		{method: http.MethodGet, target: "/admin/users?limit=0", status: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/admin/users/2/disable", status: http.StatusOK},
+		{method: http.MethodPost, target: "/admin/users/2/enable", status: http.StatusOK},
+		{method: http.MethodPost, target: "/admin/users/1/disable", status: http.StatusForbidden},
+		{method: http.MethodPut, target: "/admin/users/2/role", body: `{"role":"support-readonly"}`, status: http.StatusOK},
+		{method: http.MethodPost, target: "/admin/users/2/impersonate", status: http.StatusOK},
+		{method: http.MethodPut, target: "/admin/log-level", body: `{"level":"warn"}`, status: http.StatusOK},
+		{method: http.MethodGet, target: "/admin/log-level", status: http.StatusOK},
+		{method: http.MethodGet, target: "/sync?limit=10", status: http.StatusOK},
+		{method: http.MethodGet, target: "/sync?since=garbage", status: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/sync", status: http.StatusOK, body: `{"changes":[
+			{"type":"user","id":"1","op":"upsert","base":{"username":"root"},"fields":{"username":"admin"},"changed_at":"2024-05-01T12:00:00Z"},
+			{"type":"user","id":"1","op":"delete","changed_at":"2024-05-01T12:00:00Z"}]}`},
+		{method: http.MethodPost, target: "/sync", body: `{}`, status: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/events?last_event_id=garbage", status: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/households", status: http.StatusOK},
+		{method: http.MethodPost, target: "/households", body: `{"name":"Семья"}`, status: http.StatusCreated},
+		{method: http.MethodGet, target: "/households/5", status: http.StatusOK},
+		{method: http.MethodGet, target: "/households/6", status: http.StatusNotFound},
+		{method: http.MethodPost, target: "/households/5/leave", status: http.StatusConflict},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		if tc.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		require.Equal(t, tc.status, rec.Code, "%s %s: %s", tc.method, tc.target, rec.Body.String())
+	}
+}
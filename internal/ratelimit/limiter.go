@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// authRoutes - встроенные лимиты маршрутов auth, которые перебирают чаще всего:
+// пароли, токены из писем и коды второго фактора. ratelimit.routes из конфига
+// их перекрывают.
+var authRoutes = map[string]config.RouteLimit{
+	"POST /auth/login":                  {Requests: 10, Window: time.Minute},
+	"POST /auth/register":               {Requests: 5, Window: time.Hour},
+	"POST /auth/verify-email/resend":    {Requests: 5, Window: 15 * time.Minute},
+	"POST /auth/password-reset/request": {Requests: 5, Window: 15 * time.Minute},
+	"POST /auth/password-reset/confirm": {Requests: 10, Window: 15 * time.Minute},
+	"POST /auth/2fa/totp/confirm":       {Requests: 10, Window: 15 * time.Minute},
+	"POST /auth/2fa/totp/disable":       {Requests: 10, Window: 15 * time.Minute},
+	"POST /auth/2fa/recovery-codes":     {Requests: 10, Window: 15 * time.Minute},
+}
+
+// Scope - по чему считается лимит.
+type Scope string
+
+const (
+	ByIP Scope = "ip"
+	// ByUser работает только после auth.RequireUser, анонимные запросы пропускает
+	ByUser Scope = "user"
+)
+
+type Limiter struct {
+	store Store
+	cfg   atomic.Pointer[config.RateLimitConfig]
+	log   logger.Logger
+	now   func() time.Time
+}
+
+func NewLimiter(store Store, cfg config.RateLimitConfig, log logger.Logger) *Limiter {
+	l := &Limiter{store: store, log: log, now: time.Now}
+	l.cfg.Store(&cfg)
+	return l
+}
+
+// SetConfig меняет лимиты на лету, окна уже начатых счётчиков сохраняются.
+func (l *Limiter) SetConfig(cfg config.RateLimitConfig) {
+	l.cfg.Store(&cfg)
+}
+
+// LimitFor - лимит маршрута вида "POST /auth/login".
+func (l *Limiter) LimitFor(route string) config.RouteLimit {
+	cfg := l.cfg.Load()
+	if limit, ok := cfg.Routes[route]; ok {
+		return limit
+	}
+	if limit, ok := authRoutes[route]; ok {
+		return limit
+	}
+	return cfg.Default
+}
+
+// Middleware ограничивает запросы к каждому маршруту отдельно для каждого IP
+// или пользователя и отдаёт заголовки RateLimit-*. При превышении - 429 с Retry-After.
+// Если хранилище лимитов сломалось, запрос пропускается.
+func (l *Limiter) Middleware(scope Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.cfg.Load().Disabled || c.FullPath() == "" {
+			c.Next()
+			return
+		}
+
+		route := c.Request.Method + " " + c.FullPath()
+		limit := l.LimitFor(route)
+		if limit.Requests <= 0 {
+			c.Next()
+			return
+		}
+
+		var subject string
+		switch scope {
+		case ByUser:
+			p := rbac.PrincipalFrom(c)
+			if p == nil {
+				c.Next()
+				return
+			}
+			subject = strconv.FormatInt(p.UserID, 10)
+		default:
+			subject = c.ClientIP()
+		}
+
+		ctx := c.Request.Context()
+		now := l.now()
+
+		w, err := l.store.Hit(ctx, string(scope)+":"+subject+":"+route, limit.Requests, limit.Window, now)
+		if err != nil {
+			l.log.Error(ctx, "rate limit check failed", logger.Field{Key: "error", Value: err})
+			c.Next()
+			return
+		}
+
+		reset := ceilSeconds(w.Reset.Sub(now))
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(max(limit.Requests-w.Count, 0)))
+		c.Header("RateLimit-Reset", strconv.Itoa(reset))
+		c.Header("RateLimit-Policy", strconv.Itoa(limit.Requests)+";w="+strconv.Itoa(ceilSeconds(limit.Window)))
+
+		if !w.Allowed {
+			c.Header("Retry-After", strconv.Itoa(reset))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ceilSeconds - секунды с округлением вверх: клиент, который подождёт столько,
+// точно попадёт в новое окно.
+func ceilSeconds(d time.Duration) int {
+	return max(int(math.Ceil(d.Seconds())), 0)
+}
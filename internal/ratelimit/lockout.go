@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/config"
+)
+
+// Lockout блокирует вход в аккаунт после нескольких неудачных попыток подряд,
+// каждая следующая блокировка вдвое дольше предыдущей. Ключ - обычно email.
+type Lockout struct {
+	store Store
+	cfg   atomic.Pointer[config.LockoutConfig]
+}
+
+func NewLockout(store Store, cfg config.LockoutConfig) *Lockout {
+	l := &Lockout{store: store}
+	l.cfg.Store(&cfg)
+	return l
+}
+
+func (l *Lockout) SetConfig(cfg config.LockoutConfig) {
+	l.cfg.Store(&cfg)
+}
+
+// Check - сколько ещё действует блокировка, 0 если её нет.
+func (l *Lockout) Check(ctx context.Context, key string) (time.Duration, error) {
+	return l.store.LockedFor(ctx, "lock:"+key)
+}
+
+// Fail засчитывает неудачную попытку и возвращает длительность блокировки,
+// если она началась, иначе 0.
+func (l *Lockout) Fail(ctx context.Context, key string) (time.Duration, error) {
+	cfg := l.cfg.Load()
+	if cfg.Threshold <= 0 {
+		return 0, nil
+	}
+
+	n, err := l.store.Incr(ctx, "fail:"+key, cfg.Window)
+	if err != nil {
+		return 0, err
+	}
+	if n < int64(cfg.Threshold) {
+		return 0, nil
+	}
+
+	d := Delay(*cfg, int(n)-cfg.Threshold)
+	if err := l.store.Lock(ctx, "lock:"+key, d); err != nil {
+		return 0, err
+	}
+	// счётчик должен пережить блокировку, иначе после неё отсчёт начнётся с начала
+	if err := l.store.Expire(ctx, "fail:"+key, d+cfg.Window); err != nil {
+		return 0, err
+	}
+
+	return d, nil
+}
+
+// Reset - после успешного входа счётчик и блокировка сбрасываются.
+func (l *Lockout) Reset(ctx context.Context, key string) error {
+	return l.store.Del(ctx, "fail:"+key, "lock:"+key)
+}
+
+// Delay - длительность блокировки номер n (с нуля): BaseDelay * 2^n, не больше MaxDelay.
+func Delay(cfg config.LockoutConfig, n int) time.Duration {
+	d := cfg.BaseDelay
+	for i := 0; i < n && d < cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, cfg.MaxDelay)
+}
@@ -0,0 +1,223 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/internal/server"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+func newRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedisStore(client), mr
+}
+
+func TestStore_SlidingWindow(t *testing.T) {
+	redisStore, _ := newRedisStore(t)
+
+	for name, store := range map[string]Store{"redis": redisStore, "memory": NewMemoryStore()} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			start := time.Now().Truncate(time.Millisecond)
+
+			for i := 1; i <= 3; i++ {
+				w, err := store.Hit(ctx, "k", 3, time.Minute, start.Add(time.Duration(i)*time.Second))
+				require.NoError(t, err)
+				require.True(t, w.Allowed)
+				require.Equal(t, i, w.Count)
+			}
+
+			w, err := store.Hit(ctx, "k", 3, time.Minute, start.Add(10*time.Second))
+			require.NoError(t, err)
+			require.False(t, w.Allowed)
+			require.Equal(t, 3, w.Count)
+			// место освободится, когда из окна выйдет первое попадание
+			require.Equal(t, start.Add(time.Second+time.Minute), w.Reset)
+
+			// окно скользящее: через минуту после первого попадания одно место свободно
+			w, err = store.Hit(ctx, "k", 3, time.Minute, start.Add(time.Minute+1500*time.Millisecond))
+			require.NoError(t, err)
+			require.True(t, w.Allowed)
+
+			w, err = store.Hit(ctx, "k", 3, time.Minute, start.Add(time.Minute+1600*time.Millisecond))
+			require.NoError(t, err)
+			require.False(t, w.Allowed)
+
+			// другой ключ считается отдельно
+			w, err = store.Hit(ctx, "other", 3, time.Minute, start)
+			require.NoError(t, err)
+			require.True(t, w.Allowed)
+		})
+	}
+}
+
+func TestLockout(t *testing.T) {
+	redisStore, mr := newRedisStore(t)
+	cfg := config.LockoutConfig{Threshold: 2, Window: 15 * time.Minute, BaseDelay: time.Minute, MaxDelay: 3 * time.Minute}
+	l := NewLockout(redisStore, cfg)
+	ctx := context.Background()
+
+	d, err := l.Fail(ctx, "dima@example.com")
+	require.NoError(t, err)
+	require.Zero(t, d)
+
+	d, err = l.Fail(ctx, "dima@example.com")
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, d)
+
+	left, err := l.Check(ctx, "dima@example.com")
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, left)
+
+	mr.FastForward(time.Minute)
+	left, err = l.Check(ctx, "dima@example.com")
+	require.NoError(t, err)
+	require.Zero(t, left)
+
+	// счётчик пережил блокировку, следующая вдвое дольше
+	d, err = l.Fail(ctx, "dima@example.com")
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Minute, d)
+
+	require.NoError(t, l.Reset(ctx, "dima@example.com"))
+	left, err = l.Check(ctx, "dima@example.com")
+	require.NoError(t, err)
+	require.Zero(t, left)
+
+	require.Equal(t, 3*time.Minute, Delay(cfg, 5))
+}
+
+type brokenStore struct{ Store }
+
+var errDown = errors.New("redis: connection refused")
+
+func (brokenStore) Hit(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (Window, error) {
+	return Window{}, errDown
+}
+
+func TestFallbackStore(t *testing.T) {
+	s := NewFallbackStore(brokenStore{}, NewMemoryStore(), nopLogger{})
+	ctx := context.Background()
+
+	w, err := s.Hit(ctx, "k", 1, time.Minute, time.Now())
+	require.NoError(t, err)
+	require.True(t, w.Allowed)
+
+	w, err = s.Hit(ctx, "k", 1, time.Minute, time.Now())
+	require.NoError(t, err)
+	require.False(t, w.Allowed)
+	require.True(t, s.degraded.Load())
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, _ := newRedisStore(t)
+	limiter := NewLimiter(store, config.RateLimitConfig{
+		Default: config.RouteLimit{Requests: 100, Window: time.Minute},
+		Routes:  map[string]config.RouteLimit{"GET /ping": {Requests: 2, Window: time.Minute}},
+	}, nopLogger{})
+
+	r := gin.New()
+	r.Use(limiter.Middleware(ByIP))
+	r.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/auth/login", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/auth/2fa/totp/disable", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(method, path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/ping", "10.0.0.1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "60", rec.Header().Get("RateLimit-Reset"))
+	require.Equal(t, "2;w=60", rec.Header().Get("RateLimit-Policy"))
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/ping", "10.0.0.1").Code)
+
+	rec = do(http.MethodGet, "/ping", "10.0.0.1")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	require.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// у другого IP своё окно
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/ping", "10.0.0.2").Code)
+
+	// встроенный лимит для входа
+	require.Equal(t, "10", do(http.MethodPost, "/auth/login", "10.0.0.1").Header().Get("RateLimit-Limit"))
+	require.Equal(t, "10", do(http.MethodPost, "/auth/2fa/totp/disable", "10.0.0.1").Header().Get("RateLimit-Limit"))
+
+	// лимиты меняются на лету
+	limiter.SetConfig(config.RateLimitConfig{Disabled: true})
+	rec = do(http.MethodGet, "/ping", "10.0.0.1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Empty(t, rec.Header().Get("RateLimit-Limit"))
+}
+
+func TestMiddleware_ForwardedFor(t *testing.T) {
+	store, _ := newRedisStore(t)
+	limiter := NewLimiter(store, config.RateLimitConfig{
+		Routes: map[string]config.RouteLimit{"GET /ping": {Requests: 1, Window: time.Minute}},
+	}, nopLogger{})
+
+	newRouter := func(trusted []string) *gin.Engine {
+		r, err := server.NewRouter("dev", trusted, nopLogger{})
+		require.NoError(t, err)
+		r.Use(limiter.Middleware(ByIP))
+		r.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+		return r
+	}
+
+	do := func(r *gin.Engine, remote, forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// без доверенных прокси подделанный заголовок не даёт нового окна
+	r := newRouter(nil)
+	require.Equal(t, http.StatusOK, do(r, "10.0.0.1", "1.1.1.1"))
+	require.Equal(t, http.StatusTooManyRequests, do(r, "10.0.0.1", "2.2.2.2"))
+
+	// за доверенным прокси клиент берётся из заголовка
+	r = newRouter([]string{"10.0.1.0/24"})
+	require.Equal(t, http.StatusOK, do(r, "10.0.1.5", "3.3.3.3"))
+	require.Equal(t, http.StatusOK, do(r, "10.0.1.5", "4.4.4.4"))
+	require.Equal(t, http.StatusTooManyRequests, do(r, "10.0.1.6", "3.3.3.3"))
+
+	_, err := server.NewRouter("dev", []string{"not-an-ip"}, nopLogger{})
+	require.Error(t, err)
+}
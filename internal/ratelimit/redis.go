@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindow - лог попаданий в sorted set, score - время в миллисекундах.
+// Всё в одном скрипте, чтобы проверка и запись были атомарными между экземплярами.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+local reset = now + window
+if #oldest > 0 then
+	reset = tonumber(oldest[2]) + window
+end
+return {allowed, count, reset}
+`)
+
+// RedisStore - общие для всех экземпляров лимиты.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// ключи в Redis общие с другими данными, поэтому у всех один префикс
+const keyPrefix = "mm:rl:"
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client, prefix: keyPrefix}
+}
+
+func (s *RedisStore) Hit(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (Window, error) {
+	// одинаковое время у двух запросов не должно схлопнуть их в один элемент
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + randomSuffix()
+
+	res, err := slidingWindow.Run(ctx, s.client, []string{s.prefix + key},
+		now.UnixMilli(), window.Milliseconds(), limit, member).Int64Slice()
+	if err != nil {
+		return Window{}, err
+	}
+	if len(res) != 3 {
+		return Window{}, errors.New("ratelimit: unexpected script result")
+	}
+
+	return Window{Allowed: res[0] == 1, Count: int(res[1]), Reset: time.UnixMilli(res[2])}, nil
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	key = s.prefix + key
+
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		incr = p.Incr(ctx, key)
+		p.PExpire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+func (s *RedisStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.PExpire(ctx, s.prefix+key, ttl).Err()
+}
+
+func (s *RedisStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, 1, ttl).Err()
+}
+
+func (s *RedisStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	d, err := s.client.PTTL(ctx, s.prefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// -2 нет ключа, -1 нет срока: ни то ни другое блокировкой не считаем
+	if d < 0 {
+		return 0, nil
+	}
+	return d, nil
+}
+
+func (s *RedisStore) Del(ctx context.Context, keys ...string) error {
+	full := make([]string, len(keys))
+	for i, k := range keys {
+		full[i] = s.prefix + k
+	}
+	return s.client.Del(ctx, full...).Err()
+}
+
+func randomSuffix() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
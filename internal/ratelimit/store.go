@@ -0,0 +1,252 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// Window - состояние скользящего окна после попытки.
+type Window struct {
+	Allowed bool
+	// попаданий в окне, включая эту попытку, если она прошла
+	Count int
+	// когда освободится самое старое место в окне
+	Reset time.Time
+}
+
+// Store хранит окна и счётчики. Ключи общие для всех экземпляров сервиса,
+// если хранилище - Redis.
+type Store interface {
+	// Hit засчитывает попадание в окно key, если в нём меньше limit попаданий.
+	Hit(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (Window, error)
+	// Incr увеличивает счётчик и продлевает его жизнь до ttl.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Expire продлевает жизнь счётчика из Incr.
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	// Lock создаёт ключ, который живёт ttl.
+	Lock(ctx context.Context, key string, ttl time.Duration) error
+	// LockedFor - сколько ещё живёт ключ из Lock, 0 если его нет.
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	Del(ctx context.Context, keys ...string) error
+}
+
+// MemoryStore - хранилище в памяти процесса: лимиты считаются отдельно на
+// каждом экземпляре. Используется, когда Redis недоступен.
+type MemoryStore struct {
+	mu       sync.Mutex
+	hits     map[string][]time.Time
+	counters map[string]memCounter
+	locks    map[string]time.Time
+
+	nextSweep time.Time
+}
+
+type memCounter struct {
+	n       int64
+	expires time.Time
+}
+
+// как часто выбрасывать протухшие ключи, чтобы память не росла от разовых IP
+const sweepEvery = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		hits:     make(map[string][]time.Time),
+		counters: make(map[string]memCounter),
+		locks:    make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) Hit(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (Window, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	hits := trim(s.hits[key], now.Add(-window))
+	w := Window{Count: len(hits)}
+	if len(hits) < limit {
+		hits = append(hits, now)
+		w.Allowed = true
+		w.Count++
+	}
+	s.hits[key] = hits
+
+	w.Reset = now.Add(window)
+	if len(hits) > 0 {
+		w.Reset = hits[0].Add(window)
+	}
+
+	return w, nil
+}
+
+// trim отбрасывает попадания не позже since, hits отсортированы по времени.
+func trim(hits []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(since) {
+		i++
+	}
+	return hits[i:]
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	c := s.counters[key]
+	if now.After(c.expires) {
+		c.n = 0
+	}
+	c.n++
+	c.expires = now.Add(ttl)
+	s.counters[key] = c
+
+	return c.n, nil
+}
+
+func (s *MemoryStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.counters[key]; ok {
+		c.expires = time.Now().Add(ttl)
+		s.counters[key] = c
+	}
+	return nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locks[key] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *MemoryStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d := time.Until(s.locks[key]); d > 0 {
+		return d, nil
+	}
+	return 0, nil
+}
+
+func (s *MemoryStore) Del(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range keys {
+		delete(s.hits, k)
+		delete(s.counters, k)
+		delete(s.locks, k)
+	}
+	return nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(sweepEvery)
+
+	for k, hits := range s.hits {
+		// окно у ключа неизвестно, но последнее попадание старше часа точно не нужно
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) > time.Hour {
+			delete(s.hits, k)
+		}
+	}
+	for k, c := range s.counters {
+		if now.After(c.expires) {
+			delete(s.counters, k)
+		}
+	}
+	for k, until := range s.locks {
+		if now.After(until) {
+			delete(s.locks, k)
+		}
+	}
+}
+
+// FallbackStore ходит в primary, а при его ошибке - в fallback. Лучше считать
+// лимиты на каждом экземпляре отдельно, чем перестать их считать совсем.
+type FallbackStore struct {
+	primary  Store
+	fallback Store
+	log      logger.Logger
+	// primary сейчас недоступен, чтобы не писать предупреждение на каждый запрос
+	degraded atomic.Bool
+}
+
+func NewFallbackStore(primary, fallback Store, log logger.Logger) *FallbackStore {
+	return &FallbackStore{primary: primary, fallback: fallback, log: log}
+}
+
+func (s *FallbackStore) use(ctx context.Context, err error) bool {
+	if err == nil {
+		if s.degraded.Swap(false) {
+			s.log.Info(ctx, "rate limit store recovered")
+		}
+		return false
+	}
+
+	if !s.degraded.Swap(true) {
+		s.log.Warn(ctx, "rate limit store unavailable, using in-memory limits", logger.Field{Key: "error", Value: err})
+	}
+	return true
+}
+
+func (s *FallbackStore) Hit(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (Window, error) {
+	w, err := s.primary.Hit(ctx, key, limit, window, now)
+	if s.use(ctx, err) {
+		return s.fallback.Hit(ctx, key, limit, window, now)
+	}
+	return w, nil
+}
+
+func (s *FallbackStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := s.primary.Incr(ctx, key, ttl)
+	if s.use(ctx, err) {
+		return s.fallback.Incr(ctx, key, ttl)
+	}
+	return n, nil
+}
+
+func (s *FallbackStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	err := s.primary.Expire(ctx, key, ttl)
+	if s.use(ctx, err) {
+		return s.fallback.Expire(ctx, key, ttl)
+	}
+	return nil
+}
+
+func (s *FallbackStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	err := s.primary.Lock(ctx, key, ttl)
+	if s.use(ctx, err) {
+		return s.fallback.Lock(ctx, key, ttl)
+	}
+	return nil
+}
+
+// LockedFor смотрит в оба хранилища: блокировка могла быть поставлена в память,
+// пока Redis лежал.
+func (s *FallbackStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	mem, _ := s.fallback.LockedFor(ctx, key)
+
+	d, err := s.primary.LockedFor(ctx, key)
+	if s.use(ctx, err) {
+		return mem, nil
+	}
+	return max(d, mem), nil
+}
+
+func (s *FallbackStore) Del(ctx context.Context, keys ...string) error {
+	_ = s.fallback.Del(ctx, keys...)
+	s.use(ctx, s.primary.Del(ctx, keys...))
+	return nil
+}
@@ -0,0 +1,83 @@
+package rbac
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "rbac.principal"
+
+type principalCtxKey struct{}
+
+// SetPrincipal кладёт p и в gin.Context, и в context запроса,
+// чтобы до него могли дотянуться слои ниже хендлеров (например аудит в репозиториях).
+func SetPrincipal(c *gin.Context, p *Principal) {
+	c.Set(principalKey, p)
+	c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), p))
+}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalCtxKey{}).(*Principal)
+	return p
+}
+
+// PrincipalFrom возвращает nil, если запрос не прошёл аутентификацию.
+func PrincipalFrom(c *gin.Context) *Principal {
+	v, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+
+	p, _ := v.(*Principal)
+	return p
+}
+
+func RequirePermission(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := PrincipalFrom(c)
+		if p == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthenticated"})
+			return
+		}
+
+		if !p.Can(perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrForbidden.Error()})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireOwnerOr пропускает, если id из параметра пути param совпадает с пользователем запроса,
+// или у него есть право perm.
+func RequireOwnerOr(param string, perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := PrincipalFrom(c)
+		if p == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthenticated"})
+			return
+		}
+
+		ownerID, err := strconv.ParseInt(c.Param(param), 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid " + param})
+			return
+		}
+
+		// 404 вместо 403, чтобы по ответу нельзя было перебирать существующие id
+		if !p.CanAccess(ownerID, perm) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,73 @@
+// Package rbac - роли пользователей и права, которые из них следуют.
+package rbac
+
+import (
+	"errors"
+	"slices"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+type Role string
+
+const (
+	RoleUser            Role = "user"
+	RoleAdmin           Role = "admin"
+	RoleSupportReadonly Role = "support-readonly"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleAdmin, RoleSupportReadonly:
+		return true
+	default:
+		return false
+	}
+}
+
+type Permission string
+
+const (
+	// читать чужие профили и списки пользователей
+	PermUsersRead Permission = "users:read"
+	// менять чужие профили, роли, блокировать
+	PermUsersWrite       Permission = "users:write"
+	PermUsersImpersonate Permission = "users:impersonate"
+	PermAuditRead        Permission = "audit:read"
+	// настройки работающего сервиса: уровень логов и т.п.
+	PermSystemManage Permission = "system:manage"
+)
+
+// обычному пользователю дополнительных прав не нужно, к своим данным он ходит через проверку владельца
+var rolePermissions = map[Role][]Permission{
+	RoleUser:            {},
+	RoleSupportReadonly: {PermUsersRead, PermAuditRead},
+	RoleAdmin:           {PermUsersRead, PermUsersWrite, PermUsersImpersonate, PermAuditRead, PermSystemManage},
+}
+
+func HasPermission(role Role, perm Permission) bool {
+	return slices.Contains(rolePermissions[role], perm)
+}
+
+// Principal - кто выполняет запрос.
+type Principal struct {
+	UserID int64
+	Role   Role
+	// не 0, если админ зашёл под этим пользователем
+	ImpersonatorID int64
+}
+
+func (p *Principal) Can(perm Permission) bool {
+	// под чужой учёткой админ не получает админских прав, даже если заходит под другим админом
+	if p.ImpersonatorID != 0 {
+		return false
+	}
+
+	return HasPermission(p.Role, perm)
+}
+
+// CanAccess - проверка на уровне строки: свои данные доступны всегда, чужие только с правом perm.
+// Её же должны использовать хендлеры счетов и транзакций, передавая id владельца записи.
+func (p *Principal) CanAccess(ownerID int64, perm Permission) bool {
+	return p.UserID == ownerID || p.Can(perm)
+}
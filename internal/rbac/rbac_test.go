@@ -0,0 +1,59 @@
+package rbac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipal_CanAccess(t *testing.T) {
+	cases := []struct {
+		name      string
+		principal Principal
+		ownerID   int64
+		perm      Permission
+		want      bool
+	}{
+		{name: "own data", principal: Principal{UserID: 1, Role: RoleUser}, ownerID: 1, perm: PermUsersWrite, want: true},
+		{name: "foreign data", principal: Principal{UserID: 1, Role: RoleUser}, ownerID: 2, perm: PermUsersRead, want: false},
+		{name: "support reads", principal: Principal{UserID: 1, Role: RoleSupportReadonly}, ownerID: 2, perm: PermUsersRead, want: true},
+		{name: "support cannot write", principal: Principal{UserID: 1, Role: RoleSupportReadonly}, ownerID: 2, perm: PermUsersWrite, want: false},
+		{name: "admin writes", principal: Principal{UserID: 1, Role: RoleAdmin}, ownerID: 2, perm: PermUsersWrite, want: true},
+		{name: "impersonated admin", principal: Principal{UserID: 1, Role: RoleAdmin, ImpersonatorID: 3}, ownerID: 2, perm: PermUsersRead, want: false},
+		{name: "impersonated own data", principal: Principal{UserID: 2, Role: RoleUser, ImpersonatorID: 3}, ownerID: 2, perm: PermUsersRead, want: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, tc.principal.CanAccess(tc.ownerID, tc.perm))
+		})
+	}
+}
+
+func TestRequireOwnerOr(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	serve := func(p *Principal, path string) int {
+		r := gin.New()
+		r.GET("/users/:id", func(c *gin.Context) {
+			if p != nil {
+				SetPrincipal(c, p)
+			}
+		}, RequireOwnerOr("id", PermUsersRead), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, serve(&Principal{UserID: 1, Role: RoleUser}, "/users/1"))
+	require.Equal(t, http.StatusNotFound, serve(&Principal{UserID: 1, Role: RoleUser}, "/users/2"))
+	require.Equal(t, http.StatusOK, serve(&Principal{UserID: 1, Role: RoleSupportReadonly}, "/users/2"))
+	require.Equal(t, http.StatusUnauthorized, serve(nil, "/users/1"))
+	require.Equal(t, http.StatusBadRequest, serve(&Principal{UserID: 1, Role: RoleUser}, "/users/abc"))
+}
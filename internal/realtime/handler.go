@@ -0,0 +1,156 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/delta"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// изменений за одно событие, остальное придёт следующими событиями сразу за ним
+const pageSize = 100
+
+// Changes - изменения, которые поток умеет дочитывать из журнала.
+type Changes interface {
+	Pull(ctx context.Context, userID int64, since string, limit int) (delta.Page, error)
+	Head(ctx context.Context, userID int64) (string, error)
+}
+
+type Handler struct {
+	hub       *Hub
+	changes   Changes
+	heartbeat time.Duration
+	log       logger.Logger
+}
+
+func NewHandler(hub *Hub, changes Changes, heartbeat time.Duration, log logger.Logger) *Handler {
+	return &Handler{hub: hub, changes: changes, heartbeat: heartbeat, log: log}
+}
+
+// Register ожидает, что r уже требует аутентификацию.
+func (h *Handler) Register(r gin.IRouter) {
+	r.GET("/events", h.events)
+}
+
+// events - Server-Sent Events. id события - токен синхронизации: браузер сам
+// пришлёт его в Last-Event-ID при переподключении, а клиент без EventSource
+// может передать его в last_event_id или в since для GET /sync.
+func (h *Handler) events(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := rbac.PrincipalFrom(c).UserID
+
+	token := c.GetHeader("Last-Event-ID")
+	if token == "" {
+		token = c.Query("last_event_id")
+	}
+	if token != "" {
+		if _, err := delta.ParseToken(token); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// подписка до первого чтения журнала, иначе изменение между ними потерялось бы
+	sub, err := h.hub.Subscribe(userID)
+	switch {
+	case errors.Is(err, ErrTooManyStreams):
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrClosed):
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server is shutting down"})
+		return
+	}
+	defer sub.Close()
+
+	if token == "" {
+		token, err = h.changes.Head(ctx, userID)
+		if err != nil {
+			h.log.Error(ctx, "events handler failed", logger.Field{Key: "error", Value: err})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+	}
+
+	// поток живёт дольше, чем WriteTimeout сервера
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		h.log.Warn(ctx, "cannot disable write deadline for event stream", logger.Field{Key: "error", Value: err})
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// nginx иначе буферизует ответ
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	err = h.stream(ctx, sub, userID, token, func(page delta.Page) error {
+		return writeEvent(c.Writer, page)
+	}, func() error {
+		_, err := io.WriteString(c.Writer, ": ping\n\n")
+		c.Writer.Flush()
+		return err
+	})
+	if err != nil && ctx.Err() == nil {
+		h.log.Warn(ctx, "event stream closed with error", logger.Field{Key: "error", Value: err})
+	}
+}
+
+// stream дочитывает журнал после token при каждом сигнале, пока клиент не
+// отключится или сервер не остановится.
+func (h *Handler) stream(ctx context.Context, sub *Subscription, userID int64, token string,
+	send func(delta.Page) error, ping func() error) error {
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+
+	for pull := true; ; {
+		for pull {
+			page, err := h.changes.Pull(ctx, userID, token, pageSize)
+			if err != nil {
+				return err
+			}
+
+			// страница может оказаться пустой, если в ней только типы, которых сервер не отдаёт
+			if len(page.Changes) > 0 {
+				if err := send(page); err != nil {
+					return err
+				}
+			}
+			token, pull = page.Next, page.HasMore
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.Done():
+			return nil
+		case <-sub.Wake():
+			pull = true
+		case <-ticker.C:
+			if err := ping(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeEvent(w gin.ResponseWriter, page delta.Page) error {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "id: %s\nevent: changes\ndata: %s\n\n", page.Next, data); err != nil {
+		return err
+	}
+	w.Flush()
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package realtime отдаёт клиентам изменения их данных сразу, как только они
+// записаны. Сигналы приходят из базы через NOTIFY, сами изменения читаются из
+// журнала синхронизации (пакет delta), поэтому поток можно продолжить с
+// последнего полученного события.
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// Channel - канал NOTIFY, в него пишет sync_touch.
+const Channel = "mm_changes"
+
+var (
+	ErrTooManyStreams = errors.New("too many open streams")
+	ErrClosed         = errors.New("hub is closed")
+)
+
+// Hub будит потоки пользователя, когда у него появились изменения. Сами изменения
+// через Hub не передаются: поток дочитывает их из журнала, так пропущенный
+// сигнал ничего не теряет.
+type Hub struct {
+	mu      sync.Mutex
+	streams map[int64]map[*Subscription]struct{}
+	limit   int
+	closed  bool
+	done    chan struct{}
+	log     logger.Logger
+}
+
+// Subscription - подписка одного потока.
+type Subscription struct {
+	hub    *Hub
+	userID int64
+	wake   chan struct{}
+}
+
+func NewHub(maxPerUser int, log logger.Logger) *Hub {
+	return &Hub{
+		streams: make(map[int64]map[*Subscription]struct{}),
+		limit:   maxPerUser,
+		done:    make(chan struct{}),
+		log:     log,
+	}
+}
+
+func (h *Hub) Subscribe(userID int64) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+	if len(h.streams[userID]) >= h.limit {
+		return nil, ErrTooManyStreams
+	}
+
+	s := &Subscription{hub: h, userID: userID, wake: make(chan struct{}, 1)}
+	if h.streams[userID] == nil {
+		h.streams[userID] = make(map[*Subscription]struct{})
+	}
+	h.streams[userID][s] = struct{}{}
+
+	return s, nil
+}
+
+// Notify будит все потоки пользователя.
+func (h *Hub) Notify(userID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.streams[userID] {
+		s.signal()
+	}
+}
+
+// NotifyAll - после переподключения слушателя: пока его не было, сигналы терялись.
+func (h *Hub) NotifyAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, subs := range h.streams {
+		for s := range subs {
+			s.signal()
+		}
+	}
+}
+
+// HandleNotification - обработчик для storage.Listener.
+func (h *Hub) HandleNotification(n storage.Notification) {
+	var payload struct {
+		UserID int64 `json:"user_id"`
+	}
+	if err := json.Unmarshal([]byte(n.Payload), &payload); err != nil || payload.UserID == 0 {
+		h.log.Warn(context.Background(), "malformed change notification",
+			logger.Field{Key: "channel", Value: n.Channel},
+			logger.Field{Key: "payload", Value: n.Payload})
+		return
+	}
+
+	h.Notify(payload.UserID)
+}
+
+// Close завершает все потоки, вызывается перед остановкой сервера: иначе
+// открытые потоки держали бы Shutdown до конца таймаута.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.closed {
+		h.closed = true
+		close(h.done)
+	}
+}
+
+// Wake - сигнал, что у пользователя появились изменения. Несколько сигналов
+// подряд сливаются в один.
+func (s *Subscription) Wake() <-chan struct{} {
+	return s.wake
+}
+
+// Done закрывается, когда сервер останавливается.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.hub.done
+}
+
+func (s *Subscription) Close() {
+	h := s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.streams[s.userID], s)
+	if len(h.streams[s.userID]) == 0 {
+		delete(h.streams, s.userID)
+	}
+}
+
+func (s *Subscription) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
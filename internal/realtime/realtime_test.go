@@ -0,0 +1,183 @@
+package realtime
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/delta"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) Info(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Warn(ctx context.Context, msg string, fields ...logger.Field)  {}
+func (nopLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {}
+func (nopLogger) With(fields ...logger.Field) logger.Logger                     { return nopLogger{} }
+func (nopLogger) Sync() error                                                   { return nil }
+
+// fakeChanges - журнал одного пользователя, номер изменения = индекс + 1
+type fakeChanges struct {
+	mu      sync.Mutex
+	changes []delta.Change
+}
+
+func (f *fakeChanges) add(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.changes = append(f.changes, delta.Change{Type: "user", ID: id, Op: delta.OpUpsert})
+}
+
+func (f *fakeChanges) Pull(ctx context.Context, userID int64, since string, limit int) (delta.Page, error) {
+	seq, err := delta.ParseToken(since)
+	if err != nil {
+		return delta.Page{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	page := delta.Page{Changes: []delta.Change{}, Next: since}
+	for i := int(seq); i < len(f.changes) && len(page.Changes) < limit; i++ {
+		page.Changes = append(page.Changes, f.changes[i])
+		page.Next = delta.EncodeToken(int64(i + 1))
+	}
+	return page, nil
+}
+
+func (f *fakeChanges) Head(ctx context.Context, userID int64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return delta.EncodeToken(int64(len(f.changes))), nil
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub(2, nopLogger{})
+
+	a, err := hub.Subscribe(1)
+	require.NoError(t, err)
+	b, err := hub.Subscribe(1)
+	require.NoError(t, err)
+	_, err = hub.Subscribe(1)
+	require.ErrorIs(t, err, ErrTooManyStreams)
+
+	other, err := hub.Subscribe(2)
+	require.NoError(t, err)
+
+	// сигналы сливаются, чужой пользователь их не получает
+	hub.HandleNotification(storage.Notification{Channel: Channel, Payload: `{"user_id":1,"seq":5}`})
+	hub.Notify(1)
+	hub.HandleNotification(storage.Notification{Channel: Channel, Payload: `garbage`})
+	require.Len(t, a.Wake(), 1)
+	require.Len(t, b.Wake(), 1)
+	require.Len(t, other.Wake(), 0)
+
+	hub.NotifyAll()
+	require.Len(t, other.Wake(), 1)
+
+	// закрытая подписка освобождает место
+	a.Close()
+	_, err = hub.Subscribe(1)
+	require.NoError(t, err)
+
+	hub.Close()
+	<-b.Done()
+	_, err = hub.Subscribe(3)
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+type event struct {
+	id, name, data string
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) event {
+	t.Helper()
+
+	var e event
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && e.name != "":
+			return e
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestHandler_Events(t *testing.T) {
+	changes := &fakeChanges{}
+	changes.add("1")
+	changes.add("2")
+
+	hub := NewHub(5, nopLogger{})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		rbac.SetPrincipal(c, &rbac.Principal{UserID: 1, Role: rbac.RoleUser})
+	})
+	NewHandler(hub, changes, 20*time.Millisecond, nopLogger{}).Register(r)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// клиент уже видел первое изменение и продолжает со второго
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", delta.EncodeToken(1))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	body := bufio.NewReader(resp.Body)
+	e := readEvent(t, body)
+	require.Equal(t, delta.EncodeToken(2), e.id)
+	require.Contains(t, e.data, `"id":"2"`)
+	require.NotContains(t, e.data, `"id":"1"`)
+
+	changes.add("3")
+	hub.Notify(1)
+	e = readEvent(t, body)
+	require.Equal(t, delta.EncodeToken(3), e.id)
+	require.Contains(t, e.data, `"id":"3"`)
+
+	// при остановке сервера поток завершается сам
+	hub.Close()
+	_, err = body.ReadString(0)
+	require.Error(t, err)
+}
+
+func TestHandler_EventsBadToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		rbac.SetPrincipal(c, &rbac.Principal{UserID: 1, Role: rbac.RoleUser})
+	})
+	NewHandler(NewHub(5, nopLogger{}), &fakeChanges{}, time.Second, nopLogger{}).Register(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?last_event_id=garbage", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
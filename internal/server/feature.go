@@ -0,0 +1,20 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireFeature отвечает 404 на маршруты выключенного флага, как будто их нет.
+// enabled спрашивается на каждый запрос, так что флаг можно менять на лету,
+// например config.Watcher.Feature.
+func RequireFeature(name string, enabled func(name string) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled(name) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.Next()
+	}
+}
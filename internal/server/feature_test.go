@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireFeature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var on atomic.Bool
+	r := gin.New()
+	r.GET("/sync", RequireFeature("sync", func(name string) bool {
+		require.Equal(t, "sync", name)
+		return on.Load()
+	}), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sync", nil))
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusNotFound, serve())
+	// флаг читается на каждый запрос, перезапуск не нужен
+	on.Store(true)
+	require.Equal(t, http.StatusOK, serve())
+}
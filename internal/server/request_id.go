@@ -0,0 +1,56 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	// длиннее обычно не бывает, а в логи не хочется пускать что угодно
+	maxRequestIDLen = 128
+)
+
+// RequestID берёт X-Request-ID клиента или прокси, а если его нет - генерирует новый.
+// id возвращается в ответе и попадает в поля логгера через context запроса.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := NormalizeRequestID(c.GetHeader(RequestIDHeader))
+
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logger.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// NormalizeRequestID возвращает id клиента, если его можно пустить в логи, иначе новый.
+func NormalizeRequestID(id string) string {
+	if !validRequestID(id) {
+		return newRequestID()
+	}
+
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+
+	var seen string
+	r.GET("/", func(c *gin.Context) {
+		seen = logger.RequestIDFromContext(c.Request.Context())
+	})
+
+	cases := []struct {
+		name     string
+		header   string
+		wantSame bool
+	}{
+		{name: "propagated", header: "abc-123", wantSame: true},
+		{name: "generated when missing"},
+		{name: "generated when invalid", header: "bad id\n"},
+		{name: "generated when too long", header: strings.Repeat("a", maxRequestIDLen+1)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set(RequestIDHeader, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(RequestIDHeader)
+			require.NotEmpty(t, got)
+			require.Equal(t, got, seen)
+			if tc.wantSame {
+				require.Equal(t, tc.header, got)
+			} else {
+				require.NotEqual(t, tc.header, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// NewRouter создаёт движок с общими middleware, маршруты регистрируют доменные пакеты.
+// X-Forwarded-For учитывается только от trustedProxies, без них c.ClientIP() -
+// адрес соединения, иначе лимиты по IP обходятся подделанным заголовком.
+func NewRouter(env string, trustedProxies []string, log logger.Logger) (*gin.Engine, error) {
+	if env != "dev" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	r.Use(RequestID(), gin.Recovery(), requestLogger(log))
+
+	return r, nil
+}
+
+func requestLogger(log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		log.Info(c.Request.Context(), "http request",
+			logger.Field{Key: "method", Value: c.Request.Method},
+			logger.Field{Key: "path", Value: c.FullPath()},
+			logger.Field{Key: "status", Value: c.Writer.Status()},
+			logger.Field{Key: "duration", Value: time.Since(start)})
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+type Server struct {
+	srv             *http.Server
+	log             logger.Logger
+	shutdownTimeout time.Duration
+	beforeShutdown  []func()
+}
+
+func New(cfg *config.Config, handler http.Handler, log logger.Logger) *Server {
+	return &Server{
+		srv: &http.Server{
+			Addr:         net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port)),
+			Handler:      handler,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		},
+		log:             log,
+		shutdownTimeout: cfg.Timeouts.ShutdownGracePeriod,
+	}
+}
+
+// NewMetrics - отдельный сервер для /metrics на server.metricsPort, чтобы метрики
+// не торчали наружу вместе с API. Таймауты те же, что у основного сервера.
+func NewMetrics(cfg *config.Config, handler http.Handler, log logger.Logger) *Server {
+	s := New(cfg, handler, log)
+
+	s.srv.Addr = net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.MetricsPort))
+	s.log = log.With(logger.Field{Key: "server", Value: "metrics"})
+
+	return s
+}
+
+// BeforeShutdown регистрирует fn, которая вызывается при отмене ctx до остановки сервера,
+// пока он ещё принимает запросы. Нужна, чтобы /readyz успел ответить 503.
+func (s *Server) BeforeShutdown(fn func()) {
+	s.beforeShutdown = append(s.beforeShutdown, fn)
+}
+
+// Run блокируется до отмены ctx, после чего даёт активным запросам
+// shutdownTimeout на завершение.
+func (s *Server) Run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		s.log.Info(ctx, "http server started", logger.Field{Key: "addr", Value: s.srv.Addr})
+		if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("http server: %w", err)
+	case <-ctx.Done():
+	}
+
+	for _, fn := range s.beforeShutdown {
+		fn()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	s.log.Info(ctx, "shutting down http server")
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("http server shutdown: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// Notification - сообщение из NOTIFY.
+type Notification struct {
+	Channel string
+	Payload string
+}
+
+type listenConn interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	WaitForNotification(ctx context.Context) (*pgconn.Notification, error)
+	Close(ctx context.Context) error
+}
+
+// Listener держит отдельное от пула соединение с LISTEN на каналы: соединение
+// из пула после LISTEN нельзя отдавать другим запросам. Всегда слушает основную
+// базу, на репликах NOTIFY не приходит.
+type Listener struct {
+	connect  func(ctx context.Context) (listenConn, error)
+	channels []string
+	backoff  Backoff
+	log      logger.Logger
+}
+
+func NewListener(db *DB, backoff Backoff, log logger.Logger, channels ...string) *Listener {
+	return &Listener{
+		connect: func(ctx context.Context) (listenConn, error) {
+			if db.connConfig == nil {
+				return nil, errors.New("listener: db has no connection config")
+			}
+			return pgx.ConnectConfig(ctx, db.connConfig.Copy())
+		},
+		channels: channels,
+		backoff:  backoff,
+		log:      log,
+	}
+}
+
+// Run слушает до отмены ctx и переподключается с паузой backoff, если соединение
+// упало. handle вызывается в горутине Run и не должна блокироваться. onConnect
+// вызывается после каждого подключения: пока соединения не было, уведомления
+// терялись, и подписчикам нужно перечитать состояние.
+func (l *Listener) Run(ctx context.Context, handle func(Notification), onConnect func()) {
+	attempt := 0
+	for {
+		err := l.listen(ctx, handle, onConnect, &attempt)
+		if ctx.Err() != nil {
+			return
+		}
+
+		attempt++
+		delay := l.backoff.Delay(attempt)
+		l.log.Warn(ctx, "listener connection lost",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "attempt", Value: attempt},
+			logger.Field{Key: "retry_in", Value: delay})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (l *Listener) listen(ctx context.Context, handle func(Notification), onConnect func(), attempt *int) error {
+	conn, err := l.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = conn.Close(closeCtx)
+	}()
+
+	for _, ch := range l.channels {
+		if _, err := conn.Exec(ctx, "listen "+pgx.Identifier{ch}.Sanitize()); err != nil {
+			return fmt.Errorf("listen %s: %w", ch, err)
+		}
+	}
+
+	if *attempt > 0 {
+		l.log.Info(ctx, "listener reconnected", logger.Field{Key: "attempts", Value: *attempt})
+	}
+	*attempt = 0
+	onConnect()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(Notification{Channel: n.Channel, Payload: n.Payload})
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeListenConn struct {
+	execs   []string
+	pending []string
+	// вызывается, когда уведомления закончились
+	done   func(ctx context.Context) error
+	closed bool
+}
+
+func (c *fakeListenConn) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	c.execs = append(c.execs, sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func (c *fakeListenConn) WaitForNotification(ctx context.Context) (*pgconn.Notification, error) {
+	if len(c.pending) == 0 {
+		return nil, c.done(ctx)
+	}
+	n := &pgconn.Notification{Channel: "mm_changes", Payload: c.pending[0]}
+	c.pending = c.pending[1:]
+	return n, nil
+}
+
+func (c *fakeListenConn) Close(ctx context.Context) error {
+	c.closed = true
+	return nil
+}
+
+func TestListener_Reconnects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := &fakeListenConn{
+		pending: []string{"a", "b"},
+		done:    func(context.Context) error { return errors.New("connection reset") },
+	}
+	second := &fakeListenConn{
+		pending: []string{"c"},
+		done: func(ctx context.Context) error {
+			cancel()
+			return ctx.Err()
+		},
+	}
+
+	dials := 0
+	log := newRecLogger()
+	l := &Listener{
+		connect: func(ctx context.Context) (listenConn, error) {
+			dials++
+			switch dials {
+			case 1:
+				return nil, errors.New("connection refused")
+			case 2:
+				return first, nil
+			default:
+				return second, nil
+			}
+		},
+		channels: []string{"mm_changes"},
+		backoff:  Backoff{Initial: time.Millisecond},
+		log:      log,
+	}
+
+	var got []string
+	connects := 0
+	l.Run(ctx, func(n Notification) { got = append(got, n.Payload) }, func() { connects++ })
+
+	require.Equal(t, 3, dials)
+	require.Equal(t, 2, connects)
+	require.Equal(t, []string{"a", "b", "c"}, got)
+	require.Equal(t, []string{`listen "mm_changes"`}, first.execs)
+	require.True(t, first.closed)
+	require.True(t, second.closed)
+
+	var warns int
+	for _, e := range *log.entries {
+		if e.level == "warn" {
+			warns++
+		}
+	}
+	require.Equal(t, 2, warns)
+}
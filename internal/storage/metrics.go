@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolStats - статистика pgxpool, снимается на каждый scrape.
+type poolStats struct {
+	pool *pgxpool.Pool
+
+	acquired     *prometheus.Desc
+	idle         *prometheus.Desc
+	total        *prometheus.Desc
+	max          *prometheus.Desc
+	acquireCount *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+// name - метка pool: primary или replicaN.
+func newPoolStats(pool *pgxpool.Pool, name string) *poolStats {
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc("mm_db_pool_"+metric, help, nil, prometheus.Labels{"pool": name})
+	}
+
+	return &poolStats{
+		pool:         pool,
+		acquired:     desc("acquired_conns", "Соединения, занятые запросами."),
+		idle:         desc("idle_conns", "Свободные соединения в пуле."),
+		total:        desc("total_conns", "Все соединения пула."),
+		max:          desc("max_conns", "Максимальный размер пула."),
+		acquireCount: desc("acquires_total", "Сколько раз соединение бралось из пула."),
+		waitCount:    desc("empty_acquires_total", "Сколько раз пришлось ждать свободное соединение."),
+		waitDuration: desc("acquire_wait_seconds_total", "Суммарное время получения соединений из пула, включая ожидание."),
+	}
+}
+
+func (s *poolStats) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{s.acquired, s.idle, s.total, s.max, s.acquireCount, s.waitCount, s.waitDuration} {
+		ch <- d
+	}
+}
+
+func (s *poolStats) Collect(ch chan<- prometheus.Metric) {
+	st := s.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(s.acquired, prometheus.GaugeValue, float64(st.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(s.idle, prometheus.GaugeValue, float64(st.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(s.total, prometheus.GaugeValue, float64(st.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(s.max, prometheus.GaugeValue, float64(st.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(s.acquireCount, prometheus.CounterValue, float64(st.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(s.waitCount, prometheus.CounterValue, float64(st.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(s.waitDuration, prometheus.CounterValue, st.AcquireDuration().Seconds())
+}
+
+// replicaStats - состояние реплик по результатам последней проверки.
+type replicaStats struct {
+	replicas []*replica
+
+	up  *prometheus.Desc
+	lag *prometheus.Desc
+}
+
+func newReplicaStats(replicas []*replica) *replicaStats {
+	return &replicaStats{
+		replicas: replicas,
+		up:       prometheus.NewDesc("mm_db_replica_up", "1, если реплика участвует в чтении.", []string{"pool"}, nil),
+		lag:      prometheus.NewDesc("mm_db_replica_lag_seconds", "Отставание реплики на последней успешной проверке.", []string{"pool"}, nil),
+	}
+}
+
+func (s *replicaStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.up
+	ch <- s.lag
+}
+
+func (s *replicaStats) Collect(ch chan<- prometheus.Metric) {
+	for _, r := range s.replicas {
+		up := 0.0
+		if r.healthy.Load() {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(s.up, prometheus.GaugeValue, up, r.name)
+		ch <- prometheus.MustNewConstMetric(s.lag, prometheus.GaugeValue, time.Duration(r.lag.Load()).Seconds(), r.name)
+	}
+}
+
+// Collectors - метрики базы для регистрации в prometheus: длительность запросов,
+// статистика пулов и состояние реплик, если DB создан через Connect.
+func (db *DB) Collectors() []prometheus.Collector {
+	var cs []prometheus.Collector
+	if db.tracer != nil {
+		cs = append(cs, db.tracer)
+	}
+	if pool, ok := db.Pool.(*pgxpool.Pool); ok {
+		cs = append(cs, newPoolStats(pool, "primary"))
+	}
+	for _, r := range db.replicas {
+		if pool, ok := r.pool.(*pgxpool.Pool); ok {
+			cs = append(cs, newPoolStats(pool, r.name))
+		}
+	}
+	if len(db.replicas) > 0 {
+		cs = append(cs, newReplicaStats(db.replicas))
+	}
+
+	return cs
+}
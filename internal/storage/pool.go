@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/skinkvi/money_managment/internal/config"
+)
+
+// poolConfig собирает конфиг пула через pgxpool.ParseConfig, чтобы sslmode,
+// файлы сертификатов и fallback-хосты разбирал сам pgx.
+func poolConfig(cfg config.DBConfig) (*pgxpool.Config, error) {
+	connString := cfg.URL
+	if connString == "" {
+		connString = keywordConnString(cfg)
+	}
+
+	poolCfg, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		// в тексте ошибки pgx есть строка подключения, а пароль в кавычках он
+		// маскирует не всегда - отдаём только причину
+		if cause := errors.Unwrap(err); cause != nil {
+			return nil, fmt.Errorf("parse db config: %w", cause)
+		}
+		return nil, errors.New("parse db config: invalid connection settings")
+	}
+
+	if cfg.MaxConn > 0 {
+		poolCfg.MaxConns = int32(cfg.MaxConn)
+	}
+	if cfg.MinConns > 0 {
+		poolCfg.MinConns = int32(cfg.MinConns)
+	}
+	if poolCfg.MinConns > poolCfg.MaxConns {
+		return nil, fmt.Errorf("parse db config: min_conns (%d) greater than max_conns (%d)", poolCfg.MinConns, poolCfg.MaxConns)
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolCfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolCfg.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	params := poolCfg.ConnConfig.RuntimeParams
+	if cfg.StatementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	}
+	if cfg.ApplicationName != "" && params["application_name"] == "" {
+		params["application_name"] = cfg.ApplicationName
+	}
+
+	return poolCfg, nil
+}
+
+// keywordConnString - строка вида "host=... port=..." из отдельных полей.
+func keywordConnString(cfg config.DBConfig) string {
+	var b strings.Builder
+	add := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(quoteConnValue(value))
+	}
+
+	add("host", cfg.Host)
+	if cfg.Port != 0 {
+		add("port", strconv.Itoa(cfg.Port))
+	}
+	add("user", cfg.User)
+	add("password", cfg.Password)
+	add("dbname", cfg.DBName)
+	add("sslmode", cfg.SSLMode)
+	add("sslrootcert", cfg.SSLRootCert)
+	add("sslcert", cfg.SSLCert)
+	add("sslkey", cfg.SSLKey)
+
+	return b.String()
+}
+
+// quoteConnValue экранирует значение по правилам libpq: в кавычки, \ и ' через \.
+func quoteConnValue(v string) string {
+	if !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+// Backoff - экспоненциальная пауза между попытками: Initial, 2*Initial, ... но не больше Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Initial
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if b.Max > 0 && d >= b.Max {
+			return b.Max
+		}
+	}
+	if b.Max > 0 && d > b.Max {
+		return b.Max
+	}
+	return d
+}
+
+// Retry вызывает fn до attempts раз (минимум один), пока она не вернёт nil.
+// Возвращает последнюю ошибку или ошибку контекста, если его отменили во время паузы.
+func Retry(ctx context.Context, attempts int, backoff Backoff, fn func(attempt int) error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(attempt); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		timer := time.NewTimer(backoff.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+
+	return err
+}
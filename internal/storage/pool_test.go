@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolConfig_FromFields(t *testing.T) {
+	cfg := config.DBConfig{
+		Host:              "db.internal",
+		Port:              6432,
+		User:              "mm",
+		Password:          "p a'ss",
+		DBName:            "money",
+		SSLMode:           "disable",
+		MaxConn:           20,
+		MinConns:          2,
+		MaxConnLifetime:   time.Hour,
+		MaxConnIdleTime:   10 * time.Minute,
+		HealthCheckPeriod: 30 * time.Second,
+		StatementTimeout:  5 * time.Second,
+		ApplicationName:   "mm-test",
+	}
+
+	pc, err := poolConfig(cfg)
+	require.NoError(t, err)
+	require.Equal(t, "db.internal", pc.ConnConfig.Host)
+	require.Equal(t, uint16(6432), pc.ConnConfig.Port)
+	require.Equal(t, "p a'ss", pc.ConnConfig.Password)
+	require.Equal(t, "money", pc.ConnConfig.Database)
+	require.Nil(t, pc.ConnConfig.TLSConfig)
+	require.Equal(t, int32(20), pc.MaxConns)
+	require.Equal(t, int32(2), pc.MinConns)
+	require.Equal(t, time.Hour, pc.MaxConnLifetime)
+	require.Equal(t, 10*time.Minute, pc.MaxConnIdleTime)
+	require.Equal(t, 30*time.Second, pc.HealthCheckPeriod)
+	require.Equal(t, "5000", pc.ConnConfig.RuntimeParams["statement_timeout"])
+	require.Equal(t, "mm-test", pc.ConnConfig.RuntimeParams["application_name"])
+}
+
+func TestPoolConfig_URL(t *testing.T) {
+	pc, err := poolConfig(config.DBConfig{
+		URL:             "postgres://mm:secret@db:5433/money?sslmode=require&pool_max_conns=7&application_name=from-url",
+		Host:            "ignored",
+		ApplicationName: "default",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "db", pc.ConnConfig.Host)
+	require.Equal(t, uint16(5433), pc.ConnConfig.Port)
+	require.NotNil(t, pc.ConnConfig.TLSConfig)
+	require.Equal(t, int32(7), pc.MaxConns)
+	require.Equal(t, "from-url", pc.ConnConfig.RuntimeParams["application_name"])
+}
+
+func TestPoolConfig_Invalid(t *testing.T) {
+	_, err := poolConfig(config.DBConfig{Host: "db", SSLMode: "sometimes", Password: "hunter2"})
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "hunter2")
+
+	_, err = poolConfig(config.DBConfig{Host: "db", SSLMode: "disable", MaxConn: 2, MinConns: 5})
+	require.Error(t, err)
+
+	_, err = poolConfig(config.DBConfig{Host: "db", SSLMode: "verify-full", SSLRootCert: "/nonexistent/ca.pem"})
+	require.Error(t, err)
+}
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	require.Equal(t, 100*time.Millisecond, b.Delay(1))
+	require.Equal(t, 200*time.Millisecond, b.Delay(2))
+	require.Equal(t, 800*time.Millisecond, b.Delay(4))
+	require.Equal(t, time.Second, b.Delay(5))
+	require.Equal(t, time.Second, b.Delay(50))
+}
+
+func TestRetry(t *testing.T) {
+	errDown := errors.New("connection refused")
+	b := Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}
+
+	calls := 0
+	err := Retry(context.Background(), 5, b, func(int) error {
+		calls++
+		if calls < 3 {
+			return errDown
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	calls = 0
+	err = Retry(context.Background(), 3, b, func(int) error { calls++; return errDown })
+	require.ErrorIs(t, err, errDown)
+	require.Equal(t, 3, calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Retry(ctx, 3, Backoff{Initial: time.Hour}, func(int) error { return errDown })
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// ConnectRedis создаёт клиента Redis. Redis у нас вспомогательный, поэтому недоступность
+// при старте не ошибка: клиент переподключится сам, а /readyz покажет проблему.
+func ConnectRedis(ctx context.Context, cfg config.RedisConfig, log logger.Logger) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.Address,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		PoolSize:     cfg.PoolSize,
+	})
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.Warn(ctx, "redis is not available", logger.Field{Key: "error", Value: err})
+	}
+
+	return client
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// replicaLagQuery - насколько реплика отстаёт от основной базы. Если всё
+// полученное WAL уже применено, отставания нет, даже когда на основной базе
+// давно не было записей и pg_last_xact_replay_timestamp старый.
+const replicaLagQuery = `select case
+	when not pg_is_in_recovery() then 0
+	when pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() then 0
+	else coalesce(extract(epoch from now() - pg_last_xact_replay_timestamp()), 0)
+end::float8`
+
+type replica struct {
+	name    string
+	pool    DBPool
+	healthy atomic.Bool
+	// последнее измеренное отставание в наносекундах
+	lag atomic.Int64
+}
+
+type primaryKey struct{}
+
+// WithPrimary помечает ctx так, что Reader вернёт основную базу. Нужно, когда
+// чтение сразу после записи должно увидеть её результат.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+// Reader - куда отправить запрос только на чтение: здоровая реплика по кругу,
+// а если реплик нет, все отстают или недоступны - основная база.
+// Запросы внутри транзакции идут через pgx.Tx и сюда не попадают.
+func (db *DB) Reader(ctx context.Context) Querier {
+	n := uint64(len(db.replicas))
+	if n == 0 || usePrimary(ctx) {
+		return db.Pool
+	}
+
+	start := db.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if r := db.replicas[(start+i)%n]; r.healthy.Load() {
+			return r.pool
+		}
+	}
+
+	return db.Pool
+}
+
+// checkReplicas проверяет все реплики и включает/исключает их из ротации.
+func (db *DB) checkReplicas(ctx context.Context) {
+	for _, r := range db.replicas {
+		lag, err := db.replicaLag(ctx, r)
+
+		healthy := err == nil && (db.maxLag <= 0 || lag <= db.maxLag)
+		if err == nil {
+			r.lag.Store(int64(lag))
+		}
+
+		if was := r.healthy.Swap(healthy); was == healthy {
+			continue
+		}
+
+		fields := []logger.Field{{Key: "replica", Value: r.name}, {Key: "lag", Value: lag}}
+		switch {
+		case healthy:
+			db.log.Info(ctx, "replica is back in rotation", fields...)
+		case err != nil:
+			db.log.Warn(ctx, "replica is unavailable, reads go to primary", append(fields, logger.Field{Key: "error", Value: err})...)
+		default:
+			db.log.Warn(ctx, "replica lags behind, reads go to primary", append(fields, logger.Field{Key: "max_lag", Value: db.maxLag})...)
+		}
+	}
+}
+
+func (db *DB) replicaLag(ctx context.Context, r *replica) (time.Duration, error) {
+	var seconds float64
+	if err := r.pool.QueryRow(ctx, replicaLagQuery).Scan(&seconds); err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// watchReplicas раз в period перепроверяет реплики, пока не вызван Close.
+func (db *DB) watchReplicas(period time.Duration) {
+	if period <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db.stop = cancel
+	db.done = make(chan struct{})
+
+	go func() {
+		defer close(db.done)
+
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkCtx, cancel := context.WithTimeout(ctx, period)
+				db.checkReplicas(checkCtx)
+				cancel()
+			}
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newReplicaDB(t *testing.T, n int) (*DB, []pgxmock.PgxPoolIface) {
+	t.Helper()
+
+	primary, err := pgxmock.NewPool()
+	require.NoError(t, err)
+
+	db := &DB{Pool: primary, log: newRecLogger(), maxLag: time.Second}
+	var mocks []pgxmock.PgxPoolIface
+	for i := 0; i < n; i++ {
+		m, err := pgxmock.NewPool()
+		require.NoError(t, err)
+		mocks = append(mocks, m)
+		db.replicas = append(db.replicas, &replica{name: "replica", pool: m})
+	}
+
+	return db, mocks
+}
+
+func expectLag(m pgxmock.PgxPoolIface, seconds float64) {
+	m.ExpectQuery(regexp.QuoteMeta("select case")).
+		WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow(seconds))
+}
+
+func TestReader_NoReplicas(t *testing.T) {
+	db, _ := newReplicaDB(t, 0)
+	require.Same(t, db.Pool, db.Reader(context.Background()))
+}
+
+func TestReader_RoundRobinAndEjection(t *testing.T) {
+	db, replicas := newReplicaDB(t, 2)
+	ctx := context.Background()
+
+	// до первой проверки реплики не в ротации
+	require.Same(t, db.Pool, db.Reader(ctx))
+
+	expectLag(replicas[0], 0)
+	expectLag(replicas[1], 0.2)
+	db.checkReplicas(ctx)
+
+	seen := map[Querier]int{}
+	for i := 0; i < 4; i++ {
+		seen[db.Reader(ctx)]++
+	}
+	require.Equal(t, map[Querier]int{replicas[0]: 2, replicas[1]: 2}, seen)
+
+	// явный запрос основной базы
+	require.Same(t, db.Pool, db.Reader(WithPrimary(ctx)))
+
+	// первая отстаёт, вторая недоступна - читаем с основной
+	expectLag(replicas[0], 30)
+	replicas[1].ExpectQuery(regexp.QuoteMeta("select case")).WillReturnError(errors.New("connection refused"))
+	db.checkReplicas(ctx)
+	require.Same(t, db.Pool, db.Reader(ctx))
+
+	// первая догнала
+	expectLag(replicas[0], 0)
+	replicas[1].ExpectQuery(regexp.QuoteMeta("select case")).WillReturnError(errors.New("connection refused"))
+	db.checkReplicas(ctx)
+	for i := 0; i < 3; i++ {
+		require.Same(t, replicas[0], db.Reader(ctx))
+	}
+
+	for _, m := range replicas {
+		require.NoError(t, m.ExpectationsWereMet())
+	}
+}
+
+func TestWithTx_UsesPrimary(t *testing.T) {
+	db, replicas := newReplicaDB(t, 1)
+	ctx := context.Background()
+
+	expectLag(replicas[0], 0)
+	db.checkReplicas(ctx)
+
+	primary := db.Pool.(pgxmock.PgxPoolIface)
+	primary.ExpectBegin()
+	primary.ExpectQuery("select 1").WillReturnRows(pgxmock.NewRows([]string{"n"}).AddRow(1))
+	primary.ExpectCommit()
+
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		var n int
+		return tx.QueryRow(ctx, "select 1").Scan(&n)
+	})
+	require.NoError(t, err)
+	require.NoError(t, primary.ExpectationsWereMet())
+	require.NoError(t, replicas[0].ExpectationsWereMet())
+}
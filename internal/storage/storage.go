@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/skinkvi/money_managment/migrations"
 	"github.com/skinkvi/money_managment/pkg/logger"
 )
 
@@ -16,45 +19,158 @@ var (
 	ErrUserAlreadyExists = errors.New("user already exists")
 	ErrDB                = errors.New("database error")
 	ErrNoUsers           = errors.New("no users found")
+	ErrUserNotFound      = errors.New("user not found")
+	// версия записи в базе не совпала с той, что прислал клиент
+	ErrVersionConflict = errors.New("version conflict")
 )
 
+// код ошибки postgres unique_violation
+const uniqueViolationCode = "23505"
+
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
+
+// Querier - общее у пула и транзакции, репозитории принимают его, когда запрос
+// должен выполниться внутри чужой транзакции.
+type Querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 type DBPool interface {
 	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
 	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Ping(ctx context.Context) error
 	Close()
 }
 
 type DB struct {
-	Pool DBPool
-	log  logger.Logger
+	// Pool - основная база, в неё идут записи и транзакции
+	Pool   DBPool
+	log    logger.Logger
+	tracer *QueryTracer
+	// для соединений вне пула, например у Listener
+	connConfig *pgx.ConnConfig
+
+	replicas []*replica
+	next     atomic.Uint64
+	maxLag   time.Duration
+	stop     context.CancelFunc
+	done     chan struct{}
 }
 
+// Connect создаёт пул и ждёт, пока база станет доступна: при старте в
+// docker-compose postgres часто поднимается позже приложения.
 func Connect(ctx context.Context, cfg config.DBConfig, log logger.Logger) (*DB, error) {
-	poolCfg := pgxpool.Config{
-		ConnConfig: &pgx.ConnConfig{},
-	}
-	// пришлось составлять конфиг так, потому что если я пытаюсь его составить внутри струтктуры ConnConfig{} то пишет что нет ни одного из перечисленных полей
-	poolCfg.MaxConns = int32(cfg.MaxConn)
-	poolCfg.ConnConfig.Host = cfg.Host
-	poolCfg.ConnConfig.Port = uint16(cfg.Port)
-	poolCfg.ConnConfig.User = cfg.User
-	poolCfg.ConnConfig.Password = cfg.Password
-	poolCfg.ConnConfig.Database = cfg.DBName
-
-	pool, err := pgxpool.NewWithConfig(context.Background(), &poolCfg)
+	poolCfg, err := poolConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tracer := NewQueryTracer(cfg.SlowQueryThreshold, log)
+	poolCfg.ConnConfig.Tracer = tracer
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		log.Error(ctx, "cannot create pool with config")
 		return nil, fmt.Errorf("pgxpool.NewWithConfig: %w", err)
 	}
 
-	if err = pool.Ping(context.Background()); err != nil {
+	backoff := Backoff{Initial: cfg.ConnectBackoff, Max: cfg.ConnectMaxBackoff}
+	err = Retry(ctx, cfg.ConnectAttempts, backoff, func(attempt int) error {
+		err := pool.Ping(ctx)
+		if err != nil {
+			log.Warn(ctx, "database is not ready",
+				logger.Field{Key: "attempt", Value: attempt},
+				logger.Field{Key: "error", Value: err})
+		}
+		return err
+	})
+	if err != nil {
+		pool.Close()
 		return nil, fmt.Errorf("ping db: %w", err)
 	}
 
-	return &DB{Pool: pool, log: log}, nil
+	db := &DB{Pool: pool, log: log, tracer: tracer, maxLag: cfg.MaxReplicaLag, connConfig: poolCfg.ConnConfig.Copy()}
+
+	for i, url := range cfg.Replicas {
+		replicaCfg := cfg
+		replicaCfg.URL = url
+
+		rc, err := poolConfig(replicaCfg)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		rc.ConnConfig.Tracer = tracer
+
+		// пул ленивый, недоступная реплика не мешает старту - её просто не будет в ротации
+		rp, err := pgxpool.NewWithConfig(ctx, rc)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("replica %d: pgxpool.NewWithConfig: %w", i+1, err)
+		}
+		db.replicas = append(db.replicas, &replica{name: fmt.Sprintf("replica%d", i+1), pool: rp})
+	}
+
+	if len(db.replicas) > 0 {
+		db.checkReplicas(ctx)
+		db.watchReplicas(cfg.ReplicaCheckPeriod)
+	}
+
+	return db, nil
+}
+
+// WithTx выполняет fn в транзакции: коммит если fn вернула nil, иначе откат.
+func (db *DB) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		// log может быть не задан, если DB собран вручную (например в тестах)
+		if rbErr := tx.Rollback(ctx); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) && db.log != nil {
+			db.log.Error(ctx, "failed to rollback tx", logger.Field{Key: "error", Value: rbErr})
+		}
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) Ping(ctx context.Context) error {
+	return db.Pool.Ping(ctx)
+}
+
+// SchemaVersion - номер последней применённой миграции из таблицы tern.
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	const query = `select version from ` + migrations.VersionTable
+
+	var v int32
+	if err := db.Pool.QueryRow(ctx, query).Scan(&v); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+
+	return int(v), nil
 }
 
 func (db *DB) Close() {
+	if db.stop != nil {
+		db.stop()
+		<-db.done
+	}
+	for _, r := range db.replicas {
+		r.pool.Close()
+	}
 	db.Pool.Close()
 }
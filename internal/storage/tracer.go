@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/skinkvi/money_managment/pkg/redact"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryTracer - pgx.QueryTracer, который логирует запросы, считает их длительность
+// и создаёт на каждый запрос спан OpenTelemetry.
+//
+// Каждый запрос пишется в debug, запросы дольше slow - в warn вместе с текстом и аргументами,
+// ошибки - в error. Аргументы в логах скрыты, кроме чисел, bool и времени: в строках
+// бывают email, хэши паролей и токены.
+type QueryTracer struct {
+	log      logger.Logger
+	slow     time.Duration
+	duration *prometheus.HistogramVec
+}
+
+func NewQueryTracer(slow time.Duration, log logger.Logger) *QueryTracer {
+	return &QueryTracer{
+		log:  log,
+		slow: slow,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "mm",
+			Subsystem: "db",
+			Name:      "query_duration_seconds",
+			Help:      "Длительность SQL запросов по имени запроса.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"query", "status"}),
+	}
+}
+
+// Describe и Collect отдают гистограмму, трейсер регистрируется в prometheus как Collector.
+func (t *QueryTracer) Describe(ch chan<- *prometheus.Desc) {
+	t.duration.Describe(ch)
+}
+
+func (t *QueryTracer) Collect(ch chan<- prometheus.Metric) {
+	t.duration.Collect(ch)
+}
+
+const tracerName = "github.com/skinkvi/money_managment/internal/storage"
+
+type queryNameCtxKey struct{}
+
+// WithQueryName задаёт имя запроса для логов и метрик, например "user.GetByID".
+// Без него имя берётся из комментария "-- name: X" в начале запроса или из "глагол таблица".
+func WithQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameCtxKey{}, name)
+}
+
+type queryTraceCtxKey struct{}
+
+type queryTrace struct {
+	name  string
+	sql   string
+	args  []any
+	start time.Time
+	span  trace.Span
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	name, _ := ctx.Value(queryNameCtxKey{}).(string)
+	if name == "" {
+		name = QueryName(data.SQL)
+	}
+
+	// в спан идёт только текст запроса, аргументы в трейсы не попадают вообще
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBQueryText(compactSQL(data.SQL))))
+
+	return context.WithValue(ctx, queryTraceCtxKey{}, &queryTrace{
+		name:  name,
+		sql:   data.SQL,
+		args:  data.Args,
+		start: time.Now(),
+		span:  span,
+	})
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	q, ok := ctx.Value(queryTraceCtxKey{}).(*queryTrace)
+	if !ok {
+		return
+	}
+
+	elapsed := time.Since(q.start)
+	status := "ok"
+	if data.Err != nil {
+		status = "error"
+		q.span.RecordError(data.Err)
+		q.span.SetStatus(codes.Error, data.Err.Error())
+	}
+	q.span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	defer q.span.End()
+	t.duration.WithLabelValues(q.name, status).Observe(elapsed.Seconds())
+
+	fields := []logger.Field{
+		{Key: "query", Value: q.name},
+		{Key: "duration", Value: elapsed},
+		{Key: "rows", Value: data.CommandTag.RowsAffected()},
+	}
+
+	switch {
+	case data.Err != nil:
+		t.log.Error(ctx, "query failed", append(fields,
+			logger.Field{Key: "error", Value: data.Err},
+			logger.Field{Key: "sql", Value: compactSQL(q.sql)},
+			logger.Field{Key: "args", Value: RedactArgs(q.args)})...)
+	case t.slow > 0 && elapsed >= t.slow:
+		t.log.Warn(ctx, "slow query", append(fields,
+			logger.Field{Key: "threshold", Value: t.slow},
+			logger.Field{Key: "sql", Value: compactSQL(q.sql)},
+			logger.Field{Key: "args", Value: RedactArgs(q.args)})...)
+	default:
+		t.log.Debug(ctx, "query", fields...)
+	}
+}
+
+// QueryName - короткое имя запроса с ограниченным числом вариантов, пригодное для метки метрики.
+func QueryName(sql string) string {
+	sql = strings.TrimSpace(sql)
+
+	if rest, ok := strings.CutPrefix(sql, "-- name:"); ok {
+		name, _, _ := strings.Cut(rest, "\n")
+		return strings.TrimSpace(name)
+	}
+
+	words := strings.Fields(strings.ToLower(sql))
+	if len(words) == 0 {
+		return "unknown"
+	}
+
+	verb := words[0]
+	// таблица идёт после этих слов: insert into t, update t, delete from t, select ... from t
+	after := map[string]string{"insert": "into", "delete": "from", "select": "from", "update": "update"}
+	marker, known := after[verb]
+	if !known {
+		return verb
+	}
+
+	for i, w := range words {
+		if w == marker && i+1 < len(words) && (verb != "update" || i == 0) {
+			return verb + " " + strings.Trim(words[i+1], "(),;")
+		}
+	}
+
+	return verb
+}
+
+// RedactArgs оставляет числа, bool, время и nil, остальное заменяет на redact.Mask.
+func RedactArgs(args []any) []any {
+	out := make([]any, len(args))
+	for i, a := range args {
+		switch a.(type) {
+		case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64,
+			time.Time, time.Duration, *int64, *time.Time:
+			out[i] = a
+		default:
+			out[i] = redact.Mask
+		}
+	}
+
+	return out
+}
+
+func compactSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
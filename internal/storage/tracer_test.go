@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/skinkvi/money_managment/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type entry struct {
+	level  string
+	msg    string
+	fields map[string]any
+}
+
+// recLogger запоминает записи, чтобы проверить, с каким уровнем залогирован запрос
+type recLogger struct {
+	entries *[]entry
+}
+
+func newRecLogger() recLogger { return recLogger{entries: &[]entry{}} }
+
+func (l recLogger) add(level, msg string, fields []logger.Field) {
+	m := make(map[string]any, len(fields))
+	for _, f := range fields {
+		m[f.Key] = f.Value
+	}
+	*l.entries = append(*l.entries, entry{level: level, msg: msg, fields: m})
+}
+
+func (l recLogger) Debug(ctx context.Context, msg string, fields ...logger.Field) {
+	l.add("debug", msg, fields)
+}
+func (l recLogger) Info(ctx context.Context, msg string, fields ...logger.Field) {
+	l.add("info", msg, fields)
+}
+func (l recLogger) Warn(ctx context.Context, msg string, fields ...logger.Field) {
+	l.add("warn", msg, fields)
+}
+func (l recLogger) Error(ctx context.Context, msg string, fields ...logger.Field) {
+	l.add("error", msg, fields)
+}
+func (l recLogger) With(fields ...logger.Field) logger.Logger { return l }
+func (l recLogger) Sync() error                               { return nil }
+
+func TestQueryName(t *testing.T) {
+	cases := map[string]string{
+		"select id, email from users where id = $1":            "select users",
+		"\n\tupdate users\n set role = $2 where id = $1":       "update users",
+		"insert into audit_log (action) values ($1)":           "insert audit_log",
+		"delete\n from sessions where id = $1":                 "delete sessions",
+		"-- name: user.GetByEmail\nselect * from users":        "user.GetByEmail",
+		"with revoked as (update user_tokens set used_at = 1)": "with",
+		"": "unknown",
+	}
+
+	for sql, want := range cases {
+		require.Equal(t, want, QueryName(sql), sql)
+	}
+}
+
+func TestQueryTracer(t *testing.T) {
+	log := newRecLogger()
+	tracer := NewQueryTracer(50*time.Millisecond, log)
+
+	run := func(ctx context.Context, sql string, args []any, sleep time.Duration, err error) {
+		ctx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: sql, Args: args})
+		time.Sleep(sleep)
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("UPDATE 1"), Err: err})
+	}
+
+	run(context.Background(), "update users set email = $1 where id = $2", []any{"dima@example.com", int64(1)}, 0, nil)
+	run(WithQueryName(context.Background(), "user.Slow"), "select pg_sleep(1)", nil, 60*time.Millisecond, nil)
+	run(context.Background(), "select 1 from users", []any{[]byte("hash")}, 0, errors.New("boom"))
+
+	entries := *log.entries
+	require.Len(t, entries, 3)
+
+	require.Equal(t, "debug", entries[0].level)
+	require.Equal(t, "update users", entries[0].fields["query"])
+	require.Equal(t, int64(1), entries[0].fields["rows"])
+	require.NotContains(t, entries[0].fields, "args")
+
+	require.Equal(t, "warn", entries[1].level)
+	require.Equal(t, "user.Slow", entries[1].fields["query"])
+
+	require.Equal(t, "error", entries[2].level)
+	require.Equal(t, []any{"[redacted]"}, entries[2].fields["args"])
+
+	require.Equal(t, 3, testutil.CollectAndCount(tracer))
+}
+
+func TestRedactArgs(t *testing.T) {
+	now := time.Now()
+	require.Equal(t,
+		[]any{int64(1), true, now, nil, "[redacted]", "[redacted]"},
+		RedactArgs([]any{int64(1), true, now, nil, "$2a$10$hash", []byte{1, 2}}))
+}
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/storage"
+)
+
+const AuditEntity = "user"
+
+const (
+	ActionCreate      = "user.create"
+	ActionUpdate      = "user.update"
+	ActionDelete      = "user.delete"
+	ActionVerifyEmail = "user.verify_email"
+	ActionSetRole     = "user.set_role"
+	ActionDisable     = "user.disable"
+	ActionEnable      = "user.enable"
+)
+
+// auditSnapshot - поля пользователя, изменения которых попадают в журнал.
+// version и update_at меняются при любом изменении и ничего не добавляют.
+func auditSnapshot(u *User) map[string]any {
+	if u == nil {
+		return nil
+	}
+
+	return map[string]any{
+		"username":          u.Username,
+		"email":             u.Email,
+		"passhash":          u.PassHash,
+		"email_verified_at": u.EmailVerifiedAt,
+		"role":              u.Role,
+		"disabled_at":       u.DisabledAt,
+	}
+}
+
+// record пишет изменение пользователя в журнал в транзакции q. Если ничего не поменялось, записи нет.
+func (r *pgUserRepository) record(ctx context.Context, q storage.Querier, action string, before, after *User) error {
+	diff := audit.Diff(auditSnapshot(before), auditSnapshot(after), "passhash")
+	if len(diff) == 0 {
+		return nil
+	}
+
+	id := after
+	if id == nil {
+		id = before
+	}
+
+	return r.audit.Record(ctx, q, audit.Entry{
+		Action:     action,
+		EntityType: AuditEntity,
+		EntityID:   strconv.FormatInt(id.ID, 10),
+		Diff:       diff,
+	})
+}
@@ -0,0 +1,178 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+type Handler struct {
+	repo Repository
+	log  logger.Logger
+}
+
+func NewHandler(repo Repository, log logger.Logger) *Handler {
+	return &Handler{repo: repo, log: log}
+}
+
+// Register ожидает, что r уже требует аутентификацию (rbac.Principal в контексте).
+func (h *Handler) Register(r gin.IRouter) {
+	r.GET("/users/:id", rbac.RequireOwnerOr("id", rbac.PermUsersRead), h.get)
+	r.PATCH("/users/:id", rbac.RequireOwnerOr("id", rbac.PermUsersWrite), h.patch)
+}
+
+type Response struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Version   int64     `json:"version"`
+	Role      rbac.Role `json:"role"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewResponse - представление пользователя для API, без хэша пароля.
+func NewResponse(u *User) Response {
+	return Response{
+		ID:        u.ID,
+		Username:  u.Username,
+		Email:     u.Email,
+		Version:   u.Version,
+		Role:      u.Role,
+		Disabled:  u.DisabledAt != nil,
+		CreatedAt: u.CreateAt,
+		UpdatedAt: u.UpdateAt,
+	}
+}
+
+// в профиле пароль не меняется, поэтому passhash тут нет
+type patchRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+}
+
+func (h *Handler) get(c *gin.Context) {
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+
+	u, err := h.repo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	tag := etag(u.Version)
+	c.Header("ETag", tag)
+
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		if v, ok := parseETag(match); ok && v == u.Version {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, NewResponse(u))
+}
+
+func (h *Handler) patch(c *gin.Context) {
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+
+	// без If-Match клиент мог бы затереть чужие изменения, поэтому требуем его всегда
+	match := c.GetHeader("If-Match")
+	if match == "" {
+		c.AbortWithStatusJSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header is required"})
+		return
+	}
+
+	var req patchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var version int64
+	if match == "*" {
+		// версия нужна для записи, реплика может отдать устаревшую
+		u, err := h.repo.GetByID(storage.WithPrimary(ctx), id)
+		if err != nil {
+			h.writeError(c, err)
+			return
+		}
+		version = u.Version
+	} else {
+		v, ok := parseETag(match)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "malformed If-Match header"})
+			return
+		}
+		version = v
+	}
+
+	u, err := h.repo.Patch(ctx, id, version, Patch{Username: req.Username, Email: req.Email})
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Header("ETag", etag(u.Version))
+	c.JSON(http.StatusOK, NewResponse(u))
+}
+
+func (h *Handler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, storage.ErrVersionConflict):
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "user was modified by another request"})
+	case errors.Is(err, storage.ErrUserAlreadyExists):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrEmptyPatch), errors.Is(err, ErrInvalidPatch):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.log.Error(c.Request.Context(), "user handler failed", logger.Field{Key: "error", Value: err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func userID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return 0, false
+	}
+
+	return id, true
+}
+
+func etag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseETag понимает и сильные ("3"), и слабые (W/"3") теги.
+func parseETag(tag string) (int64, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	tag = strings.Trim(tag, `"`)
+
+	v, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return v, true
+}
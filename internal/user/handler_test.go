@@ -0,0 +1,115 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRepo - in-memory Repository для тестов хендлеров
+type fakeRepo struct {
+	Repository
+	user *User
+}
+
+func (f *fakeRepo) GetByID(ctx context.Context, id int64) (*User, error) {
+	if f.user == nil || f.user.ID != id {
+		return nil, storage.ErrUserNotFound
+	}
+	u := *f.user
+	return &u, nil
+}
+
+func (f *fakeRepo) Patch(ctx context.Context, id, version int64, patch Patch) (*User, error) {
+	if f.user == nil || f.user.ID != id {
+		return nil, storage.ErrUserNotFound
+	}
+	if patch.IsEmpty() {
+		return nil, ErrEmptyPatch
+	}
+	if f.user.Version != version {
+		return nil, storage.ErrVersionConflict
+	}
+	if patch.Username != nil {
+		f.user.Username = *patch.Username
+	}
+	if patch.Email != nil {
+		f.user.Email = *patch.Email
+	}
+	f.user.Version++
+	u := *f.user
+	return &u, nil
+}
+
+func newTestRouter(repo Repository) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		rbac.SetPrincipal(c, &rbac.Principal{UserID: 1, Role: rbac.RoleUser})
+	})
+	NewHandler(repo, nopLogger{}).Register(r)
+	return r
+}
+
+func TestHandler_GetETag(t *testing.T) {
+	r := newTestRouter(&fakeRepo{user: &User{ID: 1, Username: "dima", Version: 3}})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `"3"`, rec.Header().Get("ETag"))
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("If-None-Match", `W/"3"`)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotModified, rec.Code)
+
+	// чужой профиль обычному пользователю не виден
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/2", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandler_Patch(t *testing.T) {
+	cases := []struct {
+		name       string
+		ifMatch    string
+		body       string
+		wantStatus int
+		wantETag   string
+	}{
+		{name: "success", ifMatch: `"3"`, body: `{"username":"dmitry"}`, wantStatus: http.StatusOK, wantETag: `"4"`},
+		{name: "wildcard", ifMatch: "*", body: `{"email":"new@example.com"}`, wantStatus: http.StatusOK, wantETag: `"4"`},
+		{name: "missing If-Match", body: `{"username":"dmitry"}`, wantStatus: http.StatusPreconditionRequired},
+		{name: "stale version", ifMatch: `"2"`, body: `{"username":"dmitry"}`, wantStatus: http.StatusPreconditionFailed},
+		{name: "malformed If-Match", ifMatch: "abc", body: `{"username":"dmitry"}`, wantStatus: http.StatusPreconditionFailed},
+		{name: "empty patch", ifMatch: `"3"`, body: `{}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestRouter(&fakeRepo{user: &User{ID: 1, Username: "dima", Version: 3}})
+
+			req := httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.wantStatus, rec.Code)
+			if tc.wantETag != "" {
+				require.Equal(t, tc.wantETag, rec.Header().Get("ETag"))
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package user
+
+import (
+	"context"
+
+	"github.com/skinkvi/money_managment/internal/rbac"
+)
+
+// ErrorObserver считает ошибки операций, реализуется metrics.Repository.
+type ErrorObserver interface {
+	Observe(repo, op string, err error)
+}
+
+type instrumentedRepository struct {
+	next Repository
+	obs  ErrorObserver
+}
+
+// WithMetrics оборачивает repo так, что ошибка каждой операции попадает в obs.
+func WithMetrics(repo Repository, obs ErrorObserver) Repository {
+	return &instrumentedRepository{next: repo, obs: obs}
+}
+
+func (r *instrumentedRepository) observe(op string, err error) {
+	r.obs.Observe("user", op, err)
+}
+
+func (r *instrumentedRepository) Create(ctx context.Context, u *User) (int64, error) {
+	id, err := r.next.Create(ctx, u)
+	r.observe("Create", err)
+	return id, err
+}
+
+func (r *instrumentedRepository) GetByID(ctx context.Context, id int64) (*User, error) {
+	u, err := r.next.GetByID(ctx, id)
+	r.observe("GetByID", err)
+	return u, err
+}
+
+func (r *instrumentedRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	u, err := r.next.GetByEmail(ctx, email)
+	r.observe("GetByEmail", err)
+	return u, err
+}
+
+func (r *instrumentedRepository) Update(ctx context.Context, u *User) (*User, error) {
+	updated, err := r.next.Update(ctx, u)
+	r.observe("Update", err)
+	return updated, err
+}
+
+func (r *instrumentedRepository) Patch(ctx context.Context, id, version int64, patch Patch) (*User, error) {
+	u, err := r.next.Patch(ctx, id, version, patch)
+	r.observe("Patch", err)
+	return u, err
+}
+
+func (r *instrumentedRepository) Delete(ctx context.Context, id int64) error {
+	err := r.next.Delete(ctx, id)
+	r.observe("Delete", err)
+	return err
+}
+
+func (r *instrumentedRepository) MarkEmailVerified(ctx context.Context, id int64) error {
+	err := r.next.MarkEmailVerified(ctx, id)
+	r.observe("MarkEmailVerified", err)
+	return err
+}
+
+func (r *instrumentedRepository) SetRole(ctx context.Context, id int64, role rbac.Role) (*User, error) {
+	u, err := r.next.SetRole(ctx, id, role)
+	r.observe("SetRole", err)
+	return u, err
+}
+
+func (r *instrumentedRepository) SetDisabled(ctx context.Context, id int64, disabled bool) (*User, error) {
+	u, err := r.next.SetDisabled(ctx, id, disabled)
+	r.observe("SetDisabled", err)
+	return u, err
+}
+
+func (r *instrumentedRepository) List(ctx context.Context, limit, offset int) ([]User, error) {
+	users, err := r.next.List(ctx, limit, offset)
+	r.observe("List", err)
+	return users, err
+}
+
+func (r *instrumentedRepository) Count(ctx context.Context) (int64, error) {
+	n, err := r.next.Count(ctx)
+	r.observe("Count", err)
+	return n, err
+}
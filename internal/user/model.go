@@ -1,6 +1,19 @@
 package user
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/rbac"
+)
+
+var (
+	ErrEmptyPatch   = errors.New("nothing to update")
+	ErrInvalidPatch = errors.New("invalid user data")
+)
 
 type User struct {
 	ID       int64
@@ -9,4 +22,47 @@ type User struct {
 	PassHash string
 	CreateAt time.Time
 	UpdateAt time.Time
+	// увеличивается при каждом изменении, нужна для optimistic locking
+	Version int64
+	// nil пока пользователь не подтвердил email
+	EmailVerifiedAt *time.Time
+	Role            rbac.Role
+	// не nil, если администратор заблокировал пользователя
+	DisabledAt *time.Time
+}
+
+// Patch - частичное обновление пользователя, nil поля не меняются.
+type Patch struct {
+	Username *string
+	Email    *string
+	PassHash *string
+}
+
+func (p Patch) IsEmpty() bool {
+	return p.Username == nil && p.Email == nil && p.PassHash == nil
+}
+
+// Validate - те же правила, что при регистрации: непустое имя и адрес почты.
+// Вызывается из Repository.Patch, так что действует для HTTP, gRPC и синхронизации.
+func (p Patch) Validate() error {
+	if p.Username != nil && strings.TrimSpace(*p.Username) == "" {
+		return fmt.Errorf("%w: username must not be empty", ErrInvalidPatch)
+	}
+	if p.Email != nil && !validEmail(*p.Email) {
+		return fmt.Errorf("%w: email must be a valid address", ErrInvalidPatch)
+	}
+	return nil
+}
+
+// NormalizeEmail приводит адрес к виду, в котором он хранится в users.email.
+// Поиск и блокировка входа используют тот же вид, иначе "Dima@Example.com"
+// и "dima@example.com" считались бы разными аккаунтами.
+func NormalizeEmail(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// validEmail принимает только голый адрес, без имени вида "Dima <d@example.com>".
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
 }
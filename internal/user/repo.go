@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/rbac"
 	"github.com/skinkvi/money_managment/internal/storage"
 	"github.com/skinkvi/money_managment/pkg/logger"
 )
@@ -13,8 +16,18 @@ import (
 type Repository interface {
 	Create(ctx context.Context, u *User) (int64, error)
 	GetByID(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	// Update перезаписывает username, email и passhash, если u.Version совпадает с версией в базе,
+	// иначе возвращает storage.ErrVersionConflict.
 	Update(ctx context.Context, u *User) (*User, error)
+	// Patch обновляет только заполненные поля patch, версия проверяется так же как в Update.
+	Patch(ctx context.Context, id, version int64, patch Patch) (*User, error)
 	Delete(ctx context.Context, id int64) error
+	// MarkEmailVerified проставляет email_verified_at, повторный вызов ничего не меняет.
+	MarkEmailVerified(ctx context.Context, id int64) error
+	SetRole(ctx context.Context, id int64, role rbac.Role) (*User, error)
+	// SetDisabled блокирует или разблокирует пользователя, версия при этом не проверяется.
+	SetDisabled(ctx context.Context, id int64, disabled bool) (*User, error)
 
 	// limit - максмальное количество записей
 	// offset - смещение от начала
@@ -23,13 +36,22 @@ type Repository interface {
 	Count(ctx context.Context) (int64, error)
 }
 
+// колонки пользователя в порядке, в котором их сканирует scanUser
+const userColumns = `id, username, email, passhash, create_at, update_at, version, email_verified_at, role, disabled_at`
+
+func scanUser(row pgx.Row, u *User) error {
+	return row.Scan(&u.ID, &u.Username, &u.Email, &u.PassHash, &u.CreateAt, &u.UpdateAt, &u.Version, &u.EmailVerifiedAt, &u.Role, &u.DisabledAt)
+}
+
 type pgUserRepository struct {
-	db  *storage.DB
-	log logger.Logger
+	db    *storage.DB
+	audit audit.Recorder
+	log   logger.Logger
 }
 
-func NewUserRepository(db *storage.DB, log logger.Logger) Repository {
-	return &pgUserRepository{db: db, log: log}
+// NewUserRepository - все изменения пользователей пишутся в журнал rec в той же транзакции.
+func NewUserRepository(db *storage.DB, rec audit.Recorder, log logger.Logger) Repository {
+	return &pgUserRepository{db: db, audit: rec, log: log}
 }
 
 func (r *pgUserRepository) Create(ctx context.Context, u *User) (int64, error) {
@@ -38,11 +60,19 @@ func (r *pgUserRepository) Create(ctx context.Context, u *User) (int64, error) {
 		values
 		($1, $2, $3)
 		on conflict (email) do nothing
-		returning id`
+		returning ` + userColumns
 
-	var id int64
+	u.Email = NormalizeEmail(u.Email)
+
+	var created User
+
+	err := r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := scanUser(tx.QueryRow(ctx, query, u.Username, u.Email, u.PassHash), &created); err != nil {
+			return err
+		}
 
-	err := r.db.Pool.QueryRow(ctx, query, u.Username, u.Email, u.PassHash).Scan(&id)
+		return r.record(ctx, tx, ActionCreate, nil, &created)
+	})
 
 	if errors.Is(err, pgx.ErrNoRows) {
 		return 0, storage.ErrUserAlreadyExists
@@ -53,16 +83,16 @@ func (r *pgUserRepository) Create(ctx context.Context, u *User) (int64, error) {
 		return 0, fmt.Errorf("%w: %s", storage.ErrDB, err)
 	}
 
-	r.log.Info(ctx, "created user with id: ", logger.Field{Key: "user_id", Value: id})
-	return id, nil
+	r.log.Info(ctx, "created user with id: ", logger.Field{Key: "user_id", Value: created.ID})
+	return created.ID, nil
 }
 
 func (r *pgUserRepository) GetByID(ctx context.Context, id int64) (*User, error) {
-	const query = `select id, username, email, passhash, create_at, update_at
+	const query = `select ` + userColumns + `
 				   from users
 				   where id = $1`
 
-	rows, err := r.db.Pool.Query(ctx, query, id)
+	rows, err := r.db.Reader(ctx).Query(ctx, query, id)
 	if err != nil {
 		r.log.Error(ctx, "failed to execute query GetByID",
 			logger.Field{Key: "error", Value: err},
@@ -73,7 +103,7 @@ func (r *pgUserRepository) GetByID(ctx context.Context, id int64) (*User, error)
 
 	var u User
 	if rows.Next() {
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.PassHash, &u.CreateAt, &u.UpdateAt); err != nil {
+		if err := scanUser(rows, &u); err != nil {
 			r.log.Error(ctx, "failed to scan row GetByID",
 				logger.Field{Key: "error", Value: err},
 				logger.Field{Key: "user_id", Value: id})
@@ -87,7 +117,7 @@ func (r *pgUserRepository) GetByID(ctx context.Context, id int64) (*User, error)
 			return nil, fmt.Errorf("rows integration GetByID: %w", err)
 		}
 
-		notFound := fmt.Errorf("user with id %d not found", id)
+		notFound := fmt.Errorf("user with id %d not found: %w", id, storage.ErrUserNotFound)
 		r.log.Info(ctx, "user not found",
 			logger.Field{Key: "user_id", Value: id})
 		return nil, notFound
@@ -96,59 +126,250 @@ func (r *pgUserRepository) GetByID(ctx context.Context, id int64) (*User, error)
 	return &u, nil
 }
 
+func (r *pgUserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	const query = `select ` + userColumns + `
+	from users
+	where email = $1`
+
+	var u User
+
+	err := scanUser(r.db.Pool.QueryRow(ctx, query, NormalizeEmail(email)), &u)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrUserNotFound
+	}
+
+	if err != nil {
+		r.log.Error(ctx, "failed to execute query GetByEmail", logger.Field{Key: "error", Value: err})
+		return nil, fmt.Errorf("failed GetByEmail query: %w", err)
+	}
+
+	return &u, nil
+}
+
 func (r *pgUserRepository) Update(ctx context.Context, u *User) (*User, error) {
-	const query = `update users 
-	set username = $1, email = $2, passhash = $3, update_at = now()
-	where id = $4
-	returning id, username, email, passhash, create_at, update_at`
+	return r.Patch(ctx, u.ID, u.Version, Patch{
+		Username: &u.Username,
+		Email:    &u.Email,
+		PassHash: &u.PassHash,
+	})
+}
+
+func (r *pgUserRepository) Patch(ctx context.Context, id, version int64, patch Patch) (*User, error) {
+	if patch.IsEmpty() {
+		return nil, ErrEmptyPatch
+	}
+	if patch.Email != nil {
+		email := NormalizeEmail(*patch.Email)
+		patch.Email = &email
+	}
+	if err := patch.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		set  []string
+		args []any
+	)
+	add := func(column string, value any) {
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if patch.Username != nil {
+		add("username", *patch.Username)
+	}
+	if patch.Email != nil {
+		add("email", *patch.Email)
+		// новый адрес надо подтверждать заново
+		set = append(set, fmt.Sprintf("email_verified_at = case when users.email = $%d then email_verified_at end", len(args)))
+	}
+	if patch.PassHash != nil {
+		add("passhash", *patch.PassHash)
+	}
+	set = append(set, "version = version + 1", "update_at = now()")
+	args = append(args, id)
+
+	query := fmt.Sprintf(`update users 
+	set %s
+	where id = $%d
+	returning %s`,
+		strings.Join(set, ", "), len(args), userColumns)
 
 	var usr User
 
-	if err := r.db.Pool.QueryRow(ctx, query, u.Username, u.Email, u.PassHash, u.ID).Scan(&usr.ID, &usr.Username, &usr.Email, &usr.PassHash, &usr.CreateAt, &usr.UpdateAt); err != nil {
-		r.log.Error(ctx, "failed to execute query Update",
-			logger.Field{Key: "error", Value: err},
-			logger.Field{Key: "user_id", Value: u.ID})
+	err := r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		before, err := r.lock(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		// строка заблокирована до конца транзакции, так что версия не поменяется между проверкой и update
+		if before.Version != version {
+			r.log.Info(ctx, "user version conflict",
+				logger.Field{Key: "user_id", Value: id},
+				logger.Field{Key: "expected_version", Value: version},
+				logger.Field{Key: "current_version", Value: before.Version})
+			return fmt.Errorf("%w: user %d has version %d, got %d", storage.ErrVersionConflict, id, before.Version, version)
+		}
+
+		if err := scanUser(tx.QueryRow(ctx, query, args...), &usr); err != nil {
+			if storage.IsUniqueViolation(err) {
+				return storage.ErrUserAlreadyExists
+			}
 
-		if errors.Is(err, pgx.ErrNoRows) {
-			r.log.Error(ctx, "user not found", logger.Field{Key: "user_id", Value: u.ID})
-			return nil, fmt.Errorf("user with id %d not found: %w", u.ID, err)
+			r.log.Error(ctx, "failed to execute query Update",
+				logger.Field{Key: "error", Value: err},
+				logger.Field{Key: "user_id", Value: id})
+
+			return fmt.Errorf("failed query Update: %w", err)
 		}
 
-		return nil, fmt.Errorf("failed query Update: %w", err)
+		if usr.Email != before.Email {
+			if err := r.revokeVerification(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+
+		return r.record(ctx, tx, ActionUpdate, before, &usr)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &usr, nil
 }
 
-func (r *pgUserRepository) Delete(ctx context.Context, id int64) error {
-	const query = `delete
+// revokeVerification гасит неиспользованные ссылки подтверждения email: они ушли на
+// старый адрес и не должны подтверждать новый.
+func (r *pgUserRepository) revokeVerification(ctx context.Context, q storage.Querier, id int64) error {
+	const query = `update user_tokens set used_at = now()
+	where user_id = $1 and purpose = 'verify_email' and used_at is null`
+
+	if _, err := q.Exec(ctx, query, id); err != nil {
+		r.log.Error(ctx, "failed to execute query revokeVerification",
+			logger.Field{Key: "error", Value: err},
+			logger.Field{Key: "user_id", Value: id})
+		return fmt.Errorf("failed query revokeVerification: %w", err)
+	}
+
+	return nil
+}
+
+// lock читает пользователя с блокировкой строки до конца транзакции,
+// прочитанное значение - снимок "до" для журнала.
+func (r *pgUserRepository) lock(ctx context.Context, q storage.Querier, id int64) (*User, error) {
+	const query = `select ` + userColumns + `
 	from users
-	where id = $1`
+	where id = $1
+	for update`
+
+	var u User
+
+	err := scanUser(q.QueryRow(ctx, query, id), &u)
+	if errors.Is(err, pgx.ErrNoRows) {
+		r.log.Info(ctx, "user not found", logger.Field{Key: "user_id", Value: id})
+		return nil, fmt.Errorf("user with id %d not found: %w", id, storage.ErrUserNotFound)
+	}
 
-	cmdTag, err := r.db.Pool.Exec(ctx, query, id)
 	if err != nil {
-		r.log.Error(ctx, "failed to execute query Delete",
+		r.log.Error(ctx, "failed to lock user",
 			logger.Field{Key: "error", Value: err},
 			logger.Field{Key: "user_id", Value: id})
+		return nil, fmt.Errorf("failed query lock user: %w", err)
+	}
+
+	return &u, nil
+}
+
+func (r *pgUserRepository) Delete(ctx context.Context, id int64) error {
+	const query = `delete
+	from users
+	where id = $1`
+
+	return r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		before, err := r.lock(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, query, id); err != nil {
+			r.log.Error(ctx, "failed to execute query Delete",
+				logger.Field{Key: "error", Value: err},
+				logger.Field{Key: "user_id", Value: id})
 
-		return fmt.Errorf("failed delete user: %w", err)
+			return fmt.Errorf("failed delete user: %w", err)
+		}
+
+		return r.record(ctx, tx, ActionDelete, before, nil)
+	})
+}
+
+func (r *pgUserRepository) MarkEmailVerified(ctx context.Context, id int64) error {
+	const query = `update users
+	set email_verified_at = coalesce(email_verified_at, now()), version = version + 1, update_at = now()
+	where id = $1
+	returning ` + userColumns
+
+	_, err := r.updateOne(ctx, "MarkEmailVerified", ActionVerifyEmail, query, id)
+	return err
+}
+
+func (r *pgUserRepository) SetRole(ctx context.Context, id int64, role rbac.Role) (*User, error) {
+	const query = `update users
+	set role = $2, version = version + 1, update_at = now()
+	where id = $1
+	returning ` + userColumns
+
+	return r.updateOne(ctx, "SetRole", ActionSetRole, query, id, string(role))
+}
+
+func (r *pgUserRepository) SetDisabled(ctx context.Context, id int64, disabled bool) (*User, error) {
+	const query = `update users
+	set disabled_at = case when $2 then coalesce(disabled_at, now()) end, version = version + 1, update_at = now()
+	where id = $1
+	returning ` + userColumns
+
+	action := ActionEnable
+	if disabled {
+		action = ActionDisable
 	}
 
-	if cmdTag.RowsAffected() == 0 {
-		r.log.Error(ctx, "user not found", logger.Field{Key: "user_id", Value: id})
-		return fmt.Errorf("user with id %d not found", id)
+	return r.updateOne(ctx, "SetDisabled", action, query, id, disabled)
+}
+
+// updateOne выполняет update одного пользователя по id с returning userColumns и пишет изменение в журнал.
+func (r *pgUserRepository) updateOne(ctx context.Context, op, action, query string, id int64, args ...any) (*User, error) {
+	var u User
+
+	err := r.db.WithTx(ctx, func(tx pgx.Tx) error {
+		before, err := r.lock(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := scanUser(tx.QueryRow(ctx, query, append([]any{id}, args...)...), &u); err != nil {
+			r.log.Error(ctx, "failed to execute query "+op,
+				logger.Field{Key: "error", Value: err},
+				logger.Field{Key: "user_id", Value: id})
+			return fmt.Errorf("failed query %s: %w", op, err)
+		}
+
+		return r.record(ctx, tx, action, before, &u)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &u, nil
 }
 
 func (r *pgUserRepository) List(ctx context.Context, limit, offset int) ([]User, error) {
-	const query = `select id, username, email, passhash, create_at, update_at
+	const query = `select ` + userColumns + `
 	from users
 	order by id
 	limit $1 offset $2`
 
-	rows, err := r.db.Pool.Query(ctx, query, limit, offset)
+	rows, err := r.db.Reader(ctx).Query(ctx, query, limit, offset)
 	if err != nil {
 		r.log.Error(ctx, "failed to execute query List",
 			logger.Field{Key: "error", Value: err})
@@ -160,7 +381,7 @@ func (r *pgUserRepository) List(ctx context.Context, limit, offset int) ([]User,
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.PassHash, &u.CreateAt, &u.UpdateAt); err != nil {
+		if err := scanUser(rows, &u); err != nil {
 			r.log.Error(ctx, "failed scan List",
 				logger.Field{Key: "error", Value: err})
 			return nil, fmt.Errorf("failed scan user List: %w", err)
@@ -183,7 +404,7 @@ func (r *pgUserRepository) Count(ctx context.Context) (int64, error) {
 
 	var count int64
 
-	err := r.db.Pool.QueryRow(ctx, query).Scan(&count)
+	err := r.db.Reader(ctx).QueryRow(ctx, query).Scan(&count)
 	if err != nil {
 		r.log.Error(ctx, "failed execute query Count", logger.Field{Key: "error", Value: err})
 		return 0, fmt.Errorf("failed query Count: %w", err)
@@ -10,6 +10,8 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	pgxmock "github.com/pashagolub/pgxmock/v4"
+	"github.com/skinkvi/money_managment/internal/audit"
+	"github.com/skinkvi/money_managment/internal/rbac"
 	"github.com/skinkvi/money_managment/internal/storage"
 	"github.com/skinkvi/money_managment/pkg/logger"
 	"github.com/stretchr/testify/require"
@@ -31,21 +33,39 @@ func (nopLogger) Sync() error { return nil }
 // Вынес в константы все запросы что бы не писать их постоянно + они не изменяемы
 const (
 	insertQuery  = `insert into users`
-	updateQuery  = `update users set username = $1, email = $2, passhash = $3, update_at = now() where id = $4 returning id, username, email, passhash, create_at, update_at`
-	getByIDQuery = `select id, username, email, passhash, create_at, update_at from users`
+	updateQuery  = `update users set username = $1, email = $2, email_verified_at = case when users.email = $2 then email_verified_at end, passhash = $3, version = version + 1, update_at = now() where id = $4 returning id, username, email, passhash, create_at, update_at, version, email_verified_at, role, disabled_at`
+	patchQuery   = `update users set email = $1, email_verified_at = case when users.email = $1 then email_verified_at end, version = version + 1, update_at = now() where id = $2 returning id, username, email, passhash, create_at, update_at, version, email_verified_at, role, disabled_at`
+	lockQuery    = `select id, username, email, passhash, create_at, update_at, version, email_verified_at, role, disabled_at from users where id = $1 for update`
+	getByIDQuery = `select id, username, email, passhash, create_at, update_at, version, email_verified_at, role, disabled_at from users`
+	getByEmail   = `select id, username, email, passhash, create_at, update_at, version, email_verified_at, role, disabled_at from users where email = $1`
 	deleteQuery  = `delete from users where id = $1`
-	listQuery    = `select id, username, email, passhash, create_at, update_at from users order by id limit $1 offset $2`
+	listQuery    = `select id, username, email, passhash, create_at, update_at, version, email_verified_at, role, disabled_at from users order by id limit $1 offset $2`
 	countQuery   = `select count(id) from users`
 )
 
-func newTestRepo(t *testing.T) (Repository, pgxmock.PgxPoolIface) {
+var userColumnNames = []string{
+	"id", "username", "email", "passhash", "create_at", "update_at", "version", "email_verified_at", "role", "disabled_at",
+}
+
+// memRecorder запоминает записи журнала вместо insert в audit_log
+type memRecorder struct {
+	entries []audit.Entry
+}
+
+func (m *memRecorder) Record(ctx context.Context, q storage.Querier, e audit.Entry) error {
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+func newTestRepo(t *testing.T) (Repository, pgxmock.PgxPoolIface, *memRecorder) {
 	mockPool, err := pgxmock.NewPool()
 	require.NoError(t, err)
 	t.Cleanup(func() {
 		mockPool.Close()
 	})
 	db := &storage.DB{Pool: mockPool}
-	return NewUserRepository(db, nopLogger{}), mockPool
+	rec := &memRecorder{}
+	return NewUserRepository(db, rec, nopLogger{}), mockPool, rec
 }
 
 var (
@@ -66,12 +86,10 @@ func TestUserRepository_GetByID(t *testing.T) {
 			mockSetup: func(p pgxmock.PgxPoolIface) {
 				p.ExpectQuery(regexp.QuoteMeta(getByIDQuery)).
 					WithArgs(int64(42)).
-					WillReturnRows(pgxmock.NewRows([]string{
-						"id", "username", "email", "passhash", "create_at", "update_at",
-					}).AddRow(42, "dima", "dima@example.com", "hash", fixedTime, fixedTime))
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(42, "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(1), nil, "user", nil))
 			},
 			wantUser: &User{ID: 42, Username: "dima", Email: "dima@example.com",
-				PassHash: "hash", CreateAt: fixedTime, UpdateAt: fixedTime},
+				PassHash: "hash", CreateAt: fixedTime, UpdateAt: fixedTime, Version: 1, Role: rbac.RoleUser},
 		},
 		{
 			name: "not found",
@@ -97,7 +115,7 @@ func TestUserRepository_GetByID(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			repo, mock := newTestRepo(t)
+			repo, mock, _ := newTestRepo(t)
 			tc.mockSetup(mock)
 
 			got, err := repo.GetByID(context.Background(), 42)
@@ -129,20 +147,24 @@ func TestUserRepository_Create(t *testing.T) {
 		{
 			name: "success",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectBegin()
 				ppi.ExpectQuery(regexp.QuoteMeta(
 					insertQuery)).
 					WithArgs("dima", "dima@example.com", "hash").
-					WillReturnRows(pgxmock.NewRows(
-						[]string{"id"}).AddRow(int64(42)))
+					WillReturnRows(pgxmock.NewRows(userColumnNames).
+						AddRow(int64(42), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(1), nil, "user", nil))
+				ppi.ExpectCommit()
 			},
 			wantID: 42,
 		},
 		{
 			name: "already exists",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectBegin()
 				ppi.ExpectQuery(regexp.QuoteMeta(insertQuery)).
 					WithArgs("dima", "dima@example.com", "hash").
 					WillReturnError(pgx.ErrNoRows)
+				ppi.ExpectRollback()
 			},
 			wantErr:   storage.ErrUserAlreadyExists.Error(),
 			wantErrIs: storage.ErrUserAlreadyExists,
@@ -151,9 +173,11 @@ func TestUserRepository_Create(t *testing.T) {
 		{
 			name: "database error",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectBegin()
 				ppi.ExpectQuery(regexp.QuoteMeta(insertQuery)).
 					WithArgs("dima", "dima@example.com", "hash").
 					WillReturnError(errors.New("failed to create user"))
+				ppi.ExpectRollback()
 			},
 			wantErr:   "failed to create user",
 			wantErrIs: storage.ErrDB,
@@ -165,17 +189,18 @@ func TestUserRepository_Create(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			repo, mock := newTestRepo(t)
+			repo, mock, rec := newTestRepo(t)
 			tc.mockSetup(mock)
 
 			gotID, err := repo.Create(context.Background(), &User{
 				Username: "dima",
-				Email:    "dima@example.com",
+				Email:    " Dima@Example.com",
 				PassHash: "hash",
 			})
 
 			if tc.wantErr != "" {
 				require.ErrorContains(t, err, tc.wantErr)
+				require.Empty(t, rec.entries)
 				return
 			}
 			if tc.wantErrIs != nil {
@@ -186,6 +211,12 @@ func TestUserRepository_Create(t *testing.T) {
 			require.NoError(t, err)
 			require.Equal(t, tc.wantID, gotID)
 			require.NoError(t, mock.ExpectationsWereMet())
+
+			require.Len(t, rec.entries, 1)
+			require.Equal(t, ActionCreate, rec.entries[0].Action)
+			require.Equal(t, "42", rec.entries[0].EntityID)
+			require.Equal(t, audit.Change{Before: nil, After: "dima"}, rec.entries[0].Diff["username"])
+			require.Equal(t, audit.Change{Before: nil, After: "[redacted]"}, rec.entries[0].Diff["passhash"])
 		})
 	}
 }
@@ -202,27 +233,14 @@ func TestUserRepository_Update(t *testing.T) {
 		{
 			name: "success",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
-				u := &User{
-					ID:       1,
-					Username: "dima",
-					Email:    "dima@example.com",
-					PassHash: "hash",
-					CreateAt: fixedTime,
-					UpdateAt: fixedTime,
-				}
-
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dmitry", "dima@example.com", "hash", fixedTime, fixedTime, int64(3), nil, "user", nil))
 				ppi.ExpectQuery(regexp.QuoteMeta(updateQuery)).
-					WithArgs(u.Username, u.Email, u.PassHash, u.ID).
-					WillReturnRows(pgxmock.NewRows([]string{
-						"id", "username", "email", "passhash", "create_at", "update_at",
-					}).AddRow(
-						u.ID,
-						u.Username,
-						u.Email,
-						u.PassHash,
-						u.CreateAt,
-						u.UpdateAt,
-					))
+					WithArgs("dima", "dima@example.com", "hash", int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(4), nil, "user", nil))
+				ppi.ExpectCommit()
 			},
 			wantUser: &User{
 				ID:       1,
@@ -231,53 +249,61 @@ func TestUserRepository_Update(t *testing.T) {
 				PassHash: "hash",
 				CreateAt: fixedTime,
 				UpdateAt: fixedTime,
+				Version:  4,
+				Role:     rbac.RoleUser,
 			},
 		},
 		{
 			name: "error not found",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
-				u := &User{
-					ID:       1,
-					Username: "dima",
-					Email:    "dima@example.com",
-					PassHash: "hash",
-				}
-
-				ppi.ExpectQuery(regexp.QuoteMeta(updateQuery)).
-					WithArgs(u.Username, u.Email, u.PassHash, u.ID).
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
 					WillReturnError(pgx.ErrNoRows)
+				ppi.ExpectRollback()
 			},
 			wantErr:   "user with id 1 not found",
-			wantErrIs: pgx.ErrNoRows,
+			wantErrIs: storage.ErrUserNotFound,
+		},
+		{
+			name: "version conflict",
+			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(5), nil, "user", nil))
+				ppi.ExpectRollback()
+			},
+			wantErr:   "user 1 has version 5, got 3",
+			wantErrIs: storage.ErrVersionConflict,
 		},
 		{
 			name: "error db",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
-				u := &User{
-					ID:       1,
-					Username: "dima",
-					Email:    "dima@example.com",
-					PassHash: "hash",
-				}
-
-				origErr := errors.New("some db error")
-
-				ppi.ExpectQuery(regexp.QuoteMeta(insertQuery)).
-					WithArgs(u.Username, u.Email, u.PassHash, u.ID).
-					WillReturnError(origErr)
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(3), nil, "user", nil))
+				ppi.ExpectQuery(regexp.QuoteMeta(updateQuery)).
+					WithArgs("dima", "dima@example.com", "hash", int64(1)).
+					WillReturnError(errors.New("some db error"))
+				ppi.ExpectRollback()
 			},
-			wantErr: "failed query Update:",
+			wantErr: "failed query Update: some db error",
 		},
 		{
 			name: "scan error",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
-				ppi.ExpectQuery(regexp.QuoteMeta(insertQuery)).
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(3), nil, "user", nil))
+				ppi.ExpectQuery(regexp.QuoteMeta(updateQuery)).
 					WithArgs("dima", "dima@example.com", "hash", int64(1)).
-					WillReturnRows(pgxmock.NewRows([]string{
-						"id", "username", "email", "passhash", "create_at", "update_at",
-					}).AddRow(
-						int64(1), "dima", "dima@example.com", "hash", "invalid-time", fixedTime,
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(
+						int64(1), "dima", "dima@example.com", "hash", "invalid-time", fixedTime, int64(4), nil, "user", nil,
 					))
+				ppi.ExpectRollback()
 			},
 			wantErr: "failed query Update:",
 		},
@@ -287,7 +313,7 @@ func TestUserRepository_Update(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			repo, mock := newTestRepo(t)
+			repo, mock, rec := newTestRepo(t)
 			tc.mockSetup(mock)
 
 			got, err := repo.Update(context.Background(), &User{
@@ -295,15 +321,148 @@ func TestUserRepository_Update(t *testing.T) {
 				Username: "dima",
 				Email:    "dima@example.com",
 				PassHash: "hash",
+				Version:  3,
+				Role:     rbac.RoleUser,
 			})
 
+			if tc.wantErrIs != nil {
+				require.ErrorIs(t, err, tc.wantErrIs)
+			}
+
 			if tc.wantErr != "" {
 				require.ErrorContains(t, err, tc.wantErr)
+				require.Empty(t, rec.entries)
+				require.NoError(t, mock.ExpectationsWereMet())
 				return
 			}
 
+			require.NoError(t, err)
+			require.Equal(t, tc.wantUser, got)
+			require.NoError(t, mock.ExpectationsWereMet())
+
+			// в журнале только реально изменившиеся поля
+			require.Len(t, rec.entries, 1)
+			require.Equal(t, ActionUpdate, rec.entries[0].Action)
+			require.Equal(t, map[string]audit.Change{
+				"username": {Before: "dmitry", After: "dima"},
+			}, rec.entries[0].Diff)
+		})
+	}
+}
+
+func TestUserRepository_Patch(t *testing.T) {
+	t.Parallel()
+	email := "new@example.com"
+	mixedEmail := " New@Example.com "
+	blank, badEmail := " ", "not-an-email"
+
+	cases := []struct {
+		name      string
+		patch     Patch
+		mockSetup func(pgxmock.PgxPoolIface)
+		wantUser  *User
+		wantErrIs error
+	}{
+		{
+			name:  "only changed fields",
+			patch: Patch{Email: &email},
+			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(2), nil, "user", nil))
+				ppi.ExpectQuery(regexp.QuoteMeta(patchQuery)).
+					WithArgs(email, int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", email, "hash", fixedTime, fixedTime, int64(3), nil, "user", nil))
+				// ссылки подтверждения старого адреса больше не действуют
+				ppi.ExpectExec(regexp.QuoteMeta(`update user_tokens set used_at = now()`)).
+					WithArgs(int64(1)).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				ppi.ExpectCommit()
+			},
+			wantUser: &User{
+				ID:       1,
+				Username: "dima",
+				Email:    email,
+				PassHash: "hash",
+				CreateAt: fixedTime,
+				UpdateAt: fixedTime,
+				Version:  3,
+				Role:     rbac.RoleUser,
+			},
+		},
+		{
+			name:  "email is normalized",
+			patch: Patch{Email: &mixedEmail},
+			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(2), nil, "user", nil))
+				ppi.ExpectQuery(regexp.QuoteMeta(patchQuery)).
+					WithArgs(email, int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", email, "hash", fixedTime, fixedTime, int64(3), nil, "user", nil))
+				// ссылки подтверждения старого адреса больше не действуют
+				ppi.ExpectExec(regexp.QuoteMeta(`update user_tokens set used_at = now()`)).
+					WithArgs(int64(1)).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				ppi.ExpectCommit()
+			},
+			wantUser: &User{
+				ID:       1,
+				Username: "dima",
+				Email:    email,
+				PassHash: "hash",
+				CreateAt: fixedTime,
+				UpdateAt: fixedTime,
+				Version:  3,
+				Role:     rbac.RoleUser,
+			},
+		},
+		{
+			name:      "empty patch",
+			patch:     Patch{},
+			mockSetup: func(ppi pgxmock.PgxPoolIface) {},
+			wantErrIs: ErrEmptyPatch,
+		},
+		{
+			name:      "blank username",
+			patch:     Patch{Username: &blank},
+			mockSetup: func(ppi pgxmock.PgxPoolIface) {},
+			wantErrIs: ErrInvalidPatch,
+		},
+		{
+			name:      "invalid email",
+			patch:     Patch{Email: &badEmail},
+			mockSetup: func(ppi pgxmock.PgxPoolIface) {},
+			wantErrIs: ErrInvalidPatch,
+		},
+		{
+			name:  "version conflict",
+			patch: Patch{Email: &email},
+			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(7), nil, "user", nil))
+				ppi.ExpectRollback()
+			},
+			wantErrIs: storage.ErrVersionConflict,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			repo, mock, _ := newTestRepo(t)
+			tc.mockSetup(mock)
+
+			got, err := repo.Patch(context.Background(), 1, 2, tc.patch)
+
 			if tc.wantErrIs != nil {
 				require.ErrorIs(t, err, tc.wantErrIs)
+				return
 			}
 
 			require.NoError(t, err)
@@ -324,18 +483,28 @@ func TestUserRepository_Delete(t *testing.T) {
 		{
 			name: "success",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(1), nil, "user", nil))
 				ppi.ExpectExec(regexp.QuoteMeta(deleteQuery)).
 					WithArgs(int64(1)).
 					WillReturnResult(pgconn.NewCommandTag("DELETE 1"))
+				ppi.ExpectCommit()
 			},
 			inputID: 1,
 		},
 		{
 			name: "driver error",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
+					WithArgs(int64(1)).
+					WillReturnRows(pgxmock.NewRows(userColumnNames).AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(1), nil, "user", nil))
 				ppi.ExpectExec(regexp.QuoteMeta(deleteQuery)).
 					WithArgs(int64(1)).
 					WillReturnError(errors.New("connection closed"))
+				ppi.ExpectRollback()
 			},
 
 			inputID: 1,
@@ -344,13 +513,16 @@ func TestUserRepository_Delete(t *testing.T) {
 		{
 			name: "user not found",
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
-				ppi.ExpectExec(regexp.QuoteMeta(deleteQuery)).
+				ppi.ExpectBegin()
+				ppi.ExpectQuery(regexp.QuoteMeta(lockQuery)).
 					WithArgs(int64(1)).
-					WillReturnResult(pgconn.NewCommandTag("DELETE 0"))
+					WillReturnError(pgx.ErrNoRows)
+				ppi.ExpectRollback()
 			},
 
-			inputID: 1,
-			wantErr: "user with id 1 not found",
+			inputID:   1,
+			wantErr:   "user with id 1 not found",
+			wantErrIs: storage.ErrUserNotFound,
 		},
 	}
 
@@ -358,16 +530,24 @@ func TestUserRepository_Delete(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			repo, mock := newTestRepo(t)
+			repo, mock, rec := newTestRepo(t)
 			tc.mockSetup(mock)
 
 			err := repo.Delete(context.Background(), tc.inputID)
 
 			if tc.wantErr == "" {
 				require.NoError(t, err)
+				require.Len(t, rec.entries, 1)
+				require.Equal(t, ActionDelete, rec.entries[0].Action)
+				require.Equal(t, audit.Change{Before: "dima@example.com", After: nil}, rec.entries[0].Diff["email"])
 			} else {
 				require.Error(t, err)
 				require.ErrorContains(t, err, tc.wantErr)
+				require.Empty(t, rec.entries)
+			}
+
+			if tc.wantErrIs != nil {
+				require.ErrorIs(t, err, tc.wantErrIs)
 			}
 
 			require.NoError(t, mock.ExpectationsWereMet())
@@ -392,15 +572,13 @@ func TestUserRepository_List(t *testing.T) {
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
 				ppi.ExpectQuery(regexp.QuoteMeta(listQuery)).
 					WithArgs(2, 0).
-					WillReturnRows(pgxmock.NewRows([]string{
-						"id", "username", "email", "passhash", "create_at", "update_at",
-					}).
-						AddRow(int64(1), "user1", "user1@example.com", "hash1", fixedTime, fixedTime).
-						AddRow(int64(2), "user2", "user2@example.com", "hash2", fixedTime, fixedTime))
+					WillReturnRows(pgxmock.NewRows(userColumnNames).
+						AddRow(int64(1), "user1", "user1@example.com", "hash1", fixedTime, fixedTime, int64(1), nil, "user", nil).
+						AddRow(int64(2), "user2", "user2@example.com", "hash2", fixedTime, fixedTime, int64(1), nil, "user", nil))
 			},
 			wantUsers: []User{
-				{ID: 1, Username: "user1", Email: "user1@example.com", PassHash: "hash1", CreateAt: fixedTime, UpdateAt: fixedTime},
-				{ID: 2, Username: "user2", Email: "user2@example.com", PassHash: "hash2", CreateAt: fixedTime, UpdateAt: fixedTime},
+				{ID: 1, Username: "user1", Email: "user1@example.com", PassHash: "hash1", CreateAt: fixedTime, UpdateAt: fixedTime, Version: 1, Role: rbac.RoleUser},
+				{ID: 2, Username: "user2", Email: "user2@example.com", PassHash: "hash2", CreateAt: fixedTime, UpdateAt: fixedTime, Version: 1, Role: rbac.RoleUser},
 			},
 		},
 		{
@@ -410,9 +588,7 @@ func TestUserRepository_List(t *testing.T) {
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
 				ppi.ExpectQuery(regexp.QuoteMeta(listQuery)).
 					WithArgs(2, 0).
-					WillReturnRows(pgxmock.NewRows([]string{
-						"id", "username", "email", "passhash", "create_at", "update_at",
-					}))
+					WillReturnRows(pgxmock.NewRows(userColumnNames))
 			},
 			wantUsers: nil,
 		},
@@ -434,10 +610,8 @@ func TestUserRepository_List(t *testing.T) {
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
 				ppi.ExpectQuery(regexp.QuoteMeta(listQuery)).
 					WithArgs(1, 0).
-					WillReturnRows(pgxmock.NewRows([]string{
-						"id", "username", "email", "passhash", "create_at", "update_at",
-					}).
-						AddRow(int64(2), "user2", "user2@example.com", "hash2", "fake time for force error", fixedTime))
+					WillReturnRows(pgxmock.NewRows(userColumnNames).
+						AddRow(int64(2), "user2", "user2@example.com", "hash2", "fake time for force error", fixedTime, int64(1), nil, "user", nil))
 			},
 			wantErr: "failed scan user List:",
 		},
@@ -446,9 +620,7 @@ func TestUserRepository_List(t *testing.T) {
 			limit:  1,
 			offset: 0,
 			mockSetup: func(ppi pgxmock.PgxPoolIface) {
-				rows := pgxmock.NewRows([]string{
-					"id", "username", "email", "passhash", "create_at", "update_at",
-				}).AddRow(int64(1), "user1", "user1@example.com", "hash1", fixedTime, fixedTime)
+				rows := pgxmock.NewRows(userColumnNames).AddRow(int64(1), "user1", "user1@example.com", "hash1", fixedTime, fixedTime, int64(1), nil, "user", nil)
 				rows.RowError(0, errors.New("iteration error"))
 				ppi.ExpectQuery(regexp.QuoteMeta(listQuery)).
 					WithArgs(1, 0).
@@ -462,7 +634,7 @@ func TestUserRepository_List(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			repo, mock := newTestRepo(t)
+			repo, mock, _ := newTestRepo(t)
 			tc.mockSetup(mock)
 
 			got, err := repo.List(context.Background(), tc.limit, tc.offset)
@@ -533,7 +705,7 @@ func TestUserRepository_Count(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			repo, mock := newTestRepo(t)
+			repo, mock, _ := newTestRepo(t)
 			tc.mockSetup(mock)
 
 			got, err := repo.Count(context.Background())
@@ -554,3 +726,70 @@ func TestUserRepository_Count(t *testing.T) {
 		})
 	}
 }
+
+func TestUserRepository_GetByEmail(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name      string
+		mockSetup func(pgxmock.PgxPoolIface)
+		wantUser  *User
+		wantErrIs error
+	}{
+		{
+			name: "success",
+			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectQuery(regexp.QuoteMeta(getByEmail)).
+					WithArgs("dima@example.com").
+					WillReturnRows(pgxmock.NewRows(userColumnNames).
+						AddRow(int64(1), "dima", "dima@example.com", "hash", fixedTime, fixedTime, int64(2), &fixedTime, "user", nil))
+			},
+			wantUser: &User{ID: 1, Username: "dima", Email: "dima@example.com", PassHash: "hash",
+				CreateAt: fixedTime, UpdateAt: fixedTime, Version: 2, EmailVerifiedAt: &fixedTime, Role: rbac.RoleUser},
+		},
+		{
+			name: "not found",
+			mockSetup: func(ppi pgxmock.PgxPoolIface) {
+				ppi.ExpectQuery(regexp.QuoteMeta(getByEmail)).
+					WithArgs("dima@example.com").
+					WillReturnError(pgx.ErrNoRows)
+			},
+			wantErrIs: storage.ErrUserNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			repo, mock, _ := newTestRepo(t)
+			tc.mockSetup(mock)
+
+			got, err := repo.GetByEmail(context.Background(), "Dima@Example.com ")
+
+			if tc.wantErrIs != nil {
+				require.ErrorIs(t, err, tc.wantErrIs)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.wantUser, got)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPatch_Validate(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	require.NoError(t, Patch{Username: str("dima"), Email: str("dima@example.com")}.Validate())
+	require.NoError(t, Patch{PassHash: str("hash")}.Validate())
+
+	for _, p := range []Patch{
+		{Username: str("")},
+		{Email: str("")},
+		{Email: str("not-an-email")},
+		{Email: str("Dima <dima@example.com>")},
+	} {
+		require.ErrorIs(t, p.Validate(), ErrInvalidPatch)
+	}
+}
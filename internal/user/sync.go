@@ -0,0 +1,111 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/skinkvi/money_managment/internal/delta"
+	"github.com/skinkvi/money_managment/internal/storage"
+)
+
+// SyncType - тип профиля в sync_log, его пишет триггер users_sync_touch.
+const SyncType = "user"
+
+// SyncSource отдаёт клиенту его профиль для синхронизации. Создавать и удалять
+// профиль через синхронизацию нельзя, меняются только username и email.
+type SyncSource struct {
+	repo Repository
+}
+
+func NewSyncSource(repo Repository) *SyncSource {
+	return &SyncSource{repo: repo}
+}
+
+func (s *SyncSource) Get(ctx context.Context, userID int64, ids []string) (map[string]delta.Entity, error) {
+	out := make(map[string]delta.Entity, 1)
+	for _, id := range ids {
+		if id != strconv.FormatInt(userID, 10) {
+			continue
+		}
+
+		u, err := s.repo.GetByID(ctx, userID)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := toEntity(u)
+		if err != nil {
+			return nil, err
+		}
+		out[id] = e
+	}
+
+	return out, nil
+}
+
+func (s *SyncSource) Create(context.Context, int64, string, delta.Entity) (delta.Entity, error) {
+	return nil, delta.ErrNotSupported
+}
+
+func (s *SyncSource) Delete(context.Context, int64, string) error {
+	return delta.ErrNotSupported
+}
+
+func (s *SyncSource) Update(ctx context.Context, userID int64, id string, fields delta.Entity) (delta.Entity, error) {
+	if id != strconv.FormatInt(userID, 10) {
+		return nil, delta.ErrNotFound
+	}
+
+	var patch Patch
+	for field, value := range fields {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s must be a string", delta.ErrInvalidField, field)
+		}
+
+		switch field {
+		case "username":
+			patch.Username = &str
+		case "email":
+			patch.Email = &str
+		default:
+			return nil, fmt.Errorf("%w: %s is read-only", delta.ErrInvalidField, field)
+		}
+	}
+
+	u, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err = s.repo.Patch(ctx, userID, u.Version, patch)
+	if errors.Is(err, storage.ErrUserAlreadyExists) || errors.Is(err, ErrInvalidPatch) {
+		return nil, fmt.Errorf("%w: %w", delta.ErrInvalidField, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return toEntity(u)
+}
+
+// профиль в синхронизации выглядит так же, как в GET /users/:id
+func toEntity(u *User) (delta.Entity, error) {
+	raw, err := json.Marshal(NewResponse(u))
+	if err != nil {
+		return nil, err
+	}
+
+	var e delta.Entity
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
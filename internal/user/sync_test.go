@@ -0,0 +1,38 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	"github.com/skinkvi/money_managment/internal/delta"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncSource(t *testing.T) {
+	repo := &fakeRepo{user: &User{ID: 1, Username: "dima", Email: "dima@example.com", Version: 2}}
+	src := NewSyncSource(repo)
+	ctx := context.Background()
+
+	// чужой профиль не виден, как будто его нет
+	got, err := src.Get(ctx, 1, []string{"1", "2"})
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, "dima", got["1"]["username"])
+	require.EqualValues(t, 2, got["1"]["version"])
+
+	e, err := src.Update(ctx, 1, "1", delta.Entity{"username": "dmitry"})
+	require.NoError(t, err)
+	require.Equal(t, "dmitry", e["username"])
+	require.EqualValues(t, 3, e["version"])
+
+	_, err = src.Update(ctx, 1, "1", delta.Entity{"role": "admin"})
+	require.ErrorIs(t, err, delta.ErrInvalidField)
+
+	_, err = src.Update(ctx, 1, "1", delta.Entity{"email": 5})
+	require.ErrorIs(t, err, delta.ErrInvalidField)
+
+	_, err = src.Update(ctx, 1, "2", delta.Entity{"username": "x"})
+	require.ErrorIs(t, err, delta.ErrNotFound)
+
+	require.ErrorIs(t, src.Delete(ctx, 1, "1"), delta.ErrNotSupported)
+}
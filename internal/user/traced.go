@@ -0,0 +1,122 @@
+package user
+
+import (
+	"context"
+	"errors"
+
+	"github.com/skinkvi/money_managment/internal/rbac"
+	"github.com/skinkvi/money_managment/internal/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/skinkvi/money_managment/internal/user"
+
+type tracedRepository struct {
+	next   Repository
+	tracer trace.Tracer
+}
+
+// WithTracing оборачивает repo так, что каждая операция - отдельный спан,
+// а запросы pgx внутри неё становятся его дочерними спанами.
+func WithTracing(repo Repository) Repository {
+	return &tracedRepository{next: repo, tracer: otel.Tracer(tracerName)}
+}
+
+func (r *tracedRepository) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return r.tracer.Start(ctx, "user.Repository/"+op, trace.WithAttributes(attrs...))
+}
+
+// end помечает спан ошибкой, кроме ожидаемых: "не найден" - обычный ответ, а не сбой.
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func userIDAttr(id int64) attribute.KeyValue {
+	return attribute.Int64("user.id", id)
+}
+
+func (r *tracedRepository) Create(ctx context.Context, u *User) (int64, error) {
+	ctx, span := r.start(ctx, "Create")
+	id, err := r.next.Create(ctx, u)
+	span.SetAttributes(userIDAttr(id))
+	end(span, err)
+	return id, err
+}
+
+func (r *tracedRepository) GetByID(ctx context.Context, id int64) (*User, error) {
+	ctx, span := r.start(ctx, "GetByID", userIDAttr(id))
+	u, err := r.next.GetByID(ctx, id)
+	end(span, err)
+	return u, err
+}
+
+func (r *tracedRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	// email в атрибуты не пишем - это персональные данные
+	ctx, span := r.start(ctx, "GetByEmail")
+	u, err := r.next.GetByEmail(ctx, email)
+	end(span, err)
+	return u, err
+}
+
+func (r *tracedRepository) Update(ctx context.Context, u *User) (*User, error) {
+	ctx, span := r.start(ctx, "Update", userIDAttr(u.ID))
+	updated, err := r.next.Update(ctx, u)
+	end(span, err)
+	return updated, err
+}
+
+func (r *tracedRepository) Patch(ctx context.Context, id, version int64, patch Patch) (*User, error) {
+	ctx, span := r.start(ctx, "Patch", userIDAttr(id), attribute.Int64("user.version", version))
+	u, err := r.next.Patch(ctx, id, version, patch)
+	end(span, err)
+	return u, err
+}
+
+func (r *tracedRepository) Delete(ctx context.Context, id int64) error {
+	ctx, span := r.start(ctx, "Delete", userIDAttr(id))
+	err := r.next.Delete(ctx, id)
+	end(span, err)
+	return err
+}
+
+func (r *tracedRepository) MarkEmailVerified(ctx context.Context, id int64) error {
+	ctx, span := r.start(ctx, "MarkEmailVerified", userIDAttr(id))
+	err := r.next.MarkEmailVerified(ctx, id)
+	end(span, err)
+	return err
+}
+
+func (r *tracedRepository) SetRole(ctx context.Context, id int64, role rbac.Role) (*User, error) {
+	ctx, span := r.start(ctx, "SetRole", userIDAttr(id), attribute.String("user.role", string(role)))
+	u, err := r.next.SetRole(ctx, id, role)
+	end(span, err)
+	return u, err
+}
+
+func (r *tracedRepository) SetDisabled(ctx context.Context, id int64, disabled bool) (*User, error) {
+	ctx, span := r.start(ctx, "SetDisabled", userIDAttr(id), attribute.Bool("user.disabled", disabled))
+	u, err := r.next.SetDisabled(ctx, id, disabled)
+	end(span, err)
+	return u, err
+}
+
+func (r *tracedRepository) List(ctx context.Context, limit, offset int) ([]User, error) {
+	ctx, span := r.start(ctx, "List", attribute.Int("limit", limit), attribute.Int("offset", offset))
+	users, err := r.next.List(ctx, limit, offset)
+	end(span, err)
+	return users, err
+}
+
+func (r *tracedRepository) Count(ctx context.Context) (int64, error) {
+	ctx, span := r.start(ctx, "Count")
+	n, err := r.next.Count(ctx)
+	end(span, err)
+	return n, err
+}
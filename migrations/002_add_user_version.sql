@@ -0,0 +1,6 @@
+-- Write your migrate up statements here
+alter table users add column if not exists version bigint not null default 1;
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+alter table users drop column if exists version;
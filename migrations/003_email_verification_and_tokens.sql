@@ -0,0 +1,20 @@
+-- Write your migrate up statements here
+alter table users add column if not exists email_verified_at timestamptz;
+
+-- одноразовые токены подтверждения email и сброса пароля, храним только sha256 от токена
+create table if not exists user_tokens (
+    id bigserial primary key,
+    user_id bigint not null references users(id) on delete cascade,
+    purpose text not null,
+    token_hash bytea not null unique,
+    expires_at timestamptz not null,
+    used_at timestamptz,
+    create_at timestamptz not null default now()
+);
+
+create index if not exists user_tokens_user_purpose_idx on user_tokens (user_id, purpose) where used_at is null;
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+drop table if exists user_tokens;
+alter table users drop column if exists email_verified_at;
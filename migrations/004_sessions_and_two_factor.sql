@@ -0,0 +1,35 @@
+-- Write your migrate up statements here
+create table if not exists sessions (
+    id bigserial primary key,
+    user_id bigint not null references users(id) on delete cascade,
+    token_hash bytea not null unique,
+    expires_at timestamptz not null,
+    revoked_at timestamptz,
+    create_at timestamptz not null default now()
+);
+
+create index if not exists sessions_user_idx on sessions (user_id) where revoked_at is null;
+
+-- secret_enc зашифрован ключом auth.totpEncryptionKey, enabled_at is null пока пользователь не подтвердил код
+create table if not exists user_totp (
+    user_id bigint primary key references users(id) on delete cascade,
+    secret_enc bytea not null,
+    enabled_at timestamptz,
+    last_used_step bigint,
+    create_at timestamptz not null default now()
+);
+
+create table if not exists user_recovery_codes (
+    id bigserial primary key,
+    user_id bigint not null references users(id) on delete cascade,
+    code_hash bytea not null,
+    used_at timestamptz,
+    create_at timestamptz not null default now(),
+    unique (user_id, code_hash)
+);
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+drop table if exists user_recovery_codes;
+drop table if exists user_totp;
+drop table if exists sessions;
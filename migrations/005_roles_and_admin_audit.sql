@@ -0,0 +1,25 @@
+-- Write your migrate up statements here
+alter table users add column if not exists role text not null default 'user'
+    check (role in ('user', 'admin', 'support-readonly'));
+alter table users add column if not exists disabled_at timestamptz;
+
+-- если сессию создал админ через impersonate, здесь его id
+alter table sessions add column if not exists impersonator_id bigint references users(id) on delete cascade;
+
+create table if not exists admin_audit_log (
+    id bigserial primary key,
+    actor_id bigint not null references users(id),
+    action text not null,
+    target_user_id bigint references users(id) on delete set null,
+    details jsonb not null default '{}',
+    create_at timestamptz not null default now()
+);
+
+create index if not exists admin_audit_log_target_idx on admin_audit_log (target_user_id, create_at desc);
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+drop table if exists admin_audit_log;
+alter table sessions drop column if exists impersonator_id;
+alter table users drop column if exists disabled_at;
+alter table users drop column if exists role;
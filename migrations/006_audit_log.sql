@@ -0,0 +1,66 @@
+-- Write your migrate up statements here
+-- actor_id и entity_id без внешних ключей: история должна пережить удаление пользователя
+create table if not exists audit_log (
+    id bigserial primary key,
+    actor_id bigint,
+    impersonator_id bigint,
+    action text not null,
+    entity_type text not null,
+    entity_id text not null,
+    diff jsonb not null default '{}',
+    request_id text,
+    ip text,
+    create_at timestamptz not null default now()
+);
+
+create index if not exists audit_log_actor_idx on audit_log (actor_id, id desc);
+create index if not exists audit_log_entity_idx on audit_log (entity_type, entity_id, id desc);
+
+create or replace function audit_log_append_only() returns trigger as $$
+begin
+    raise exception 'audit_log is append-only';
+end;
+$$ language plpgsql;
+
+create trigger audit_log_append_only
+    before update or delete on audit_log
+    for each row execute function audit_log_append_only();
+
+-- журнал админки теперь часть общего audit_log. details - плоские параметры
+-- действия, в diff они становятся значениями "после": {"k": {"before": null, "after": v}}
+insert into audit_log (actor_id, action, entity_type, entity_id, diff, create_at)
+select actor_id, 'admin.' || action, 'user', coalesce(target_user_id::text, ''),
+    (select coalesce(jsonb_object_agg(key, jsonb_build_object('before', null, 'after', value)), '{}')
+     from jsonb_each(details)),
+    create_at
+from admin_audit_log
+order by id;
+
+drop table if exists admin_audit_log;
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+create table if not exists admin_audit_log (
+    id bigserial primary key,
+    actor_id bigint not null references users(id),
+    action text not null,
+    target_user_id bigint references users(id) on delete set null,
+    details jsonb not null default '{}',
+    create_at timestamptz not null default now()
+);
+
+-- записи админки возвращаются обратно, остальная история при откате теряется.
+-- Записи удалённых админов в старую таблицу не влезают: там actor_id not null с внешним ключом
+insert into admin_audit_log (actor_id, action, target_user_id, details, create_at)
+select l.actor_id, substr(l.action, length('admin.') + 1), t.id,
+    (select coalesce(jsonb_object_agg(key, value->'after'), '{}') from jsonb_each(l.diff)),
+    l.create_at
+from audit_log l
+join users a on a.id = l.actor_id
+left join users t on t.id = nullif(l.entity_id, '')::bigint
+where l.action like 'admin.%' and l.entity_type = 'user'
+order by l.id;
+
+drop trigger if exists audit_log_append_only on audit_log;
+drop function if exists audit_log_append_only();
+drop table if exists audit_log;
@@ -0,0 +1,19 @@
+-- Write your migrate up statements here
+-- key уже включает того, кто прислал запрос: у разных пользователей ключи не пересекаются.
+-- status is null, пока первый запрос ещё выполняется
+create table if not exists idempotency_keys (
+    key text primary key,
+    fingerprint text not null,
+    status int,
+    headers jsonb,
+    body bytea,
+    locked_until timestamptz not null,
+    expires_at timestamptz not null,
+    create_at timestamptz not null default now()
+);
+
+create index if not exists idempotency_keys_expires_idx on idempotency_keys (expires_at);
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+drop table if exists idempotency_keys;
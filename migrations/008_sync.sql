@@ -0,0 +1,68 @@
+-- Write your migrate up statements here
+-- Последовательность изменений у каждого пользователя своя. Счётчик - строка в
+-- sync_state, а не общий sequence: блокировка строки держится до commit, поэтому
+-- номера у одного пользователя видны клиентам строго по порядку и без дыр
+-- от ещё не закоммиченных транзакций.
+create table if not exists sync_state (
+    user_id bigint primary key references users(id) on delete cascade,
+    seq bigint not null
+);
+
+-- по одной строке на сущность: клиенту нужно только её последнее изменение.
+-- deleted - tombstone, саму сущность уже не загрузить
+create table if not exists sync_log (
+    user_id bigint not null references users(id) on delete cascade,
+    seq bigint not null,
+    entity_type text not null,
+    entity_id text not null,
+    deleted boolean not null default false,
+    changed_at timestamptz not null default now(),
+    primary key (user_id, entity_type, entity_id)
+);
+
+create index if not exists sync_log_seq_idx on sync_log (user_id, seq);
+
+-- sync_touch вызывают триггеры всех таблиц, которые синхронизируются с клиентами
+create or replace function sync_touch(p_user bigint, p_type text, p_id text, p_deleted boolean) returns void as $$
+declare
+    next_seq bigint;
+begin
+    insert into sync_state (user_id, seq) values (p_user, 1)
+    on conflict (user_id) do update set seq = sync_state.seq + 1
+    returning seq into next_seq;
+
+    insert into sync_log (user_id, seq, entity_type, entity_id, deleted, changed_at)
+    values (p_user, next_seq, p_type, p_id, p_deleted, now())
+    on conflict (user_id, entity_type, entity_id) do update
+    set seq = excluded.seq, deleted = excluded.deleted, changed_at = excluded.changed_at;
+end;
+$$ language plpgsql;
+
+create or replace function users_sync_touch() returns trigger as $$
+begin
+    if tg_op = 'UPDATE' and (old.username, old.email, old.role, old.disabled_at)
+        is not distinct from (new.username, new.email, new.role, new.disabled_at) then
+        return new;
+    end if;
+
+    perform sync_touch(new.id, 'user', new.id::text, false);
+    return new;
+end;
+$$ language plpgsql;
+
+create trigger users_sync_touch
+    after insert or update of username, email, role, disabled_at on users
+    for each row execute function users_sync_touch();
+
+-- уже существующие профили попадают в первую синхронизацию
+insert into sync_state (user_id, seq) select id, 1 from users on conflict do nothing;
+insert into sync_log (user_id, seq, entity_type, entity_id)
+select id, 1, 'user', id::text from users on conflict do nothing;
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+drop trigger if exists users_sync_touch on users;
+drop function if exists users_sync_touch();
+drop function if exists sync_touch(bigint, text, text, boolean);
+drop table if exists sync_log;
+drop table if exists sync_state;
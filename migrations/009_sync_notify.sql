@@ -0,0 +1,37 @@
+-- Write your migrate up statements here
+-- NOTIFY доставляется только после commit, поэтому слушатель не увидит номер,
+-- изменения под которым ещё нельзя прочитать. В payload нет данных сущности:
+-- это только сигнал "у пользователя появились изменения после seq".
+create or replace function sync_touch(p_user bigint, p_type text, p_id text, p_deleted boolean) returns void as $$
+declare
+    next_seq bigint;
+begin
+    insert into sync_state (user_id, seq) values (p_user, 1)
+    on conflict (user_id) do update set seq = sync_state.seq + 1
+    returning seq into next_seq;
+
+    insert into sync_log (user_id, seq, entity_type, entity_id, deleted, changed_at)
+    values (p_user, next_seq, p_type, p_id, p_deleted, now())
+    on conflict (user_id, entity_type, entity_id) do update
+    set seq = excluded.seq, deleted = excluded.deleted, changed_at = excluded.changed_at;
+
+    perform pg_notify('mm_changes', json_build_object('user_id', p_user, 'seq', next_seq)::text);
+end;
+$$ language plpgsql;
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+create or replace function sync_touch(p_user bigint, p_type text, p_id text, p_deleted boolean) returns void as $$
+declare
+    next_seq bigint;
+begin
+    insert into sync_state (user_id, seq) values (p_user, 1)
+    on conflict (user_id) do update set seq = sync_state.seq + 1
+    returning seq into next_seq;
+
+    insert into sync_log (user_id, seq, entity_type, entity_id, deleted, changed_at)
+    values (p_user, next_seq, p_type, p_id, p_deleted, now())
+    on conflict (user_id, entity_type, entity_id) do update
+    set seq = excluded.seq, deleted = excluded.deleted, changed_at = excluded.changed_at;
+end;
+$$ language plpgsql;
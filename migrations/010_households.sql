@@ -0,0 +1,77 @@
+-- Write your migrate up statements here
+-- Домохозяйство - общее пространство нескольких пользователей, например семьи.
+-- Счета и операции со временем будут принадлежать ему, а не пользователю.
+create table if not exists households (
+    id bigserial primary key,
+    name text not null,
+    create_at timestamptz not null default now(),
+    update_at timestamptz not null default now()
+);
+
+-- владельцев может быть несколько, но хотя бы один есть всегда: это проверяет
+-- приложение под блокировкой строки households
+create table if not exists household_members (
+    household_id bigint not null references households(id) on delete cascade,
+    user_id bigint not null references users(id) on delete cascade,
+    role text not null check (role in ('owner', 'editor', 'viewer')),
+    joined_at timestamptz not null default now(),
+    primary key (household_id, user_id)
+);
+
+create index if not exists household_members_user_idx on household_members (user_id);
+
+-- приложение не даёт уйти последнему владельцу, но участник может исчезнуть и
+-- вместе с удалённым пользователем (on delete cascade). Тогда владельцем
+-- становится самый давний из оставшихся, а без участников домохозяйство удаляется.
+create or replace function household_keep_owner() returns trigger as $$
+begin
+    if old.role <> 'owner' or exists (
+        select 1 from household_members where household_id = old.household_id and role = 'owner'
+    ) then
+        return null;
+    end if;
+
+    update household_members set role = 'owner'
+    where (household_id, user_id) = (
+        select household_id, user_id from household_members
+        where household_id = old.household_id
+        order by joined_at, user_id
+        limit 1
+    );
+
+    if not found then
+        delete from households where id = old.household_id;
+    end if;
+
+    return null;
+end;
+$$ language plpgsql;
+
+create trigger household_keep_owner
+    after delete on household_members
+    for each row execute function household_keep_owner();
+
+-- приглашение по email, в базе только хэш токена из письма
+create table if not exists household_invitations (
+    id bigserial primary key,
+    household_id bigint not null references households(id) on delete cascade,
+    email text not null,
+    role text not null check (role in ('editor', 'viewer')),
+    token_hash bytea not null unique,
+    invited_by bigint references users(id) on delete set null,
+    expires_at timestamptz not null,
+    accepted_at timestamptz,
+    create_at timestamptz not null default now()
+);
+
+-- повторное приглашение на тот же адрес заменяет прежнее
+create unique index if not exists household_invitations_pending_idx
+    on household_invitations (household_id, lower(email)) where accepted_at is null;
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+drop trigger if exists household_keep_owner on household_members;
+drop function if exists household_keep_owner();
+drop table if exists household_invitations;
+drop table if exists household_members;
+drop table if exists households;
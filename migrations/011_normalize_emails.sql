@@ -0,0 +1,16 @@
+-- Write your migrate up statements here
+-- Приложение хранит email в нижнем регистре и без пробелов по краям (user.NormalizeEmail),
+-- вход ищет аккаунт по такому же виду. Старые записи приводим к нему же.
+-- Если два аккаунта отличаются только регистром, оба оставляем как есть:
+-- склеивать их автоматически нельзя, это решает администратор.
+update users u
+set email = lower(btrim(u.email))
+where u.email <> lower(btrim(u.email))
+  and not exists (
+    select 1 from users o
+    where o.id <> u.id and lower(btrim(o.email)) = lower(btrim(u.email))
+  );
+---- create above / drop below ----
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Исходный регистр не сохранялся, откатывать нечего.
@@ -0,0 +1,41 @@
+// Package migrations - SQL миграции в формате tern, вшиты в бинарник.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var FS embed.FS
+
+// VersionTable - таблица, в которой tern хранит номер применённой миграции.
+const VersionTable = "schema_version"
+
+// Latest - номер последней миграции, берётся из префикса имени файла (006_audit_log.sql -> 6).
+func Latest() (int, error) {
+	names, err := fs.Glob(FS, "*.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	latest := 0
+	for _, name := range names {
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return 0, fmt.Errorf("migration %s: no numeric prefix", name)
+		}
+
+		n, err := strconv.Atoi(prefix)
+		if err != nil {
+			return 0, fmt.Errorf("migration %s: %w", name, err)
+		}
+
+		latest = max(latest, n)
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,89 @@
+package logger
+
+import "context"
+
+// Ключи полей, которые кладут в context общие middleware.
+const (
+	RequestIDKey = "request_id"
+	UserIDKey    = "user_id"
+	TraceIDKey   = "trace_id"
+	SpanIDKey    = "span_id"
+)
+
+type fieldsCtxKey struct{}
+
+// WithFields возвращает context, в котором к уже сохранённым полям добавлены fields.
+// Логгер пишет эти поля в каждую строку, залогированную с таким context.
+func WithFields(ctx context.Context, fields ...Field) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+
+	prev := FieldsFromContext(ctx)
+	// копия, чтобы соседние context не делили один массив
+	merged := make([]Field, 0, len(prev)+len(fields))
+	merged = append(merged, prev...)
+	merged = append(merged, fields...)
+
+	return context.WithValue(ctx, fieldsCtxKey{}, merged)
+}
+
+func FieldsFromContext(ctx context.Context) []Field {
+	if ctx == nil {
+		return nil
+	}
+
+	fields, _ := ctx.Value(fieldsCtxKey{}).([]Field)
+	return fields
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return WithFields(ctx, Field{Key: RequestIDKey, Value: id})
+}
+
+// RequestIDFromContext возвращает "", если запрос не проходил через middleware с X-Request-ID.
+func RequestIDFromContext(ctx context.Context) string {
+	fields := FieldsFromContext(ctx)
+	for i := len(fields) - 1; i >= 0; i-- {
+		if fields[i].Key == RequestIDKey {
+			id, _ := fields[i].Value.(string)
+			return id
+		}
+	}
+
+	return ""
+}
+
+func WithUserID(ctx context.Context, id int64) context.Context {
+	return WithFields(ctx, Field{Key: UserIDKey, Value: id})
+}
+
+// ContextExtractor достаёт поля из context, которые хранятся не через WithFields,
+// например trace_id и span_id текущего спана.
+type ContextExtractor func(ctx context.Context) []Field
+
+type Option func(*zapLogger)
+
+func WithContextExtractor(fn ContextExtractor) Option {
+	return func(l *zapLogger) {
+		l.extractors = append(l.extractors, fn)
+	}
+}
+
+// contextFields - поля context, к которым дописываются поля конкретного вызова.
+func (l *zapLogger) contextFields(ctx context.Context, fields []Field) []Field {
+	if ctx == nil {
+		return fields
+	}
+
+	all := FieldsFromContext(ctx)
+	for _, extract := range l.extractors {
+		all = append(all[:len(all):len(all)], extract(ctx)...)
+	}
+
+	if len(all) == 0 {
+		return fields
+	}
+
+	return append(all[:len(all):len(all)], fields...)
+}
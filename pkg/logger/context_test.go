@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newObservedLogger(opts ...Option) (Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := &zapLogger{sugar: zap.New(core).Sugar()}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l, logs
+}
+
+func TestLogger_ContextFields(t *testing.T) {
+	trace := func(ctx context.Context) []Field {
+		return []Field{{Key: TraceIDKey, Value: "abc"}}
+	}
+	log, logs := newObservedLogger(WithContextExtractor(trace))
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = WithUserID(ctx, 42)
+
+	log.With(Field{Key: "component", Value: "repo"}).Info(ctx, "hello", Field{Key: "extra", Value: 1})
+
+	require.Equal(t, 1, logs.Len())
+	require.Equal(t, map[string]any{
+		"component":  "repo",
+		RequestIDKey: "req-1",
+		UserIDKey:    int64(42),
+		TraceIDKey:   "abc",
+		"extra":      int64(1),
+	}, logs.All()[0].ContextMap())
+}
+
+func TestWithFields_DoesNotShareParent(t *testing.T) {
+	parent := WithRequestID(context.Background(), "req-1")
+
+	a := WithFields(parent, Field{Key: "a", Value: 1})
+	b := WithFields(parent, Field{Key: "b", Value: 2})
+
+	require.Len(t, FieldsFromContext(parent), 1)
+	require.Equal(t, "a", FieldsFromContext(a)[1].Key)
+	require.Equal(t, "b", FieldsFromContext(b)[1].Key)
+	require.Equal(t, "req-1", RequestIDFromContext(b))
+	require.Empty(t, RequestIDFromContext(context.Background()))
+}
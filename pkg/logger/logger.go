@@ -2,6 +2,8 @@ package logger
 
 import (
 	"context"
+	"errors"
+	"io"
 
 	"github.com/skinkvi/money_managment/internal/config"
 )
@@ -18,12 +20,43 @@ type Logger interface {
 	Sync() error
 }
 
+var (
+	ErrInvalidLevel    = errors.New("invalid log level")
+	ErrInvalidEncoding = errors.New("invalid log encoding")
+)
+
+// LevelController - смена уровня логирования без перезапуска.
+// Реализуется логгером из New, общий для него и всех логгеров из With.
+type LevelController interface {
+	Level() string
+	// SetLevel принимает debug, info, warn или error.
+	SetLevel(level string) error
+}
+
+// EncodingController - смена формата вывода без перезапуска. Касается выводов,
+// у которых в конфиге нет своего encoding.
+type EncodingController interface {
+	Encoding() string
+	// SetEncoding принимает console или json.
+	SetEncoding(encoding string) error
+}
+
+// Close - последний вызов перед выходом: Sync и остановка фоновой работы логгера
+// из New, например ротации файлов по времени. Остальным логгерам хватает Sync.
+func Close(l Logger) error {
+	if c, ok := l.(io.Closer); ok {
+		return c.Close()
+	}
+	return l.Sync()
+}
+
 // совместима с логгером zap
 type Field struct {
 	Key   string
 	Value interface{}
 }
 
-func New(cfg *config.LoggerConfig) (Logger, error) {
-	return newZapLogger(cfg)
+// New создаёт логгер, который к полям вызова добавляет поля из ctx (см. WithFields).
+func New(cfg *config.LoggerConfig, opts ...Option) (Logger, error) {
+	return newZapLogger(cfg, opts...)
 }
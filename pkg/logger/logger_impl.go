@@ -2,7 +2,9 @@ package logger
 
 import (
 	"context"
-	"os"
+	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/skinkvi/money_managment/internal/config"
 	"go.uber.org/zap"
@@ -10,50 +12,97 @@ import (
 )
 
 type zapLogger struct {
-	sugar *zap.SugaredLogger
-}
-
-func newZapLogger(cfg *config.LoggerConfig) (Logger, error) {
-	var zapLevel zapcore.Level
-	switch cfg.Level {
-	case "debug":
-		zapLevel = zap.DebugLevel
-	case "info":
-		zapLevel = zap.InfoLevel
-	case "warn":
-		zapLevel = zap.WarnLevel
-	case "error":
-		zapLevel = zap.ErrorLevel
-	default:
-		zapLevel = zap.InfoLevel
-	}
+	sugar      *zap.SugaredLogger
+	level      zap.AtomicLevel
+	encoding   *encodingSwitch
+	extractors []ContextExtractor
+	// общий для логгера из New и всех логгеров из With
+	closer *closer
+}
+
+// closer останавливает фоновую ротацию выводов один раз.
+type closer struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *closer) close() {
+	c.once.Do(func() { close(c.done) })
+}
 
-	encCfg := zap.NewProductionEncoderConfig()
-	encCfg.TimeKey = "ts"
-	encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+func newZapLogger(cfg *config.LoggerConfig, opts ...Option) (Logger, error) {
+	level := zap.NewAtomicLevelAt(parseLevel(cfg.Level, zap.InfoLevel))
+	encoding := newEncodingSwitch(cfg.Encoding)
 
-	var encoder zapcore.Encoder
-	switch cfg.Encoding {
-	case "console":
-		encoder = zapcore.NewConsoleEncoder(encCfg)
-	default:
-		encoder = zapcore.NewJSONEncoder(encCfg)
+	sinks := cfg.Sinks
+	if len(sinks) == 0 {
+		// старый формат конфига: один вывод, stdout или OutputPath
+		sinks = []config.SinkConfig{{Output: cfg.OutputPath}}
 	}
 
-	out := []zapcore.WriteSyncer{zapcore.AddSync(os.Stdout)}
-	if cfg.OutputPath != "" {
-		fileWS, _, err := zap.Open(cfg.OutputPath)
+	c := &closer{done: make(chan struct{})}
+	cores := make([]zapcore.Core, 0, len(sinks))
+	for _, sink := range sinks {
+		core, err := newSinkCore(sink, encoding, level, c.done)
 		if err != nil {
+			c.close()
 			return nil, err
 		}
-		out = []zapcore.WriteSyncer{fileWS}
+		// оборачиваем каждый вывод отдельно: Tee.Write не проверяет уровни своих core,
+		// и обёртка над Tee писала бы во все выводы без учёта их уровня
+		if !cfg.Redact.Disabled {
+			core = newRedactCore(core, cfg.Redact.Allow)
+		}
+		cores = append(cores, core)
 	}
 
-	core := zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(out...), zapLevel)
+	z := zap.New(zapcore.NewTee(cores...), zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
 
-	z := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
+	l := &zapLogger{sugar: z.Sugar(), level: level, encoding: encoding, closer: c}
+	for _, opt := range opts {
+		opt(l)
+	}
 
-	return &zapLogger{sugar: z.Sugar()}, nil
+	return l, nil
+}
+
+func parseLevel(s string, fallback zapcore.Level) zapcore.Level {
+	lvl, err := zapcore.ParseLevel(s)
+	if err != nil || s == "" {
+		return fallback
+	}
+
+	return lvl
+}
+
+func (l *zapLogger) Level() string {
+	return l.level.Level().String()
+}
+
+func (l *zapLogger) SetLevel(level string) error {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidLevel, level)
+	}
+
+	l.level.SetLevel(lvl)
+	return nil
+}
+
+func (l *zapLogger) Encoding() string {
+	return l.encoding.String()
+}
+
+func (l *zapLogger) SetEncoding(encoding string) error {
+	encoding = strings.ToLower(encoding)
+	switch encoding {
+	case "console", "json":
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidEncoding, encoding)
+	}
+
+	l.encoding.console.Store(encoding == "console")
+	return nil
 }
 
 func toZapFields(fields []Field) []interface{} {
@@ -66,22 +115,30 @@ func toZapFields(fields []Field) []interface{} {
 }
 
 func (l *zapLogger) Debug(ctx context.Context, msg string, fields ...Field) {
-	l.sugar.Debugw(msg, toZapFields(fields)...)
+	l.sugar.Debugw(msg, toZapFields(l.contextFields(ctx, fields))...)
 }
 func (l *zapLogger) Info(ctx context.Context, msg string, fields ...Field) {
-	l.sugar.Infow(msg, toZapFields(fields)...)
+	l.sugar.Infow(msg, toZapFields(l.contextFields(ctx, fields))...)
 }
 func (l *zapLogger) Warn(ctx context.Context, msg string, fields ...Field) {
-	l.sugar.Warnw(msg, toZapFields(fields)...)
+	l.sugar.Warnw(msg, toZapFields(l.contextFields(ctx, fields))...)
 }
 func (l *zapLogger) Error(ctx context.Context, msg string, fields ...Field) {
-	l.sugar.Errorw(msg, toZapFields(fields)...)
+	l.sugar.Errorw(msg, toZapFields(l.contextFields(ctx, fields))...)
 }
 
 func (l *zapLogger) With(fields ...Field) Logger {
 	newSugar := l.sugar.With(toZapFields(fields)...)
-	return &zapLogger{sugar: newSugar}
+	return &zapLogger{sugar: newSugar, level: l.level, encoding: l.encoding, extractors: l.extractors, closer: l.closer}
 }
 func (l *zapLogger) Sync() error {
 	return l.sugar.Sync()
 }
+
+// Close сбрасывает буфер и останавливает ротацию по времени. Писать в логгер
+// после этого можно, но файлы больше не ротируются по таймеру.
+func (l *zapLogger) Close() error {
+	err := l.sugar.Sync()
+	l.closer.close()
+	return err
+}
@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"fmt"
+
+	"github.com/skinkvi/money_managment/pkg/redact"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// redactCore маскирует секреты и персональные данные во всех полях и в сообщении
+// до того, как запись попадёт в encoder. Поля из allow пропускаются без маскировки PII,
+// но секретные поля структур скрываются всегда.
+type redactCore struct {
+	zapcore.Core
+	allow map[string]struct{}
+}
+
+func newRedactCore(core zapcore.Core, allow []string) zapcore.Core {
+	set := make(map[string]struct{}, len(allow))
+	for _, k := range allow {
+		set[k] = struct{}{}
+	}
+
+	return &redactCore{Core: core, allow: set}
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.redactFields(fields)), allow: c.allow}
+}
+
+func (c *redactCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+
+	return ce
+}
+
+func (c *redactCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = redact.String(e.Message)
+	return c.Core.Write(e, c.redactFields(fields))
+}
+
+func (c *redactCore) redactFields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		out[i] = c.redactField(f)
+	}
+
+	return out
+}
+
+func (c *redactCore) redactField(f zapcore.Field) zapcore.Field {
+	_, allowed := c.allow[f.Key]
+
+	switch f.Type {
+	case zapcore.StringType:
+		if !allowed {
+			f.String = redact.String(f.String)
+		}
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && err != nil && !allowed {
+			return zap.String(f.Key, redact.String(err.Error()))
+		}
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok && !allowed {
+			return zap.String(f.Key, redact.String(s.String()))
+		}
+	case zapcore.ReflectType:
+		if allowed {
+			return zap.Any(f.Key, redact.Secrets(f.Interface))
+		}
+		return zap.Any(f.Key, redact.Value(f.Interface))
+	}
+
+	return f
+}
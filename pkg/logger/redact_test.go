@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type creds struct {
+	User     string `json:"user"`
+	Password string `json:"password" secret:"true"`
+}
+
+func TestRedactCore(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	log := &zapLogger{sugar: zap.New(newRedactCore(core, []string{"login"})).Sugar()}
+
+	log.With(Field{Key: "email", Value: "dima@example.com"}).Error(context.Background(), "reset for dima@example.com",
+		Field{Key: "error", Value: errors.New("user dima@example.com not found")},
+		Field{Key: "creds", Value: creds{User: "dima", Password: "qwerty"}},
+		Field{Key: "login", Value: "dima@example.com"})
+
+	require.Equal(t, 1, logs.Len())
+	entry := logs.All()[0]
+	require.Equal(t, "reset for d***@example.com", entry.Message)
+	require.Equal(t, map[string]any{
+		"email": "d***@example.com",
+		"error": "user d***@example.com not found",
+		"creds": map[string]any{"user": "dima", "password": "[redacted]"},
+		// поле из allowlist не маскируется
+		"login": "dima@example.com",
+	}, entry.ContextMap())
+}
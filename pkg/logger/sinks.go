@@ -0,0 +1,167 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// newSinkCore собирает core для одного вывода. Запись проходит, если её уровень
+// не ниже общего (его можно менять на лету) и не ниже уровня самого вывода.
+// Вывод без своего encoding следует общему, который тоже меняется на лету.
+// Ротация по времени работает, пока не закрыт done.
+func newSinkCore(sink config.SinkConfig, encoding *encodingSwitch, level zap.AtomicLevel, done <-chan struct{}) (zapcore.Core, error) {
+	ws, err := sinkWriter(sink, done)
+	if err != nil {
+		return nil, err
+	}
+
+	minLevel := parseLevel(sink.Level, zapcore.DebugLevel)
+	enabler := zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+		return l >= minLevel && level.Enabled(l)
+	})
+
+	if sink.Encoding != "" {
+		return zapcore.NewCore(newEncoder(sink.Encoding), ws, enabler), nil
+	}
+
+	return &switchCore{
+		json:    zapcore.NewCore(newEncoder("json"), ws, enabler),
+		console: zapcore.NewCore(newEncoder("console"), ws, enabler),
+		sw:      encoding,
+	}, nil
+}
+
+// encodingSwitch - общий для логгера формат вывода, console или json.
+type encodingSwitch struct {
+	console atomic.Bool
+}
+
+func newEncodingSwitch(encoding string) *encodingSwitch {
+	sw := &encodingSwitch{}
+	sw.console.Store(strings.EqualFold(encoding, "console"))
+	return sw
+}
+
+func (sw *encodingSwitch) String() string {
+	if sw.console.Load() {
+		return "console"
+	}
+	return "json"
+}
+
+// switchCore держит core для обоих форматов на одном выводе и пишет в тот,
+// что выбран сейчас. Поля из With добавляются в оба, поэтому переключение не
+// теряет контекст уже созданных логгеров.
+type switchCore struct {
+	json    zapcore.Core
+	console zapcore.Core
+	sw      *encodingSwitch
+}
+
+func (c *switchCore) current() zapcore.Core {
+	if c.sw.console.Load() {
+		return c.console
+	}
+	return c.json
+}
+
+func (c *switchCore) Enabled(l zapcore.Level) bool { return c.json.Enabled(l) }
+
+func (c *switchCore) With(fields []zapcore.Field) zapcore.Core {
+	return &switchCore{json: c.json.With(fields), console: c.console.With(fields), sw: c.sw}
+}
+
+func (c *switchCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *switchCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.current().Write(ent, fields)
+}
+
+func (c *switchCore) Sync() error { return c.current().Sync() }
+
+func newEncoder(encoding string) zapcore.Encoder {
+	encCfg := zap.NewProductionEncoderConfig()
+	encCfg.TimeKey = "ts"
+	encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	if encoding == "console" {
+		return zapcore.NewConsoleEncoder(encCfg)
+	}
+
+	return zapcore.NewJSONEncoder(encCfg)
+}
+
+func sinkWriter(sink config.SinkConfig, done <-chan struct{}) (zapcore.WriteSyncer, error) {
+	switch sink.Output {
+	case "", "stdout":
+		return zapcore.AddSync(os.Stdout), nil
+	case "stderr":
+		return zapcore.AddSync(os.Stderr), nil
+	}
+
+	r := sink.Rotation
+	// один compress тоже включает ротацию: по размеру, с порогом lumberjack по умолчанию (100 МБ)
+	if r.MaxSizeMB == 0 && r.MaxAge == 0 && r.MaxBackups == 0 && r.Every == 0 && !r.Compress {
+		// без ротации файл просто дописывается, как раньше
+		ws, _, err := zap.Open(sink.Output)
+		if err != nil {
+			return nil, fmt.Errorf("open log file %s: %w", sink.Output, err)
+		}
+		return ws, nil
+	}
+
+	lj := &lumberjack.Logger{
+		Filename:   sink.Output,
+		MaxSize:    r.MaxSizeMB,
+		MaxAge:     maxAgeDays(r.MaxAge),
+		MaxBackups: r.MaxBackups,
+		Compress:   r.Compress,
+		LocalTime:  true,
+	}
+
+	if r.Every > 0 {
+		go rotateEvery(lj, r.Every, done)
+	}
+
+	return zapcore.AddSync(lj), nil
+}
+
+// maxAgeDays переводит maxAge в сутки для lumberjack с округлением вверх:
+// 0 у него значит хранить вечно, и 12h не должны превращаться в него.
+func maxAgeDays(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + 24*time.Hour - 1) / (24 * time.Hour))
+}
+
+// rotateEvery ротирует файл по времени, lumberjack сам умеет только по размеру.
+// Останавливается, когда логгер закрыт.
+func rotateEvery(lj *lumberjack.Logger, every time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := lj.Rotate(); err != nil {
+				fmt.Fprintf(os.Stderr, "log rotation failed for %s: %v\n", lj.Filename, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skinkvi/money_managment/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_SinksAndLevel(t *testing.T) {
+	dir := t.TempDir()
+	all := filepath.Join(dir, "all.log")
+	errs := filepath.Join(dir, "errors.log")
+
+	log, err := New(&config.LoggerConfig{
+		Level:    "debug",
+		Encoding: "json",
+		Sinks: []config.SinkConfig{
+			{Output: all},
+			{Output: errs, Level: "error", Encoding: "console", Rotation: config.RotationConfig{MaxSizeMB: 1, MaxBackups: 2}},
+		},
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	log.Debug(ctx, "debug one")
+	log.Error(ctx, "error one")
+
+	levels := log.(LevelController)
+	require.ErrorIs(t, levels.SetLevel("verbose"), ErrInvalidLevel)
+	require.NoError(t, levels.SetLevel("warn"))
+	require.Equal(t, "warn", levels.Level())
+
+	// уровень общий и для логгеров из With
+	log.With(Field{Key: "k", Value: "v"}).Info(ctx, "info two")
+	log.Warn(ctx, "warn two")
+	require.NoError(t, log.Sync())
+
+	allLines := readLines(t, all)
+	require.Len(t, allLines, 3)
+	require.Contains(t, allLines[0], `"msg":"debug one"`)
+	require.Contains(t, allLines[2], `"msg":"warn two"`)
+
+	// после ошибки в файле ещё stacktrace, поэтому проверяем только отсутствие лишних записей
+	errLines := readLines(t, errs)
+	require.Contains(t, errLines[0], "error one")
+	require.NotContains(t, strings.Join(errLines, "\n"), "debug one")
+	require.NotContains(t, strings.Join(errLines, "\n"), "warn two")
+	require.False(t, strings.HasPrefix(errLines[0], "{"), "console encoding expected")
+}
+
+func TestLogger_SetEncoding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	log, err := New(&config.LoggerConfig{Level: "info", Encoding: "json", Sinks: []config.SinkConfig{{Output: path}}})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	child := log.With(Field{Key: "component", Value: "test"})
+	child.Info(ctx, "as json")
+
+	enc := log.(EncodingController)
+	require.ErrorIs(t, enc.SetEncoding("xml"), ErrInvalidEncoding)
+	require.NoError(t, enc.SetEncoding("console"))
+	require.Equal(t, "console", enc.Encoding())
+
+	// логгер, созданный до переключения, тоже пишет в новом формате и с теми же полями
+	child.Info(ctx, "as console")
+	require.NoError(t, log.Sync())
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasPrefix(lines[0], "{"))
+	require.Contains(t, lines[0], `"component":"test"`)
+	require.False(t, strings.HasPrefix(lines[1], "{"))
+	require.Contains(t, lines[1], "as console")
+	require.Contains(t, lines[1], `"component": "test"`)
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestMaxAgeDays(t *testing.T) {
+	require.Equal(t, 0, maxAgeDays(0))
+	// меньше суток не превращается в 0 - "хранить вечно"
+	require.Equal(t, 1, maxAgeDays(12*time.Hour))
+	require.Equal(t, 1, maxAgeDays(24*time.Hour))
+	require.Equal(t, 2, maxAgeDays(25*time.Hour))
+}
+
+func TestLogger_CloseStopsRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	log, err := New(&config.LoggerConfig{Level: "info", Encoding: "json", Sinks: []config.SinkConfig{
+		{Output: path, Rotation: config.RotationConfig{Every: 20 * time.Millisecond}},
+	}})
+	require.NoError(t, err)
+	log.Info(context.Background(), "before rotation")
+
+	countFiles := func() int {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		return len(entries)
+	}
+	require.Eventually(t, func() bool { return countFiles() > 1 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, Close(log))
+	// тикер мог сработать одновременно с Close
+	time.Sleep(30 * time.Millisecond)
+	n := countFiles()
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, n, countFiles())
+}
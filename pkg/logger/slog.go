@@ -0,0 +1,181 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// NewSlogHandler - slog.Handler, который пишет через l. Нужен для библиотек,
+// которые логируют через log/slog: их записи проходят через те же выводы,
+// маскировку и поля из context, что и наши.
+func NewSlogHandler(l Logger) slog.Handler {
+	return &slogHandler{log: l}
+}
+
+type slogHandler struct {
+	log    Logger
+	fields []Field
+	// префикс из WithGroup, ключи пишутся как "group.key"
+	group string
+}
+
+// levelEnabler реализует zapLogger, для остальных Logger считаем, что включено всё.
+type levelEnabler interface {
+	enabled(level slog.Level) bool
+}
+
+func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if e, ok := h.log.(levelEnabler); ok {
+		return e.enabled(level)
+	}
+
+	return true
+}
+
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make([]Field, 0, len(h.fields)+r.NumAttrs())
+	fields = append(fields, h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendAttr(fields, h.group, a)
+		return true
+	})
+
+	switch {
+	case r.Level < slog.LevelInfo:
+		h.log.Debug(ctx, r.Message, fields...)
+	case r.Level < slog.LevelWarn:
+		h.log.Info(ctx, r.Message, fields...)
+	case r.Level < slog.LevelError:
+		h.log.Warn(ctx, r.Message, fields...)
+	default:
+		h.log.Error(ctx, r.Message, fields...)
+	}
+
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := append([]Field(nil), h.fields...)
+	for _, a := range attrs {
+		fields = appendAttr(fields, h.group, a)
+	}
+
+	return &slogHandler{log: h.log, fields: fields, group: h.group}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &slogHandler{log: h.log, fields: h.fields, group: joinKey(h.group, name)}
+}
+
+func appendAttr(fields []Field, group string, a slog.Attr) []Field {
+	v := a.Value.Resolve()
+
+	if v.Kind() == slog.KindGroup {
+		prefix := group
+		// у группы без имени атрибуты поднимаются на уровень выше
+		if a.Key != "" {
+			prefix = joinKey(group, a.Key)
+		}
+		for _, ga := range v.Group() {
+			fields = appendAttr(fields, prefix, ga)
+		}
+		return fields
+	}
+
+	if a.Key == "" {
+		return fields
+	}
+
+	return append(fields, Field{Key: joinKey(group, a.Key), Value: v.Any()})
+}
+
+func joinKey(group, key string) string {
+	if group == "" {
+		return key
+	}
+
+	return group + "." + key
+}
+
+func (l *zapLogger) enabled(level slog.Level) bool {
+	return l.sugar.Desugar().Core().Enabled(zapLevel(level))
+}
+
+func zapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return zapcore.DebugLevel
+	case level < slog.LevelWarn:
+		return zapcore.InfoLevel
+	case level < slog.LevelError:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
+
+// NewFromSlog - Logger поверх любого slog.Handler. Поля из context (WithFields)
+// дописываются в каждую запись, потому что сам handler о них не знает.
+func NewFromSlog(h slog.Handler) Logger {
+	return &slogLogger{h: h}
+}
+
+type slogLogger struct {
+	h slog.Handler
+}
+
+func (l *slogLogger) log(ctx context.Context, level slog.Level, msg string, fields []Field) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !l.h.Enabled(ctx, level) {
+		return
+	}
+
+	r := slog.NewRecord(time.Now(), level, msg, 0)
+	for _, f := range FieldsFromContext(ctx) {
+		r.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+	for _, f := range fields {
+		r.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+
+	_ = l.h.Handle(ctx, r)
+}
+
+func (l *slogLogger) Debug(ctx context.Context, msg string, fields ...Field) {
+	l.log(ctx, slog.LevelDebug, msg, fields)
+}
+
+func (l *slogLogger) Info(ctx context.Context, msg string, fields ...Field) {
+	l.log(ctx, slog.LevelInfo, msg, fields)
+}
+
+func (l *slogLogger) Warn(ctx context.Context, msg string, fields ...Field) {
+	l.log(ctx, slog.LevelWarn, msg, fields)
+}
+
+func (l *slogLogger) Error(ctx context.Context, msg string, fields ...Field) {
+	l.log(ctx, slog.LevelError, msg, fields)
+}
+
+func (l *slogLogger) With(fields ...Field) Logger {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+
+	return &slogLogger{h: l.h.WithAttrs(attrs)}
+}
+
+// Sync ничего не делает: у slog.Handler нет буфера, который надо сбрасывать.
+func (l *slogLogger) Sync() error {
+	return nil
+}
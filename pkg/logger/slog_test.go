@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSlogHandler(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := &zapLogger{sugar: zap.New(core).Sugar(), level: zap.NewAtomicLevelAt(zapcore.InfoLevel)}
+
+	sl := slog.New(NewSlogHandler(l)).With("component", "pgx").WithGroup("query")
+	ctx := WithRequestID(context.Background(), "req-1")
+
+	sl.DebugContext(ctx, "skipped")
+	sl.WarnContext(ctx, "slow query", "sql", "select 1", slog.Group("stats", "rows", 1))
+
+	require.Equal(t, 1, logs.Len())
+	e := logs.All()[0]
+	require.Equal(t, zapcore.WarnLevel, e.Level)
+	require.Equal(t, "slow query", e.Message)
+	require.Equal(t, map[string]any{
+		"component":        "pgx",
+		"query.sql":        "select 1",
+		"query.stats.rows": int64(1),
+		RequestIDKey:       "req-1",
+	}, e.ContextMap())
+}
+
+func TestNewFromSlog(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewFromSlog(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	ctx := WithUserID(context.Background(), 7)
+	l.Debug(ctx, "skipped")
+	l.With(Field{Key: "component", Value: "repo"}).Error(ctx, "failed", Field{Key: "op", Value: "Create"})
+
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	require.Equal(t, "ERROR", rec["level"])
+	require.Equal(t, "failed", rec["msg"])
+	require.Equal(t, "repo", rec["component"])
+	require.Equal(t, "Create", rec["op"])
+	require.Equal(t, float64(7), rec[UserIDKey])
+}
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/skinkvi/money_managment/pkg/logger"
+)
+
+// LogMailer ничего не отправляет, а пишет письмо в лог. Для локальной разработки.
+type LogMailer struct {
+	log logger.Logger
+}
+
+func NewLogMailer(log logger.Logger) *LogMailer {
+	return &LogMailer{log: log}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.log.Info(ctx, "mail sent",
+		logger.Field{Key: "to", Value: msg.To},
+		logger.Field{Key: "subject", Value: msg.Subject},
+		logger.Field{Key: "body", Value: msg.Body})
+	return nil
+}
+
+// FileMailer складывает каждое письмо отдельным .eml файлом в dir,
+// удобно в тестах и при ручной проверке ссылок из писем.
+type FileMailer struct {
+	dir  string
+	from string
+	seq  atomic.Int64
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create mail dir %s: %w", dir, err)
+	}
+
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	name := fmt.Sprintf("%d-%03d-%s.eml", time.Now().UnixNano(), m.seq.Add(1), sanitize(msg.To))
+
+	if err := os.WriteFile(filepath.Join(m.dir, name), encode(m.from, msg), 0o644); err != nil {
+		return fmt.Errorf("write mail file: %w", err)
+	}
+
+	return nil
+}
+
+func sanitize(addr string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, addr)
+}
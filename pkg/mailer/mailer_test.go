@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates_Render(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello.ru.tmpl": {Data: []byte("Привет\n\nПривет, {{.Name}}!")},
+		"hello.en.tmpl": {Data: []byte("Hello\n\nHello, {{.Name}}!")},
+	}
+
+	tmpl, err := ParseTemplates(fsys, "*.tmpl", "ru")
+	require.NoError(t, err)
+
+	cases := []struct {
+		lang        string
+		wantSubject string
+		wantBody    string
+	}{
+		{lang: "en", wantSubject: "Hello", wantBody: "Hello, Dima!"},
+		{lang: "en-US,en;q=0.9", wantSubject: "Hello", wantBody: "Hello, Dima!"},
+		{lang: "ru", wantSubject: "Привет", wantBody: "Привет, Dima!"},
+		{lang: "de", wantSubject: "Привет", wantBody: "Привет, Dima!"},
+		{lang: "", wantSubject: "Привет", wantBody: "Привет, Dima!"},
+	}
+
+	for _, tc := range cases {
+		msg, err := tmpl.Render("hello", tc.lang, map[string]string{"Name": "Dima"})
+		require.NoError(t, err)
+		require.Equal(t, tc.wantSubject, msg.Subject, tc.lang)
+		require.Equal(t, tc.wantBody, msg.Body, tc.lang)
+	}
+
+	_, err = tmpl.Render("missing", "en", nil)
+	require.Error(t, err)
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir, "no-reply@example.com")
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), Message{To: "dima@example.com", Subject: "Тема", Body: "body"})
+	require.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "To: dima@example.com\r\n")
+	require.Contains(t, string(data), "Subject: =?utf-8?q?")
+	require.Contains(t, string(data), "\r\n\r\nbody")
+}
@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"strings"
+	"text/template"
+)
+
+// Templates рендерит письма из файлов вида <name>.<lang>.tmpl.
+// Первая строка шаблона - тема письма, остальное - тело.
+type Templates struct {
+	tmpl     *template.Template
+	fallback string
+}
+
+func ParseTemplates(fsys fs.FS, pattern, fallbackLang string) (*Templates, error) {
+	tmpl, err := template.ParseFS(fsys, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("parse mail templates: %w", err)
+	}
+
+	return &Templates{tmpl: tmpl, fallback: fallbackLang}, nil
+}
+
+// Render ищет шаблон на языке lang ("ru", "en-US" и т.п.), если его нет - на fallback языке.
+func (t *Templates) Render(name, lang string, data any) (Message, error) {
+	tmpl := t.tmpl.Lookup(name + "." + normalizeLang(lang) + ".tmpl")
+	if tmpl == nil {
+		tmpl = t.tmpl.Lookup(name + "." + t.fallback + ".tmpl")
+	}
+	if tmpl == nil {
+		return Message{}, fmt.Errorf("mail template %q not found", name)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return Message{}, fmt.Errorf("render mail template %q: %w", name, err)
+	}
+
+	subject, body, _ := strings.Cut(b.String(), "\n")
+
+	return Message{Subject: strings.TrimSpace(subject), Body: strings.TrimLeft(body, "\n")}, nil
+}
+
+// normalizeLang приводит "ru-RU,ru;q=0.9" из Accept-Language к "ru".
+func normalizeLang(lang string) string {
+	lang, _, _ = strings.Cut(lang, ",")
+	lang, _, _ = strings.Cut(lang, ";")
+	lang, _, _ = strings.Cut(lang, "-")
+
+	return strings.ToLower(strings.TrimSpace(lang))
+}
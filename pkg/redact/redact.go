@@ -0,0 +1,184 @@
+// Package redact убирает секреты и персональные данные из того, что пишется в логи.
+//
+// Секретные поля структур помечаются тегом `secret:"true"`, такие поля заменяются
+// на Mask целиком. В строках маскируются email и номера карт.
+package redact
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const Mask = "[redacted]"
+
+var (
+	emailRe = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// 13-19 цифр, между группами допускаются пробел или дефис
+	cardRe = regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`)
+)
+
+// String маскирует email (остаётся первая буква и домен) и номера карт, проходящие проверку Луна
+// (остаются последние 4 цифры).
+func String(s string) string {
+	s = emailRe.ReplaceAllStringFunc(s, maskEmail)
+	s = cardRe.ReplaceAllStringFunc(s, maskCard)
+	return s
+}
+
+func maskEmail(email string) string {
+	local, domain, _ := strings.Cut(email, "@")
+	return local[:1] + "***@" + domain
+}
+
+func maskCard(s string) string {
+	digits := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			digits = append(digits, s[i])
+		}
+	}
+
+	// длинные числа без контрольной суммы (id, суммы в копейках) не трогаем
+	if !luhn(digits) {
+		return s
+	}
+
+	return "****" + string(digits[len(digits)-4:])
+}
+
+func luhn(digits []byte) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+// Value возвращает копию v в виде map/slice/скаляров, где поля с тегом secret:"true"
+// заменены на Mask, а строки прошли через String. Ключи map берутся из тега yaml или json,
+// если его нет - имя поля.
+func Value(v any) any {
+	return value(reflect.ValueOf(v), true)
+}
+
+// Secrets делает то же, что Value, но не трогает обычные строки:
+// подходит для дампа конфига, где email и адреса - это настройки, а не персональные данные.
+func Secrets(v any) any {
+	return value(reflect.ValueOf(v), false)
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+func value(v reflect.Value, pii bool) any {
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return value(v.Elem(), pii)
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface()
+		}
+		return structValue(v, pii)
+	case reflect.Map:
+		out := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[keyString(iter.Key())] = value(iter.Value(), pii)
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = value(v.Index(i), pii)
+		}
+		return out
+	case reflect.String:
+		if pii {
+			return String(v.String())
+		}
+		return v.String()
+	}
+
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.CanInterface() {
+		return v.Interface()
+	}
+
+	return nil
+}
+
+func structValue(v reflect.Value, pii bool) map[string]any {
+	t := v.Type()
+	out := make(map[string]any, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := fieldName(f)
+		if name == "-" {
+			continue
+		}
+
+		if f.Tag.Get("secret") == "true" {
+			// пустое значение не скрываем, так видно что секрет просто не задан
+			if v.Field(i).IsZero() {
+				out[name] = ""
+			} else {
+				out[name] = Mask
+			}
+			continue
+		}
+
+		out[name] = value(v.Field(i), pii)
+	}
+
+	return out
+}
+
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"yaml", "json"} {
+		if name, _, _ := strings.Cut(f.Tag.Get(tag), ","); name != "" {
+			return name
+		}
+	}
+
+	return f.Name
+}
+
+func keyString(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+
+	return fmt.Sprint(k.Interface())
+}
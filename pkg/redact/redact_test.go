@@ -0,0 +1,54 @@
+package redact
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestString(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "email", in: `user "dima@example.com" not found`, want: `user "d***@example.com" not found`},
+		{name: "card with spaces", in: "card 4111 1111 1111 1111 declined", want: "card ****1111 declined"},
+		{name: "card plain", in: "5500005555555559", want: "****5559"},
+		{name: "not a card", in: "order 1234567890123", want: "order 1234567890123"},
+		{name: "nothing to mask", in: "user 42 updated", want: "user 42 updated"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, String(tc.in))
+		})
+	}
+}
+
+type db struct {
+	Host     string `yaml:"host"`
+	Password string `yaml:"password" secret:"true"`
+	Token    string `secret:"true"`
+}
+
+type cfg struct {
+	DB      db            `yaml:"database"`
+	Admin   string        `yaml:"admin"`
+	Timeout time.Duration `yaml:"timeout"`
+	hidden  string
+}
+
+func TestValue(t *testing.T) {
+	c := &cfg{DB: db{Host: "localhost", Password: "qwerty"}, Admin: "admin@example.com", Timeout: time.Second, hidden: "x"}
+
+	require.Equal(t, map[string]any{
+		"database": map[string]any{"host": "localhost", "password": Mask, "Token": ""},
+		"admin":    "a***@example.com",
+		"timeout":  "1s",
+	}, Value(c))
+
+	// в Secrets обычные строки не маскируются
+	require.Equal(t, "admin@example.com", Secrets(c).(map[string]any)["admin"])
+}